@@ -0,0 +1,138 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/diodechain/diode_go_client/db"
+)
+
+const (
+	apiCertKey        = "api_tls_cert"
+	apiCertPrivKey    = "api_tls_key"
+	staticCertKey     = "static_tls_cert"
+	staticCertPrivKey = "static_tls_key"
+	apiCertValidity   = 10 * 365 * 24 * time.Hour
+)
+
+// apiTLSConfig returns the tls config of the config api, the self-signed
+// certificate is generated once and stored in the database
+func apiTLSConfig(addr string) (*tls.Config, error) {
+	return selfSignedTLSConfig("API certificate", apiCertKey, apiCertPrivKey, addr)
+}
+
+// staticTLSConfig returns the tls config of the static file server when no
+// certificate files are given
+func staticTLSConfig(addr string) (*tls.Config, error) {
+	return selfSignedTLSConfig("HTTP certificate", staticCertKey, staticCertPrivKey, addr)
+}
+
+// selfSignedTLSConfig returns a tls config with the self-signed certificate
+// of the database keys, it's generated when missing
+func selfSignedTLSConfig(label string, certKey string, privKey string, addr string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if len(host) == 0 {
+		host = "localhost"
+	}
+	cert, err := loadCertificate(certKey, privKey, host)
+	if err != nil {
+		cert, err = generateCertificate(certKey, privKey, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fingerprint := sha256.Sum256(cert.Certificate[0])
+	printLabel(label, "sha256:"+hex.EncodeToString(fingerprint[:]))
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func loadCertificate(certKey string, privKey string, host string) (cert tls.Certificate, err error) {
+	certPEM, err := db.DB.Get(certKey)
+	if err != nil {
+		return
+	}
+	keyPEM, err := db.DB.Get(privKey)
+	if err != nil {
+		return
+	}
+	cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return
+	}
+	if time.Now().After(leaf.NotAfter) {
+		err = x509.CertificateInvalidError{Cert: leaf, Reason: x509.Expired}
+		return
+	}
+	// the listening host might have changed
+	err = leaf.VerifyHostname(host)
+	return
+}
+
+func generateCertificate(certKey string, privKey string, host string) (cert tls.Certificate, err error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Diode Network Client"}, CommonName: host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(apiCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else if host != "localhost" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		return
+	}
+	privDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privDER})
+	err = db.DB.Put(certKey, certPEM)
+	if err != nil {
+		return
+	}
+	err = db.DB.Put(privKey, keyPEM)
+	if err != nil {
+		return
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"math/rand"
 	"net"
@@ -29,7 +30,6 @@ var (
 	diodeCmd = command.Command{
 		Name:     "diode",
 		HelpText: " Diode network command line interface",
-		PreRun:   prepareDiode,
 		PostRun:  cleanDiode,
 	}
 	bootDiodeAddrs = [6]string{
@@ -45,6 +45,7 @@ var (
 func init() {
 	cfg := &config.Config{}
 	diodeCmd.Flag.StringVar(&cfg.DBPath, "dbpath", util.DefaultDBPath(), "file path to db file")
+	diodeCmd.Flag.StringVar(&cfg.Profile, "profile", "", "name of the identity profile to use (default: the one selected with 'diode profile use')")
 	diodeCmd.Flag.IntVar(&cfg.RetryTimes, "retrytimes", 3, "retry times to connect the remote rpc server")
 	diodeCmd.Flag.BoolVar(&cfg.EnableEdgeE2E, "e2e", true, "enable edge e2e when start diode")
 	// should put to httpd or other command
@@ -53,6 +54,9 @@ func init() {
 	diodeCmd.Flag.BoolVar(&cfg.Debug, "debug", false, "turn on debug mode")
 	diodeCmd.Flag.BoolVar(&cfg.EnableAPIServer, "api", false, "turn on the config api")
 	diodeCmd.Flag.StringVar(&cfg.APIServerAddr, "apiaddr", "localhost:1081", "define config api server address")
+	diodeCmd.Flag.BoolVar(&cfg.APIServerAuth, "apiauth", false, "require a bearer token for the config api, see 'diode token'")
+	diodeCmd.Flag.BoolVar(&cfg.APIServerTLS, "apitls", false, "serve the config api over https with a self-signed certificate")
+	diodeCmd.Flag.StringVar(&cfg.APIServerSocket, "apisocket", "", "unix socket path of the config api, access is controlled by the file permissions")
 	diodeCmd.Flag.IntVar(&cfg.RlimitNofile, "rlimit_nofile", 0, "specify the file descriptor numbers that can be opened by this process")
 	diodeCmd.Flag.StringVar(&cfg.LogFilePath, "logfilepath", "", "file path to log file")
 	diodeCmd.Flag.BoolVar(&cfg.LogDateTime, "logdatetime", false, "show the date time in log")
@@ -87,13 +91,21 @@ func init() {
 		cfg.LogMode = config.LogToConsole
 	}
 	config.AppConfig = cfg
+	diodeCmd.PreRun = prepareDiode
 	diodeCmd.AddSubCommand(bnsCmd)
 	diodeCmd.AddSubCommand(configCmd)
+	diodeCmd.AddSubCommand(ctlCmd)
+	diodeCmd.AddSubCommand(dbCmd)
 	diodeCmd.AddSubCommand(gatewayCmd)
+	diodeCmd.AddSubCommand(grantCmd)
+	diodeCmd.AddSubCommand(profileCmd)
 	diodeCmd.AddSubCommand(publishCmd)
 	diodeCmd.AddSubCommand(resetCmd)
 	diodeCmd.AddSubCommand(socksdCmd)
+	diodeCmd.AddSubCommand(socksRouteCmd)
+	diodeCmd.AddSubCommand(socksUserCmd)
 	diodeCmd.AddSubCommand(timeCmd)
+	diodeCmd.AddSubCommand(tokenCmd)
 	diodeCmd.AddSubCommand(versionCmd)
 }
 
@@ -102,6 +114,22 @@ func prepareDiode() error {
 	// initialize logger
 	pool = rpc.NewPool()
 
+	// flags and DIODE_* environment variables take precedence over the
+	// config file, the errors are returned after the logger was initialized
+	flagSets := []*flag.FlagSet{&diodeCmd.Flag}
+	if subCmd := diodeCmd.SubCommand(); subCmd != nil {
+		flagSets = append(flagSets, &subCmd.Flag)
+	}
+	overrides, overridesErr := config.NewOverrides(cfg, flagSets...)
+	if overridesErr == nil {
+		overrides.Apply(cfg)
+	}
+
+	// select the profile before loading the file config
+	profileErr := applyProfile(cfg)
+	// keep the flag values, config reloads apply the file on top of them
+	flagConfig := *cfg
+
 	// load file config
 	if len(cfg.ConfigFilePath) > 0 {
 		cfgByts, err := config.LoadConfigFromFile(cfg.ConfigFilePath)
@@ -112,6 +140,9 @@ func prepareDiode() error {
 			}
 		}
 	}
+	if overridesErr == nil {
+		overrides.Apply(cfg)
+	}
 
 	logger, err := config.NewLogger(cfg)
 	if err != nil {
@@ -119,6 +150,12 @@ func prepareDiode() error {
 	}
 	// should not copy lock
 	cfg.Logger = &logger
+	if overridesErr != nil {
+		return overridesErr
+	}
+	if profileErr != nil {
+		return profileErr
+	}
 
 	printLabel("Diode Client version", fmt.Sprintf("%s %s", version, buildTime))
 
@@ -143,23 +180,65 @@ func prepareDiode() error {
 		cfg.RemoteRPCAddrs[i], cfg.RemoteRPCAddrs[j] = cfg.RemoteRPCAddrs[j], cfg.RemoteRPCAddrs[i]
 	})
 
-	cfg.Binds = make([]config.Bind, 0)
-	for _, str := range cfg.SBinds {
-		bind, err := parseBind(str)
-		if err != nil {
-			return err
-		}
-		cfg.Binds = append(cfg.Binds, *bind)
+	cfg.Binds, err = parseBinds(cfg.SBinds)
+	if err != nil {
+		return err
 	}
+	cfg.Blocklists, err = parseAccessList(cfg.SBlocklists)
+	if err != nil {
+		return err
+	}
+	cfg.Allowlists, err = parseAccessList(cfg.SAllowlists)
+	if err != nil {
+		return err
+	}
+	pool.SetAccessLists(cfg.Blocklists, cfg.Allowlists)
 
 	// initialize diode application
 	app = NewDiode(cfg, pool)
+	app.flagConfig = flagConfig
+	app.overrides = overrides
 	if err := app.Init(); err != nil {
 		return err
 	}
 	return nil
 }
 
+// applyProfile points the database and config file to the selected profile,
+// explicit -dbpath and -configpath flags take precedence
+func applyProfile(cfg *config.Config) error {
+	if len(cfg.Profile) == 0 {
+		cfg.Profile = config.ActiveProfile()
+	} else if !config.IsValidProfileName(cfg.Profile) {
+		return config.ErrInvalidProfileName
+	} else if !config.ProfileExists(cfg.Profile) {
+		return fmt.Errorf("%v: %s (create it with 'diode profile create %s')", config.ErrProfileNotFound, cfg.Profile, cfg.Profile)
+	}
+	if cfg.DBPath == util.DefaultDBPath() {
+		cfg.DBPath = config.ProfileDBPath(cfg.Profile)
+	}
+	if len(cfg.ConfigFilePath) == 0 {
+		configPath := config.ProfileConfigPath(cfg.Profile)
+		if _, err := os.Stat(configPath); err == nil {
+			cfg.ConfigFilePath = configPath
+		}
+	}
+	return nil
+}
+
+// parseAccessList decodes the device addresses of a block or allow list
+func parseAccessList(addrs []string) (map[util.Address]bool, error) {
+	ret := make(map[util.Address]bool, len(addrs))
+	for _, str := range addrs {
+		addr, err := util.DecodeAddress(str)
+		if err != nil {
+			return nil, fmt.Errorf("access list expected device address but got: %v", str)
+		}
+		ret[addr] = true
+	}
+	return ret, nil
+}
+
 func isValidRPCAddress(address string) (isValid bool) {
 	_, _, err := net.SplitHostPort(address)
 	if err == nil {
@@ -185,6 +264,16 @@ type Diode struct {
 	deferals        []func()
 	closeCh         chan struct{}
 	cmd             *command.Command
+	flagConfig      config.Config
+	overrides       *config.Overrides
+	// reloadMx guards the config against config reloads, the config api
+	// locks it to read or change the config
+	reloadMx sync.Mutex
+	// publishedPorts are the ports as configured, the bns names of their
+	// allowlists are resolved in bnsNames
+	publishedPorts map[int]*config.Port
+	bnsNames       map[string]util.Address
+	grants         *grantWatcher
 }
 
 // NewDiode return diode application
@@ -201,13 +290,27 @@ func (dio *Diode) Init() error {
 	// Connect to first server to respond, and keep the other connections opened
 	cfg := dio.config
 
-	// Initialize db
+	// the db command verifies and restores the raw file, opening the
+	// database would repair it before
+	subCmd := diodeCmd.SubCommand()
+	if subCmd == dbCmd {
+		return nil
+	}
+
+	// Initialize db, daemons wait for short commands that hold the database
+	if subCmd != nil && subCmd.Type == command.DaemonCommand {
+		db.LockWait = 5 * time.Second
+	}
 	clidb, err := db.OpenFile(cfg.DBPath)
 	if err != nil {
 		printError("Couldn't open database", err)
+		printInfo("Check the database with 'diode db verify' or restore a backup with 'diode db restore <file>'")
 		return err
 	}
 	db.DB = clidb
+	if clidb.ReadOnly() && subCmd != nil && subCmd.Type == command.DaemonCommand {
+		printInfo("The database is used by another diode process, changes of this process are not saved")
+	}
 
 	if version != "development" && cfg.EnableUpdate {
 		var lastUpdateAtByt []byte
@@ -328,6 +431,15 @@ func (dio *Diode) Init() error {
 				copy(cfg.FleetAddr[:], fleetAddr)
 			}
 		}
+		// the fleet of the environment takes precedence over the database
+		if fleet, ok := config.LookupEnv("fleet"); ok {
+			cfg.FleetAddr, err = util.DecodeAddress(fleet)
+			if err != nil {
+				err = fmt.Errorf("invalid %s: %v", config.EnvName("fleet"), err)
+				printError("Couldn't load fleet", err)
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -409,35 +521,9 @@ func (dio *Diode) waitForFirstClient(onlyNeedOne bool) (client *rpc.RPCClient) {
 				cfg.Logger.Info(fmt.Sprintf("Adding host: %s [%d/%d]", rpcClient.Host(), i, rpcAddrLen))
 				i = i + 1
 			}
-			isValid, err := rpcClient.ValidateNetwork()
-			if isValid {
-				serverID, err := rpcClient.GetServerID()
-				if err != nil {
-					cfg.Logger.Warn("Failed to get server id: %v from %s", err, rpcClient.Host())
-					rpcClient.Close()
-					continue
-				}
-				err = rpcClient.Greet()
-				if err != nil {
-					cfg.Logger.Warn("Failed to ubmitTicket to server: %v from %s", err, rpcClient.Host())
-				}
-				dio.datapool.SetClient(serverID, rpcClient)
-				if client == nil {
-					client = rpcClient
-					wg.Done()
-				}
-				rpcClient.SetCloseCB(func() {
-					dio.datapool.SetClient(serverID, nil)
-				})
-			} else {
-				if verbose {
-					if err != nil {
-						cfg.Logger.Error(fmt.Sprintf("Network of %s is not valid (err: %s), trying next...", rpcClient.Host(), err.Error()))
-					} else {
-						cfg.Logger.Error("Network of %s is not valid for unknown reasons", rpcClient.Host())
-					}
-				}
-				rpcClient.Close()
+			if dio.addClient(rpcClient, verbose) && client == nil {
+				client = rpcClient
+				wg.Done()
 			}
 		}
 		close(c)
@@ -453,6 +539,38 @@ func (dio *Diode) waitForFirstClient(onlyNeedOne bool) (client *rpc.RPCClient) {
 	return
 }
 
+// addClient validates the network of the given client and adds it to the pool
+func (dio *Diode) addClient(rpcClient *rpc.RPCClient, verbose bool) bool {
+	cfg := dio.config
+	isValid, err := rpcClient.ValidateNetwork()
+	if !isValid {
+		if verbose {
+			if err != nil {
+				cfg.Logger.Error(fmt.Sprintf("Network of %s is not valid (err: %s), trying next...", rpcClient.Host(), err.Error()))
+			} else {
+				cfg.Logger.Error("Network of %s is not valid for unknown reasons", rpcClient.Host())
+			}
+		}
+		rpcClient.Close()
+		return false
+	}
+	serverID, err := rpcClient.GetServerID()
+	if err != nil {
+		cfg.Logger.Warn("Failed to get server id: %v from %s", err, rpcClient.Host())
+		rpcClient.Close()
+		return false
+	}
+	err = rpcClient.Greet()
+	if err != nil {
+		cfg.Logger.Warn("Failed to ubmitTicket to server: %v from %s", err, rpcClient.Host())
+	}
+	dio.datapool.SetClient(serverID, rpcClient)
+	rpcClient.SetCloseCB(func() {
+		dio.datapool.SetClient(serverID, nil)
+	})
+	return true
+}
+
 // SetSocksServer set socks server of diode application
 // TODO: close unused socks server?
 func (dio *Diode) SetSocksServer(socksServer *rpc.Server) {
@@ -499,6 +617,7 @@ func (dio *Diode) PublishPorts() {
 
 // Wait till user signal int to diode application
 func (dio *Diode) Wait() {
+	dio.WatchConfig()
 	go func() {
 		// listen to signal
 		sigChan := make(chan os.Signal, 1)
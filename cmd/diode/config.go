@@ -42,6 +42,15 @@ func configHandler() (err error) {
 	}
 	cfg := config.AppConfig
 	activity := false
+	if len(cfg.ConfigDelete) > 0 || len(cfg.ConfigSet) > 0 {
+		var backup string
+		backup, err = db.DB.AutoBackup()
+		if err != nil {
+			printError("Couldn't backup database", err)
+			return
+		}
+		printLabel("Backup", backup)
+	}
 	if len(cfg.ConfigDelete) > 0 {
 		activity = true
 		for _, deleteKey := range cfg.ConfigDelete {
@@ -0,0 +1,239 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/diodechain/diode_go_client/rpc"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// eventKeepAlive is how often an idle event stream is pinged
+	eventKeepAlive = 15 * time.Second
+	eventWriteWait = 10 * time.Second
+	// eventTicketTTL is how long a ticket of POST /events/ticket can be
+	// used to open an event stream
+	eventTicketTTL = 30 * time.Second
+)
+
+var (
+	eventUpgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 4096,
+	}
+)
+
+// eventTicket allows to open one event stream without a token
+type eventTicket struct {
+	scope   string
+	expires time.Time
+}
+
+// isEventStream returns true for the event stream endpoints, browsers can't
+// set headers for EventSource and WebSocket requests
+func isEventStream(req *http.Request) bool {
+	return req.URL.Path == "/events" || strings.HasPrefix(req.URL.Path, "/events/")
+}
+
+// newEventTicket returns a ticket with the given scope that expires after
+// eventTicketTTL
+func (configAPIServer *ConfigAPIServer) newEventTicket(scope string) (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+	now := time.Now()
+	configAPIServer.tm.Lock()
+	defer configAPIServer.tm.Unlock()
+	for key, ticket := range configAPIServer.eventTickets {
+		if now.After(ticket.expires) {
+			delete(configAPIServer.eventTickets, key)
+		}
+	}
+	configAPIServer.eventTickets[id] = eventTicket{
+		scope:   scope,
+		expires: now.Add(eventTicketTTL),
+	}
+	return id, nil
+}
+
+// useEventTicket returns the scope of the ticket, a ticket can only be used
+// once
+func (configAPIServer *ConfigAPIServer) useEventTicket(id string) (string, bool) {
+	configAPIServer.tm.Lock()
+	defer configAPIServer.tm.Unlock()
+	ticket, ok := configAPIServer.eventTickets[id]
+	if !ok {
+		return "", false
+	}
+	delete(configAPIServer.eventTickets, id)
+	if time.Now().After(ticket.expires) {
+		return "", false
+	}
+	return ticket.scope, true
+}
+
+// parseEventTypes returns the event types of the comma separated types
+// query parameter
+func parseEventTypes(req *http.Request) ([]string, error) {
+	types := []string{}
+	for _, typ := range strings.Split(req.URL.Query().Get("types"), ",") {
+		typ = strings.TrimSpace(typ)
+		if len(typ) == 0 {
+			continue
+		}
+		if !rpc.IsEventType(typ) {
+			return nil, fmt.Errorf("unknown event type %s, valid types are %s", typ, strings.Join(rpc.EventTypes, ","))
+		}
+		types = append(types, typ)
+	}
+	return types, nil
+}
+
+// eventsHandleFunc streams client events as server-sent events on /events
+// and over a websocket on /events/ws, POST /events/ticket returns a ticket
+// for clients that can't set the authorization header
+func (configAPIServer *ConfigAPIServer) eventsHandleFunc() func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		// errors are still json, the event stream sets its own content type
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if req.Method == "POST" && req.URL.Path == "/events/ticket" {
+			configAPIServer.eventTicketHandler(w, req)
+			return
+		}
+		if req.Method != "GET" {
+			configAPIServer.notFoundError(w)
+			return
+		}
+		if !configAPIServer.authorize(w, req, apiScopeRead) {
+			return
+		}
+		types, err := parseEventTypes(req)
+		if err != nil {
+			configAPIServer.clientError(w, map[string]string{"types": err.Error()})
+			return
+		}
+		switch strings.TrimSuffix(req.URL.Path, "/") {
+		case "/events":
+			configAPIServer.serveSSE(w, req, types)
+		case "/events/ws":
+			configAPIServer.serveWebSocket(w, req, types)
+		default:
+			configAPIServer.notFoundError(w)
+		}
+	}
+}
+
+// eventTicketHandler returns a ticket with the scope of the token
+func (configAPIServer *ConfigAPIServer) eventTicketHandler(w http.ResponseWriter, req *http.Request) {
+	if !configAPIServer.authorize(w, req, apiScopeRead) {
+		return
+	}
+	scope := apiScopeRead
+	if granted, ok := apiTokenScope(bearerToken(req)); ok {
+		scope = granted
+	}
+	ticket, err := configAPIServer.newEventTicket(scope)
+	if err != nil {
+		configAPIServer.appConfig.Logger.Error(fmt.Sprintf("Couldn't create event ticket: %s", err.Error()))
+		configAPIServer.serverError(w)
+		return
+	}
+	configAPIServer.statusResponse(w, map[string]interface{}{
+		"ticket":  ticket,
+		"expires": time.Now().Add(eventTicketTTL).Format(time.RFC3339),
+	})
+}
+
+func (configAPIServer *ConfigAPIServer) serveSSE(w http.ResponseWriter, req *http.Request, types []string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		configAPIServer.serverError(w)
+		return
+	}
+	sub := app.datapool.Events().Subscribe(types...)
+	defer sub.Close()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-configAPIServer.closeCh:
+			return
+		case <-req.Context().Done():
+			return
+		case <-ticker.C:
+			_, err := fmt.Fprint(w, ": ping\n\n")
+			if err != nil {
+				return
+			}
+		case event := <-sub.Events():
+			data, err := json.Marshal(event)
+			if err != nil {
+				configAPIServer.appConfig.Logger.Error(fmt.Sprintf("Couldn't encode event: %s", err.Error()))
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			if err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func (configAPIServer *ConfigAPIServer) serveWebSocket(w http.ResponseWriter, req *http.Request, types []string) {
+	conn, err := eventUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// Upgrade already wrote the error response
+		return
+	}
+	defer conn.Close()
+	sub := app.datapool.Events().Subscribe(types...)
+	defer sub.Close()
+	// the client doesn't send anything, reading is needed to process
+	// control frames and to notice a closed connection
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-configAPIServer.closeCh:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(eventWriteWait))
+			return
+		case <-closed:
+			return
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventWriteWait))
+		case event := <-sub.Events():
+			conn.SetWriteDeadline(time.Now().Add(eventWriteWait))
+			err = conn.WriteJSON(event)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -7,9 +7,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"reflect"
 	"runtime"
 	"strings"
 	"sync"
@@ -33,16 +35,17 @@ type apiResponse struct {
 }
 
 type configEntry struct {
-	Address              string `json:"client"`
-	Fleet                string `json:"fleet"`
-	Version              string `json:"version"`
-	LastValidBlockNumber uint64 `json:"lastValidBlockNumber"`
-	LastValidBlockHash   string `json:"lastValidBlockHash"`
-	Binds                []bind `json:"binds"`
-	Ports                []port `json:"ports"`
-	EnableSocks          bool   `json:"enableSocks"`
-	EnableProxy          bool   `json:"enableProxy"`
-	EnableSecureProxy    bool   `json:"enableSecureProxy"`
+	Address              string        `json:"client"`
+	Fleet                string        `json:"fleet"`
+	Version              string        `json:"version"`
+	LastValidBlockNumber uint64        `json:"lastValidBlockNumber"`
+	LastValidBlockHash   string        `json:"lastValidBlockHash"`
+	Binds                []bind        `json:"binds"`
+	Ports                []port        `json:"ports"`
+	VirtualHosts         []virtualHost `json:"virtualHosts"`
+	EnableSocks          bool          `json:"enableSocks"`
+	EnableProxy          bool          `json:"enableProxy"`
+	EnableSecureProxy    bool          `json:"enableSecureProxy"`
 }
 
 type bind struct {
@@ -60,6 +63,12 @@ type port struct {
 	Addresses  []string `json:"addresses,omitempty" validate:"dive,omitempty,address"`
 }
 
+type virtualHost struct {
+	Port   int    `json:"port" validate:"required,port"`
+	Host   string `json:"host" validate:"required"`
+	Target string `json:"target" validate:"required"`
+}
+
 type putConfigRequest struct {
 	Fleet      string   `json:"fleet,omitempty" validate:"omitempty,address"`
 	Registry   string   `json:"registry,omitempty" validate:"omitempty,address"`
@@ -68,6 +77,8 @@ type putConfigRequest struct {
 	Allowlists []string `json:"allowlists,omitempty" validate:"dive,omitempty,address"`
 	Binds      []bind   `json:"binds,omitempty" validate:"dive,omitempty"`
 	Ports      []port   `json:"ports,omitempty" validate:"dive,omitempty"`
+	// VirtualHosts replace the virtual hosts when given
+	VirtualHosts []virtualHost `json:"virtualHosts,omitempty" validate:"dive,omitempty"`
 }
 
 func isAddress(fl validator.FieldLevel) bool {
@@ -123,23 +134,34 @@ func portValidation(sl validator.StructLevel) {
 type ConfigAPIServer struct {
 	appConfig   *config.Config
 	addr        string
+	socket      string
 	corsOptions cors.Options
 	httpServer  *http.Server
+	closeCh     chan struct{}
 	cd          sync.Once
+	// eventTickets are the unused tickets of the event streams, tm guards them
+	eventTickets map[string]eventTicket
+	tm           sync.Mutex
 }
 
 // NewConfigAPIServer return ConfigAPIServer
 func NewConfigAPIServer(appConfig *config.Config) *ConfigAPIServer {
 	return &ConfigAPIServer{
-		appConfig: appConfig,
-		addr:      appConfig.APIServerAddr,
+		appConfig:    appConfig,
+		addr:         appConfig.APIServerAddr,
+		socket:       appConfig.APIServerSocket,
+		closeCh:      make(chan struct{}),
+		eventTickets: make(map[string]eventTicket),
 		corsOptions: cors.Options{
 			AllowedOrigins: []string{"http://localhost"},
 			AllowedMethods: []string{
 				http.MethodHead,
 				http.MethodGet,
+				http.MethodPost,
 				http.MethodPut,
+				http.MethodDelete,
 			},
+			AllowedHeaders:     []string{"Content-Type", "Authorization"},
 			ExposedHeaders:     []string{"content-type"},
 			AllowCredentials:   true,
 			OptionsPassthrough: false,
@@ -186,6 +208,64 @@ func (configAPIServer *ConfigAPIServer) notFoundError(w http.ResponseWriter) {
 	w.Write(res)
 }
 
+func (configAPIServer *ConfigAPIServer) unauthorizedError(w http.ResponseWriter) {
+	var response apiResponse
+	var res []byte
+	response.Success = false
+	response.Message = "unauthorized"
+	res, _ = json.Marshal(response)
+
+	w.Header().Set("WWW-Authenticate", `Bearer realm="diode"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(res)
+}
+
+func (configAPIServer *ConfigAPIServer) forbiddenError(w http.ResponseWriter) {
+	var response apiResponse
+	var res []byte
+	response.Success = false
+	response.Message = "forbidden"
+	res, _ = json.Marshal(response)
+
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(res)
+}
+
+// authorize checks that the request has a bearer token with the given scope
+// and writes the error response otherwise. Event streams can be opened with
+// a ticket of POST /events/ticket instead
+func (configAPIServer *ConfigAPIServer) authorize(w http.ResponseWriter, req *http.Request, scope string) bool {
+	if !configAPIServer.appConfig.APIServerAuth {
+		return true
+	}
+	var granted string
+	var ok bool
+	ticket := req.URL.Query().Get("ticket")
+	if len(req.Header.Get("Authorization")) == 0 && len(ticket) > 0 && req.Method == "GET" && isEventStream(req) {
+		granted, ok = configAPIServer.useEventTicket(ticket)
+	} else {
+		granted, ok = apiTokenScope(bearerToken(req))
+	}
+	if !ok {
+		configAPIServer.unauthorizedError(w)
+		return false
+	}
+	if !hasScope(granted, scope) {
+		configAPIServer.forbiddenError(w)
+		return false
+	}
+	return true
+}
+
+// bearerToken returns the token of the authorization header
+func bearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+}
+
 func (configAPIServer *ConfigAPIServer) unsupportedMediaTypeError(w http.ResponseWriter) {
 	var response apiResponse
 	var res []byte
@@ -247,6 +327,17 @@ func (configAPIServer *ConfigAPIServer) configResponse(w http.ResponseWriter, me
 				}
 				return ret
 			}(cfg.PublishedPorts),
+			VirtualHosts: func(vhosts []config.VirtualHost) []virtualHost {
+				ret := make([]virtualHost, len(vhosts))
+				for i, v := range vhosts {
+					ret[i] = virtualHost{
+						Port:   v.Port,
+						Host:   v.Host,
+						Target: v.Target,
+					}
+				}
+				return ret
+			}(cfg.VirtualHosts),
 
 			EnableSocks:       cfg.EnableSocksServer,
 			EnableProxy:       cfg.EnableProxyServer,
@@ -265,9 +356,18 @@ func (configAPIServer *ConfigAPIServer) apiHandleFunc() func(w http.ResponseWrit
 			return
 		}
 		if req.Method == "GET" {
+			if !configAPIServer.authorize(w, req, apiScopeRead) {
+				return
+			}
+			// config reloads change the config
+			app.reloadMx.Lock()
+			defer app.reloadMx.Unlock()
 			configAPIServer.configResponse(w, "ok")
 			return
 		} else if req.Method == "PUT" {
+			if !configAPIServer.authorize(w, req, apiScopeAdmin) {
+				return
+			}
 			if !configAPIServer.appConfig.LoadFromFile {
 				configAPIServer.appConfig.Logger.Error("Didn't load config file")
 				configAPIServer.serverError(w)
@@ -284,6 +384,8 @@ func (configAPIServer *ConfigAPIServer) apiHandleFunc() func(w http.ResponseWrit
 				configAPIServer.serverError(w)
 				return
 			}
+			app.reloadMx.Lock()
+			defer app.reloadMx.Unlock()
 			var isDirty bool
 			// validate put body
 			validationError := make(map[string]string)
@@ -472,6 +574,29 @@ func (configAPIServer *ConfigAPIServer) apiHandleFunc() func(w http.ResponseWrit
 					}
 				}
 			}
+			if c.VirtualHosts != nil {
+				vhosts := make([]config.VirtualHost, len(c.VirtualHosts))
+				for i, v := range c.VirtualHosts {
+					vhosts[i] = config.VirtualHost{
+						Port:   v.Port,
+						Host:   v.Host,
+						Target: v.Target,
+					}
+				}
+				// validate the virtual hosts against the published ports
+				next := *configAPIServer.appConfig
+				next.VirtualHosts = vhosts
+				_, err := parsePublishedPorts(&next)
+				if err != nil {
+					validationError["virtualhosts"] = err.Error()
+					configAPIServer.clientError(w, validationError)
+					return
+				}
+				if !reflect.DeepEqual(vhosts, configAPIServer.appConfig.VirtualHosts) {
+					isDirty = true
+					configAPIServer.appConfig.VirtualHosts = vhosts
+				}
+			}
 			if !isDirty {
 				configAPIServer.successResponse(w, "ok")
 				return
@@ -526,12 +651,19 @@ func (configAPIServer *ConfigAPIServer) rootHandleFunc() func(w http.ResponseWri
 			configAPIServer.notFoundError(w)
 			return
 		}
+		if !configAPIServer.authorize(w, req, apiScopeRead) {
+			return
+		}
 		configAPIServer.successResponse(w, "ok")
 	}
 }
 
 func (configAPIServer *ConfigAPIServer) requireJSON(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isEventStream(req) {
+			h.ServeHTTP(w, req)
+			return
+		}
 		contentType := req.Header.Get("Content-Type")
 		// set response content type to application/json
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -554,26 +686,86 @@ func (configAPIServer *ConfigAPIServer) requireJSON(h http.Handler) http.Handler
 
 // ListenAndServe start config api server
 func (configAPIServer *ConfigAPIServer) ListenAndServe() {
+	cfg := configAPIServer.appConfig
 	mux := http.NewServeMux()
 	mux.HandleFunc("/config", configAPIServer.apiHandleFunc())
+	mux.HandleFunc("/status", configAPIServer.statusHandleFunc())
+	mux.HandleFunc("/status/", configAPIServer.statusHandleFunc())
+	mux.HandleFunc("/tunnels/", configAPIServer.tunnelsHandleFunc())
+	mux.HandleFunc("/access/", configAPIServer.accessHandleFunc())
+	mux.HandleFunc("/grants", configAPIServer.grantsHandleFunc())
+	mux.HandleFunc("/grants/", configAPIServer.grantsHandleFunc())
+	mux.HandleFunc("/events", configAPIServer.eventsHandleFunc())
+	mux.HandleFunc("/events/", configAPIServer.eventsHandleFunc())
 	mux.HandleFunc("/", configAPIServer.rootHandleFunc())
 	handler := cors.New(configAPIServer.corsOptions).Handler(mux)
 	handler = configAPIServer.requireJSON(handler)
-	configAPIServer.httpServer = &http.Server{Addr: configAPIServer.addr, Handler: handler}
-	configAPIServer.appConfig.Logger.Info(fmt.Sprintf("Start config api server %s", configAPIServer.addr))
-	go func() {
-		if err := configAPIServer.httpServer.ListenAndServe(); err != nil {
-			configAPIServer.httpServer = nil
-			if err != http.ErrServerClosed {
-				configAPIServer.appConfig.Logger.Info(fmt.Sprintf("Couldn't start config api: %s", err.Error()))
+	httpServer := &http.Server{Addr: configAPIServer.addr, Handler: handler}
+	configAPIServer.httpServer = httpServer
+	if cfg.APIServerAuth && len(apiTokens()) == 0 {
+		cfg.Logger.Warn(apiAuthHint)
+	}
+	if len(configAPIServer.addr) > 0 {
+		var err error
+		scheme := "http"
+		if cfg.APIServerTLS {
+			scheme = "https"
+			httpServer.TLSConfig, err = apiTLSConfig(configAPIServer.addr)
+			if err != nil {
+				cfg.Logger.Error(fmt.Sprintf("Couldn't create config api certificate: %s", err.Error()))
+				return
+			}
+		}
+		cfg.Logger.Info(fmt.Sprintf("Start config api server %s://%s", scheme, configAPIServer.addr))
+		go func() {
+			if cfg.APIServerTLS {
+				err = httpServer.ListenAndServeTLS("", "")
+			} else {
+				err = httpServer.ListenAndServe()
 			}
+			if err != nil && err != http.ErrServerClosed {
+				cfg.Logger.Info(fmt.Sprintf("Couldn't start config api: %s", err.Error()))
+			}
+		}()
+	}
+	if len(configAPIServer.socket) > 0 {
+		listener, err := listenUnixSocket(configAPIServer.socket)
+		if err != nil {
+			cfg.Logger.Error(fmt.Sprintf("Couldn't start config api on %s: %s", configAPIServer.socket, err.Error()))
+			return
 		}
-	}()
+		cfg.Logger.Info(fmt.Sprintf("Start config api server unix://%s", configAPIServer.socket))
+		go func() {
+			err := httpServer.Serve(listener)
+			if err != nil && err != http.ErrServerClosed {
+				cfg.Logger.Info(fmt.Sprintf("Couldn't start config api: %s", err.Error()))
+			}
+		}()
+	}
+}
+
+// listenUnixSocket listens on the given path, a stale socket of a previous
+// run is removed and the socket is only accessible by the current user
+func listenUnixSocket(path string) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
 }
 
 // Close config api server
 func (configAPIServer *ConfigAPIServer) Close() {
 	configAPIServer.cd.Do(func() {
+		close(configAPIServer.closeCh)
 		if configAPIServer.httpServer != nil {
 			configAPIServer.httpServer.Close()
 		}
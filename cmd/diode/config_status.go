@@ -0,0 +1,301 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/rpc"
+	"github.com/diodechain/diode_go_client/util"
+)
+
+type nodeEntry struct {
+	Host                 string `json:"host"`
+	ServerID             string `json:"serverID"`
+	Order                int    `json:"order"`
+	Reconnecting         bool   `json:"reconnecting"`
+	LastValidBlockNumber uint64 `json:"lastValidBlockNumber"`
+	LastValidBlockHash   string `json:"lastValidBlockHash"`
+}
+
+type tunnelEntry struct {
+	Ref           string `json:"ref"`
+	Direction     string `json:"direction"`
+	Device        string `json:"device"`
+	ServerID      string `json:"serverID"`
+	Port          int    `json:"port"`
+	LocalPort     int    `json:"localPort,omitempty"`
+	Target        string `json:"target,omitempty"`
+	Protocol      string `json:"protocol"`
+	Client        string `json:"client"`
+	Since         string `json:"since"`
+	BytesSent     uint64 `json:"bytesSent"`
+	BytesReceived uint64 `json:"bytesReceived"`
+}
+
+type bindEntry struct {
+	bind
+	Listening bool   `json:"listening"`
+	Error     string `json:"error,omitempty"`
+}
+
+type bnsCacheEntry struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+type deviceCacheEntry struct {
+	Device           string `json:"device"`
+	ServerID         string `json:"serverID"`
+	Fleet            string `json:"fleet"`
+	BlockNumber      uint64 `json:"blockNumber"`
+	TotalConnections uint64 `json:"totalConnections"`
+	TotalBytes       uint64 `json:"totalBytes"`
+}
+
+type fleetCacheEntry struct {
+	Fleet       string `json:"fleet"`
+	Device      string `json:"device"`
+	Allowed     bool   `json:"allowed"`
+	BlockNumber uint64 `json:"blockNumber"`
+}
+
+type fleetCacheStatsEntry struct {
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	Expired uint64  `json:"expired"`
+	Entries int     `json:"entries"`
+	HitRate float64 `json:"hitRate"`
+}
+
+type cacheEntry struct {
+	BNS        []bnsCacheEntry      `json:"bns"`
+	Devices    []deviceCacheEntry   `json:"devices"`
+	Fleet      []fleetCacheEntry    `json:"fleet"`
+	FleetStats fleetCacheStatsEntry `json:"fleetStats"`
+}
+
+type healthEntry struct {
+	Port      int    `json:"port"`
+	Healthy   bool   `json:"healthy"`
+	CheckedAt string `json:"checkedAt"`
+	Error     string `json:"error,omitempty"`
+}
+
+type statusEntry struct {
+	Nodes   []nodeEntry   `json:"nodes"`
+	Tunnels []tunnelEntry `json:"tunnels"`
+	Binds   []bindEntry   `json:"binds"`
+	Cache   cacheEntry    `json:"cache"`
+	Access  accessEntry   `json:"access"`
+	Health  []healthEntry `json:"health"`
+}
+
+type statusResponse struct {
+	Success bool        `json:"success"`
+	Message string      `json:"message"`
+	Status  interface{} `json:"status"`
+}
+
+func nodesStatus(pool *rpc.DataPool) []nodeEntry {
+	clients := pool.GetClients()
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Order < clients[j].Order
+	})
+	nodes := make([]nodeEntry, 0, len(clients))
+	for _, client := range clients {
+		node := nodeEntry{
+			Host:         client.Host(),
+			Order:        client.Order,
+			Reconnecting: client.Reconnecting(),
+		}
+		if serverID, err := client.GetServerID(); err == nil {
+			node.ServerID = util.EncodeToString(serverID[:])
+		}
+		lvbn, lvbh := client.LastValid()
+		node.LastValidBlockNumber = lvbn
+		node.LastValidBlockHash = util.EncodeToString(lvbh[:])
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func tunnelsStatus(pool *rpc.DataPool) []tunnelEntry {
+	devices := pool.GetDevices()
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].CreatedAt.Before(devices[j].CreatedAt)
+	})
+	tunnels := make([]tunnelEntry, 0, len(devices))
+	for _, device := range devices {
+		tunnel := tunnelEntry{
+			Ref:           util.EncodeToString([]byte(device.Ref)),
+			Direction:     "outbound",
+			Device:        device.DeviceID.HexString(),
+			Port:          device.PortNumber,
+			LocalPort:     device.SrcPortNumber,
+			Target:        device.SrcAddr,
+			Protocol:      config.ProtocolName(device.Protocol),
+			Client:        device.ClientID,
+			Since:         device.CreatedAt.Format(time.RFC3339),
+			BytesSent:     device.BytesSent(),
+			BytesReceived: device.BytesReceived(),
+		}
+		// only published ports have a target
+		if len(device.SrcAddr) > 0 {
+			tunnel.Direction = "inbound"
+		}
+		if serverID, err := device.Client.GetServerID(); err == nil {
+			tunnel.ServerID = util.EncodeToString(serverID[:])
+		}
+		tunnels = append(tunnels, tunnel)
+	}
+	return tunnels
+}
+
+func bindsStatus(socksServer *rpc.Server) []bindEntry {
+	binds := []bindEntry{}
+	if socksServer == nil {
+		return binds
+	}
+	for _, status := range socksServer.Binds() {
+		entry := bindEntry{
+			bind: bind{
+				LocalPort:  status.Bind.LocalPort,
+				Remote:     status.Bind.To,
+				RemotePort: status.Bind.ToPort,
+				Protocol:   config.ProtocolName(status.Bind.Protocol),
+			},
+			Listening: status.Listening,
+		}
+		if status.Err != nil {
+			entry.Error = status.Err.Error()
+		}
+		binds = append(binds, entry)
+	}
+	return binds
+}
+
+func cacheStatus(pool *rpc.DataPool) cacheEntry {
+	cache := cacheEntry{
+		BNS:     []bnsCacheEntry{},
+		Devices: []deviceCacheEntry{},
+		Fleet:   []fleetCacheEntry{},
+	}
+	for name, addr := range pool.GetCacheBNSItems() {
+		cache.BNS = append(cache.BNS, bnsCacheEntry{Name: name, Address: addr.HexString()})
+	}
+	sort.Slice(cache.BNS, func(i, j int) bool {
+		return cache.BNS[i].Name < cache.BNS[j].Name
+	})
+	for deviceID, tck := range pool.GetCacheDeviceItems() {
+		cache.Devices = append(cache.Devices, deviceCacheEntry{
+			Device:           deviceID.HexString(),
+			ServerID:         tck.ServerID.HexString(),
+			Fleet:            tck.FleetAddr.HexString(),
+			BlockNumber:      tck.BlockNumber,
+			TotalConnections: tck.TotalConnections,
+			TotalBytes:       tck.TotalBytes,
+		})
+	}
+	sort.Slice(cache.Devices, func(i, j int) bool {
+		return cache.Devices[i].Device < cache.Devices[j].Device
+	})
+	for _, item := range pool.GetCacheFleetItems() {
+		cache.Fleet = append(cache.Fleet, fleetCacheEntry{
+			Fleet:       item.Fleet.HexString(),
+			Device:      item.Device.HexString(),
+			Allowed:     item.Allowed,
+			BlockNumber: item.BlockNumber,
+		})
+	}
+	sort.Slice(cache.Fleet, func(i, j int) bool {
+		if cache.Fleet[i].Fleet == cache.Fleet[j].Fleet {
+			return cache.Fleet[i].Device < cache.Fleet[j].Device
+		}
+		return cache.Fleet[i].Fleet < cache.Fleet[j].Fleet
+	})
+	stats := pool.FleetCacheStats()
+	cache.FleetStats = fleetCacheStatsEntry{
+		Hits:    stats.Hits,
+		Misses:  stats.Misses,
+		Expired: stats.Expired,
+		Entries: stats.Entries,
+		HitRate: stats.HitRate(),
+	}
+	return cache
+}
+
+func healthStatus(pool *rpc.DataPool) []healthEntry {
+	checks := pool.GetPortHealth()
+	health := make([]healthEntry, 0, len(checks))
+	for _, check := range checks {
+		entry := healthEntry{
+			Port:      check.Port,
+			Healthy:   check.Healthy,
+			CheckedAt: check.CheckedAt.Format(time.RFC3339),
+		}
+		if check.Err != nil {
+			entry.Error = check.Err.Error()
+		}
+		health = append(health, entry)
+	}
+	return health
+}
+
+func (configAPIServer *ConfigAPIServer) statusResponse(w http.ResponseWriter, status interface{}) {
+	res, _ := json.Marshal(&statusResponse{
+		Success: true,
+		Message: "ok",
+		Status:  status,
+	})
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// statusHandleFunc serves the runtime state of the client: connected nodes,
+// open tunnels, port binds, the bns/device cache, the access lists and the
+// health of the published ports
+func (configAPIServer *ConfigAPIServer) statusHandleFunc() func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "GET" {
+			configAPIServer.notFoundError(w)
+			return
+		}
+		if !configAPIServer.authorize(w, req, apiScopeRead) {
+			return
+		}
+		pool := app.datapool
+		switch strings.TrimSuffix(req.URL.Path, "/") {
+		case "/status":
+			configAPIServer.statusResponse(w, &statusEntry{
+				Nodes:   nodesStatus(pool),
+				Tunnels: tunnelsStatus(pool),
+				Binds:   bindsStatus(app.socksServer),
+				Cache:   cacheStatus(pool),
+				Access:  accessStatus(&app),
+				Health:  healthStatus(pool),
+			})
+		case "/status/nodes":
+			configAPIServer.statusResponse(w, nodesStatus(pool))
+		case "/status/tunnels":
+			configAPIServer.statusResponse(w, tunnelsStatus(pool))
+		case "/status/binds":
+			configAPIServer.statusResponse(w, bindsStatus(app.socksServer))
+		case "/status/cache":
+			configAPIServer.statusResponse(w, cacheStatus(pool))
+		case "/status/access":
+			configAPIServer.statusResponse(w, accessStatus(&app))
+		case "/status/health":
+			configAPIServer.statusResponse(w, healthStatus(pool))
+		default:
+			configAPIServer.notFoundError(w)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/diodechain/diode_go_client/util"
+)
+
+const (
+	blocklistName = "blocklists"
+	allowlistName = "allowlists"
+)
+
+var (
+	errUnknownAccessList = fmt.Errorf("access list should be '%s' or '%s'", blocklistName, allowlistName)
+)
+
+type accessEntry struct {
+	Blocklists []string `json:"blocklists"`
+	Allowlists []string `json:"allowlists"`
+}
+
+func sortedAddresses(addrs map[util.Address]bool) []string {
+	ret := make([]string, 0, len(addrs))
+	for addr := range addrs {
+		ret = append(ret, addr.HexString())
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func accessStatus(dio *Diode) accessEntry {
+	blocklists, allowlists := dio.datapool.AccessLists()
+	return accessEntry{
+		Blocklists: sortedAddresses(blocklists),
+		Allowlists: sortedAddresses(allowlists),
+	}
+}
+
+// CloseTunnels closes the tunnels with the given hex encoded ref or to the
+// given device address and returns the number of closed tunnels
+func (dio *Diode) CloseTunnels(id string) int {
+	closed := 0
+	for _, device := range dio.datapool.GetDevices() {
+		if util.EncodeToString([]byte(device.Ref)) == id || device.DeviceID.HexString() == strings.ToLower(id) {
+			device.Close()
+			closed++
+		}
+	}
+	return closed
+}
+
+// UpdateAccessList adds or removes the device address to the block or allow
+// list. The change is applied right away and the list is saved to the config
+// file when the config was loaded from a file. Blocking a device closes its
+// tunnels.
+func (dio *Diode) UpdateAccessList(list string, addr util.Address, add bool) (changed bool, err error) {
+	if list != blocklistName && list != allowlistName {
+		err = errUnknownAccessList
+		return
+	}
+	dio.reloadMx.Lock()
+	defer dio.reloadMx.Unlock()
+	cfg := dio.config
+	blocklists, allowlists := dio.datapool.AccessLists()
+	addrs := allowlists
+	saddrs := &cfg.SAllowlists
+	if list == blocklistName {
+		addrs = blocklists
+		saddrs = &cfg.SBlocklists
+	}
+	if addrs[addr] == add {
+		return
+	}
+	// the maps of the data pool must not be modified
+	next := make(map[util.Address]bool, len(addrs)+1)
+	for a := range addrs {
+		if a != addr {
+			next[a] = true
+		}
+	}
+	strs := []string{}
+	for _, str := range *saddrs {
+		if a, decodeErr := util.DecodeAddress(str); decodeErr == nil && a == addr {
+			continue
+		}
+		strs = append(strs, str)
+	}
+	if add {
+		next[addr] = true
+		strs = append(strs, addr.HexString())
+	}
+	*saddrs = strs
+	if list == blocklistName {
+		blocklists = next
+		cfg.Blocklists = next
+	} else {
+		allowlists = next
+		cfg.Allowlists = next
+	}
+	dio.datapool.SetAccessLists(blocklists, allowlists)
+	changed = true
+	if list == blocklistName && add {
+		closed := dio.CloseTunnels(addr.HexString())
+		if closed > 0 {
+			cfg.Logger.Info("Closed %d tunnels of blocked device %s", closed, addr.HexString())
+		}
+	}
+	if cfg.LoadFromFile {
+		// only the changed list, the runtime config has values of flags too
+		err = cfg.SaveKeysToFile(list)
+	}
+	return
+}
+
+// tunnelsHandleFunc closes tunnels on DELETE /tunnels/<ref|device>
+func (configAPIServer *ConfigAPIServer) tunnelsHandleFunc() func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := strings.TrimPrefix(req.URL.Path, "/tunnels/")
+		if req.Method != "DELETE" || len(id) == 0 || strings.Contains(id, "/") {
+			configAPIServer.notFoundError(w)
+			return
+		}
+		if !configAPIServer.authorize(w, req, apiScopeAdmin) {
+			return
+		}
+		closed := app.CloseTunnels(id)
+		if closed == 0 {
+			configAPIServer.notFoundError(w)
+			return
+		}
+		configAPIServer.appConfig.Logger.Info("Closed %d tunnels of %s through config api", closed, id)
+		configAPIServer.successResponse(w, fmt.Sprintf("closed %d tunnels", closed))
+	}
+}
+
+// accessHandleFunc adds (PUT) and removes (DELETE) device addresses on
+// /access/blocklists/<address> and /access/allowlists/<address>
+func (configAPIServer *ConfigAPIServer) accessHandleFunc() func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/access/"), "/")
+		if len(parts) != 2 || (parts[0] != blocklistName && parts[0] != allowlistName) {
+			configAPIServer.notFoundError(w)
+			return
+		}
+		if req.Method != "PUT" && req.Method != "DELETE" {
+			configAPIServer.notFoundError(w)
+			return
+		}
+		if !configAPIServer.authorize(w, req, apiScopeAdmin) {
+			return
+		}
+		addr, err := util.DecodeAddress(parts[1])
+		if err != nil {
+			configAPIServer.clientError(w, map[string]string{"address": fmt.Sprintf("invalid address value %s", parts[1])})
+			return
+		}
+		add := req.Method == "PUT"
+		changed, err := app.UpdateAccessList(parts[0], addr, add)
+		if err != nil {
+			configAPIServer.appConfig.Logger.Error(fmt.Sprintf("Couldn't save config: %s", err.Error()))
+			configAPIServer.serverError(w)
+			return
+		}
+		if changed {
+			if add {
+				configAPIServer.appConfig.Logger.Info("Added %s to %s through config api", addr.HexString(), parts[0])
+			} else {
+				configAPIServer.appConfig.Logger.Info("Removed %s from %s through config api", addr.HexString(), parts[0])
+			}
+		}
+		if !configAPIServer.appConfig.LoadFromFile {
+			configAPIServer.successResponse(w, "ok, not saved without config file")
+			return
+		}
+		configAPIServer.successResponse(w, "ok")
+	}
+}
@@ -0,0 +1,206 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/diodechain/diode_go_client/command"
+	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/db"
+	"github.com/diodechain/diode_go_client/util"
+)
+
+const (
+	ctlTimeout = 10 * time.Second
+)
+
+var (
+	ctlCmd = &command.Command{
+		Name:        "ctl",
+		HelpText:    `  Control a running diode through the config api (tunnels|close|lists|block|unblock|allow|disallow).`,
+		ExampleText: `  diode -apisocket ~/.diode.sock ctl close 0x1234... && diode ctl -token <token> block 0x1234...`,
+		Type:        command.EmptyConnectionCommand,
+	}
+	ctlToken   string
+	errCtlArgs = fmt.Errorf("expected 'tunnels', 'close <ref|device>', 'lists', 'block <address>', 'unblock <address>', 'allow <address>' or 'disallow <address>'")
+)
+
+func init() {
+	ctlCmd.Run = ctlHandler
+	ctlCmd.Flag.StringVar(&ctlToken, "token", "", "bearer token of the config api, see 'diode token'")
+}
+
+// ctlClient calls the config api of a running diode
+type ctlClient struct {
+	client  *http.Client
+	baseURL string
+	token   string
+}
+
+func newCtlClient(cfg *config.Config) (*ctlClient, error) {
+	transport := &http.Transport{}
+	baseURL := fmt.Sprintf("http://%s", cfg.APIServerAddr)
+	if len(cfg.APIServerSocket) > 0 {
+		socket := cfg.APIServerSocket
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		baseURL = "http://localhost"
+	} else if cfg.APIServerTLS {
+		// trust the self-signed certificate of the database
+		certPEM, err := db.DB.Get(apiCertKey)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't find api certificate, start diode with -apitls first")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(certPEM) {
+			return nil, fmt.Errorf("invalid api certificate")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		baseURL = fmt.Sprintf("https://%s", cfg.APIServerAddr)
+	}
+	return &ctlClient{
+		client:  &http.Client{Transport: transport, Timeout: ctlTimeout},
+		baseURL: baseURL,
+		token:   ctlToken,
+	}, nil
+}
+
+// do sends the request with the json encoded body and decodes the status of
+// the response into status
+func (ctl *ctlClient) do(method string, path string, body interface{}, status interface{}) (message string, err error) {
+	var reader io.Reader
+	if body != nil {
+		var buf []byte
+		buf, err = json.Marshal(body)
+		if err != nil {
+			return
+		}
+		reader = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, ctl.baseURL+path, reader)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(ctl.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+ctl.token)
+	}
+	res, err := ctl.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return
+	}
+	response := statusResponse{Status: status}
+	err = json.Unmarshal(resBody, &response)
+	if err != nil {
+		err = fmt.Errorf("unexpected response %s", res.Status)
+		return
+	}
+	if !response.Success {
+		err = fmt.Errorf("%s (%s)", response.Message, res.Status)
+		return
+	}
+	return response.Message, nil
+}
+
+// isCtlDialError returns true when no diode listens on the config api
+func isCtlDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func ctlHandler() (err error) {
+	err = app.Start()
+	if err != nil {
+		return
+	}
+	args := ctlCmd.Flag.Args()
+	if len(args) == 0 {
+		printError("Argument Error: ", errCtlArgs)
+		return
+	}
+	ctl, err := newCtlClient(config.AppConfig)
+	if err != nil {
+		printError("Couldn't connect to the config api", err)
+		return
+	}
+	action := args[0]
+	switch {
+	case action == "tunnels" && len(args) == 1:
+		tunnels := []tunnelEntry{}
+		_, err = ctl.do("GET", "/status/tunnels", nil, &tunnels)
+		if err != nil {
+			printError("Couldn't list tunnels", err)
+			return
+		}
+		printLabel("<REF>", "<DEVICE>                                    <DIRECTION> <PORT>  <PROTOCOL> <SENT>      <RECEIVED>")
+		for _, tunnel := range tunnels {
+			printLabel(tunnel.Ref, fmt.Sprintf("%s  %-10s %6d  %-10s %-10d  %d", tunnel.Device, tunnel.Direction, tunnel.Port, tunnel.Protocol, tunnel.BytesSent, tunnel.BytesReceived))
+		}
+	case action == "close" && len(args) == 2:
+		var message string
+		message, err = ctl.do("DELETE", "/tunnels/"+args[1], nil, nil)
+		if err != nil {
+			printError("Couldn't close tunnel", err)
+			return
+		}
+		printLabel("Tunnel", message)
+	case action == "lists" && len(args) == 1:
+		var access accessEntry
+		_, err = ctl.do("GET", "/status/access", nil, &access)
+		if err != nil {
+			printError("Couldn't list access lists", err)
+			return
+		}
+		for _, addr := range access.Blocklists {
+			printLabel("Blocklist", addr)
+		}
+		for _, addr := range access.Allowlists {
+			printLabel("Allowlist", addr)
+		}
+	case (action == "block" || action == "unblock" || action == "allow" || action == "disallow") && len(args) == 2:
+		var addr util.Address
+		addr, err = util.DecodeAddress(args[1])
+		if err != nil {
+			printError("Invalid device address", err)
+			return
+		}
+		method := "PUT"
+		if action == "unblock" || action == "disallow" {
+			method = "DELETE"
+		}
+		list := allowlistName
+		if action == "block" || action == "unblock" {
+			list = blocklistName
+		}
+		var message string
+		message, err = ctl.do(method, fmt.Sprintf("/access/%s/%s", list, addr.HexString()), nil, nil)
+		if err != nil {
+			printError("Couldn't update "+list, err)
+			return
+		}
+		printLabel(list, message)
+	default:
+		printError("Argument Error: ", errCtlArgs)
+	}
+	return
+}
@@ -0,0 +1,248 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/diodechain/diode_go_client/command"
+	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/db"
+)
+
+var (
+	dbCmd = &command.Command{
+		Name:        "db",
+		HelpText:    `  Backup, restore and verify the local database (backup [file]|restore <file>|verify [file]).`,
+		ExampleText: `  diode db backup ~/diode.backup && diode db verify ~/diode.backup && diode db restore ~/diode.backup`,
+		Type:        command.EmptyConnectionCommand,
+	}
+	errDBArgs = fmt.Errorf("expected 'backup [file]', 'restore <file>' or 'verify [file]'")
+	// dbKeyCheckers validates the known database entries, other
+	// entries are reported as unknown
+	dbKeyCheckers = map[string]func(value []byte) error{
+		"private": func(value []byte) error {
+			_, err := privateKeyAddress(value)
+			return err
+		},
+		"fleet":           checkSize(20),
+		"fleet_id":        checkNothing,
+		"lvbn":            checkNothing,
+		"lvbn2":           checkNothing,
+		"lvbn3":           checkNothing,
+		"lvbh":            checkNothing,
+		"lvbh2":           checkNothing,
+		"lvbh3":           checkSize(32),
+		"last_update_at":  checkNothing,
+		apiCertKey:        checkPEM,
+		apiCertPrivKey:    checkPEM,
+		staticCertKey:     checkPEM,
+		staticCertPrivKey: checkPEM,
+	}
+	// dbPrefixCheckers validates entries that share a key prefix
+	dbPrefixCheckers = map[string]func(key string, value []byte) error{
+		apiTokenPrefix: func(key string, value []byte) error {
+			_, err := decodeAPIToken(key, value)
+			return err
+		},
+		accessGrantPrefix: func(key string, value []byte) error {
+			_, err := decodeAccessGrant(key, value)
+			return err
+		},
+		socksUserPrefix: func(key string, value []byte) error {
+			_, err := decodeSocksUser(key, value)
+			return err
+		},
+	}
+)
+
+func init() {
+	dbCmd.Run = dbHandler
+}
+
+func checkNothing(value []byte) error {
+	return nil
+}
+
+func checkPEM(value []byte) error {
+	block, _ := pem.Decode(value)
+	if block == nil {
+		return fmt.Errorf("invalid pem block")
+	}
+	return nil
+}
+
+// dbKeyChecker returns the checker of the given key
+func dbKeyChecker(key string) (func(value []byte) error, bool) {
+	if check, ok := dbKeyCheckers[key]; ok {
+		return check, true
+	}
+	for prefix, check := range dbPrefixCheckers {
+		if strings.HasPrefix(key, prefix) {
+			prefixCheck := check
+			return func(value []byte) error {
+				return prefixCheck(key, value)
+			}, true
+		}
+	}
+	return nil, false
+}
+
+func checkSize(size int) func(value []byte) error {
+	return func(value []byte) error {
+		if len(value) != size {
+			return fmt.Errorf("expected %d bytes but got %d", size, len(value))
+		}
+		return nil
+	}
+}
+
+func dbHandler() (err error) {
+	args := dbCmd.Flag.Args()
+	if len(args) < 1 || len(args) > 2 {
+		printError("Argument Error: ", errDBArgs)
+		return
+	}
+	file := ""
+	if len(args) == 2 {
+		file = args[1]
+	}
+	switch args[0] {
+	case "backup":
+		err = dbBackup(file)
+	case "restore":
+		if len(file) == 0 {
+			printError("Argument Error: ", errDBArgs)
+			return
+		}
+		err = dbRestore(file)
+	case "verify":
+		if len(file) == 0 {
+			file = config.AppConfig.DBPath
+		}
+		err = dbVerify(file)
+	default:
+		printError("Argument Error: ", errDBArgs)
+	}
+	return
+}
+
+// dbBackup writes the readable entries of the database file to the given
+// file, without a file an automatic backup is created
+func dbBackup(file string) (err error) {
+	dbPath := config.AppConfig.DBPath
+	report, err := db.Verify(dbPath)
+	if err != nil {
+		printError("Couldn't read database", err)
+		return
+	}
+	if !report.Valid() {
+		printLabel("Skipped corrupted bytes", fmt.Sprintf("%d", report.Corrupted))
+	}
+	if len(file) == 0 {
+		file, err = db.WriteAutoBackup(dbPath, report.Values)
+	} else {
+		err = db.WriteBackup(file, report.Values)
+	}
+	if err != nil {
+		printError("Couldn't backup database", err)
+		return
+	}
+	printLabel("Backup", file)
+	return
+}
+
+// dbRestore replaces the database file with the given backup, the current
+// database is backed up before and a damaged one is saved unchanged
+func dbRestore(file string) (err error) {
+	values, err := db.ReadBackup(file)
+	if err != nil {
+		printError("Couldn't read backup", err)
+		return
+	}
+	dbPath := config.AppConfig.DBPath
+	lock, err := db.Lock(dbPath)
+	if err != nil {
+		printError("Couldn't restore database", err)
+		return
+	}
+	defer lock.Close()
+	report, err := db.Verify(dbPath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		printError("Couldn't read database", err)
+		return
+	case report.Valid():
+		var backup string
+		backup, err = db.WriteAutoBackup(dbPath, report.Values)
+		if err != nil {
+			printError("Couldn't backup database", err)
+			return
+		}
+		printLabel("Backup", backup)
+	default:
+		var damaged string
+		damaged, err = db.SaveDamaged(dbPath)
+		if err != nil {
+			printError("Couldn't save damaged database", err)
+			return
+		}
+		printLabel("Damaged database", damaged)
+	}
+	err = db.WriteBackup(dbPath, values)
+	if err != nil {
+		printError("Couldn't restore database", err)
+		return
+	}
+	printLabel("Restored entries", fmt.Sprintf("%d", len(values)))
+	return
+}
+
+// dbVerify checks the format of the database file and its known entries
+func dbVerify(file string) (err error) {
+	report, err := db.Verify(file)
+	if err != nil {
+		printError("Couldn't read database", err)
+		return
+	}
+	printLabel("Database", file)
+	printLabel("Format", report.Format)
+	printLabel("Records", fmt.Sprintf("%d", report.Records))
+	printLabel("Entries", fmt.Sprintf("%d", len(report.Values)))
+	keys := make([]string, 0, len(report.Values))
+	for key := range report.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var invalid int
+	for _, key := range keys {
+		check, ok := dbKeyChecker(key)
+		if !ok {
+			printLabel("Unknown entry", key)
+			continue
+		}
+		if checkErr := check(report.Values[key]); checkErr != nil {
+			invalid++
+			printError(fmt.Sprintf("Invalid entry %s", key), checkErr)
+		}
+	}
+	if !report.Valid() {
+		printLabel("Corrupted bytes", fmt.Sprintf("%d", report.Corrupted))
+		err = fmt.Errorf("%v: %v", db.ErrCorrupted, report.Err)
+		printError("Database verification failed", err)
+		return
+	}
+	if invalid > 0 {
+		err = fmt.Errorf("%d invalid entries", invalid)
+		printError("Database verification failed", err)
+		return
+	}
+	printInfo("Database is valid")
+	return
+}
@@ -61,14 +61,22 @@ func httpdHandler() (err error) {
 			printLabel(fmt.Sprintf("Port      %5d", bind.LocalPort), fmt.Sprintf("%5s     %11s:%d", config.ProtocolName(bind.Protocol), bind.To, bind.ToPort))
 		}
 	}
+	users, err := socksUsers(cfg)
+	if err != nil {
+		return
+	}
+	routes, err := rpc.NewRouter(cfg.SocksRoutes)
+	if err != nil {
+		return
+	}
 	socksServer.SetConfig(&rpc.Config{
 		Addr:            cfg.SocksServerAddr(),
 		FleetAddr:       cfg.FleetAddr,
-		Blocklists:      cfg.Blocklists,
-		Allowlists:      cfg.Allowlists,
 		EnableProxy:     true,
 		ProxyServerAddr: cfg.ProxyServerAddr(),
 		Fallback:        cfg.SocksFallback,
+		Users:           users,
+		Routes:          routes,
 	})
 	app.SetSocksServer(socksServer)
 	if err = socksServer.Start(); err != nil {
@@ -0,0 +1,566 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diodechain/diode_go_client/command"
+	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/db"
+	"github.com/diodechain/diode_go_client/rpc"
+	"github.com/diodechain/diode_go_client/util"
+)
+
+const (
+	accessGrantPrefix = "access_grant:"
+	// grantCheckInterval is how often the daemon looks for expired access
+	// grants
+	grantCheckInterval = 15 * time.Second
+	grantPending       = "pending"
+	grantActive        = "active"
+	grantExpired       = "expired"
+)
+
+var (
+	grantCmd = &command.Command{
+		Name:        "grant",
+		HelpText:    `  Manage time-limited access grants of private ports (add|list|revoke).`,
+		ExampleText: `  diode grant add -duration 4h 0x1234... 22 && diode grant add -start 2021-03-01T09:00:00Z -end 2021-03-01T17:00:00Z alice-laptop 22 && diode grant revoke 1a2b3c4d5e6f7a8b`,
+		Type:        command.EmptyConnectionCommand,
+	}
+	grantStart             string
+	grantEnd               string
+	grantDuration          time.Duration
+	errGrantArgs           = fmt.Errorf("expected 'add <address|bns name> <port>', 'list' or 'revoke <id>'")
+	errAccessGrantNotFound = fmt.Errorf("access grant not found")
+)
+
+func init() {
+	grantCmd.Run = grantHandler
+	grantCmd.Flag.StringVar(&grantStart, "start", "", "start time of the grant (RFC3339), now when empty")
+	grantCmd.Flag.StringVar(&grantEnd, "end", "", "end time of the grant (RFC3339), start + duration when empty")
+	grantCmd.Flag.DurationVar(&grantDuration, "duration", time.Hour, "duration of the grant")
+	grantCmd.Flag.StringVar(&ctlToken, "token", "", "bearer token of the config api of a running diode, see 'diode token'")
+}
+
+// accessGrant is stored in the database, the device is an address or a bns
+// name that is resolved by the daemon
+type accessGrant struct {
+	ID        string `json:"-"`
+	Device    string `json:"device"`
+	Port      int    `json:"port"`
+	Start     int64  `json:"start"`
+	End       int64  `json:"end"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// grantStatus returns whether the grant is pending, active or expired
+func (grant *accessGrant) status(now time.Time) string {
+	if now.Unix() < grant.Start {
+		return grantPending
+	}
+	if now.Unix() >= grant.End {
+		return grantExpired
+	}
+	return grantActive
+}
+
+func validateAccessGrant(grant *accessGrant) error {
+	if !util.IsAddress([]byte(grant.Device)) && !isValidBNS(grant.Device) {
+		return fmt.Errorf("device should be an address or a bns name but is: %v", grant.Device)
+	}
+	if !util.IsPort(grant.Port) {
+		return fmt.Errorf("port should be bigger than 1 and smaller than 65535")
+	}
+	if grant.End <= grant.Start {
+		return fmt.Errorf("end of the grant should be after the start")
+	}
+	return nil
+}
+
+// parseGrantTimes returns the start and end of a grant, start defaults to
+// now and end to start + duration
+func parseGrantTimes(start string, end string, duration time.Duration) (startTime time.Time, endTime time.Time, err error) {
+	startTime = time.Now()
+	if len(start) > 0 {
+		startTime, err = time.Parse(time.RFC3339, start)
+		if err != nil {
+			err = fmt.Errorf("start should be a RFC3339 time: %v", err)
+			return
+		}
+	}
+	endTime = startTime.Add(duration)
+	if len(end) > 0 {
+		endTime, err = time.Parse(time.RFC3339, end)
+		if err != nil {
+			err = fmt.Errorf("end should be a RFC3339 time: %v", err)
+			return
+		}
+	}
+	if !endTime.After(time.Now()) {
+		err = fmt.Errorf("end of the grant should be in the future")
+	}
+	return
+}
+
+// createAccessGrant validates and stores a new grant
+func createAccessGrant(device string, port int, start time.Time, end time.Time) (grant accessGrant, err error) {
+	grant = accessGrant{
+		Device:    strings.ToLower(device),
+		Port:      port,
+		Start:     start.Unix(),
+		End:       end.Unix(),
+		CreatedAt: time.Now().Unix(),
+	}
+	err = validateAccessGrant(&grant)
+	if err != nil {
+		return
+	}
+	buf := make([]byte, 8)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return
+	}
+	grant.ID = hex.EncodeToString(buf)
+	value, err := json.Marshal(&grant)
+	if err != nil {
+		return
+	}
+	err = db.DB.Put(accessGrantPrefix+grant.ID, value)
+	return
+}
+
+func decodeAccessGrant(key string, value []byte) (grant accessGrant, err error) {
+	err = json.Unmarshal(value, &grant)
+	if err != nil {
+		return
+	}
+	err = validateAccessGrant(&grant)
+	if err != nil {
+		err = fmt.Errorf("invalid access grant %s: %v", key, err)
+		return
+	}
+	grant.ID = strings.TrimPrefix(key, accessGrantPrefix)
+	return
+}
+
+// decodeAccessGrants returns all access grants of the given database values
+// sorted by start time
+func decodeAccessGrants(values map[string][]byte) []accessGrant {
+	grants := []accessGrant{}
+	for key, value := range values {
+		if !strings.HasPrefix(key, accessGrantPrefix) {
+			continue
+		}
+		grant, err := decodeAccessGrant(key, value)
+		if err != nil {
+			continue
+		}
+		grants = append(grants, grant)
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		if grants[i].Start == grants[j].Start {
+			return grants[i].ID < grants[j].ID
+		}
+		return grants[i].Start < grants[j].Start
+	})
+	return grants
+}
+
+func findAccessGrant(grants []accessGrant, id string) (accessGrant, bool) {
+	for _, grant := range grants {
+		if grant.ID == id {
+			return grant, true
+		}
+	}
+	return accessGrant{}, false
+}
+
+// listGrants returns the grants of the running diode, the database is read
+// when no diode listens on the config api
+func listGrants(ctl *ctlClient) (entries []grantEntry, err error) {
+	_, err = ctl.do("GET", "/grants", nil, &entries)
+	if !isCtlDialError(err) {
+		return
+	}
+	now := time.Now()
+	entries = []grantEntry{}
+	for _, grant := range decodeAccessGrants(dbValues()) {
+		entries = append(entries, newGrantEntry(grant, now))
+	}
+	return entries, nil
+}
+
+// addGrant adds the grant through the config api of the running diode, the
+// grant is only written to the database when no diode listens on the config
+// api, it fails when a diode without config api holds the database
+func addGrant(ctl *ctlClient, device string, port int, start time.Time, end time.Time) (entry grantEntry, err error) {
+	body := postGrantRequest{
+		Device: device,
+		Port:   port,
+		Start:  start.Format(time.RFC3339),
+		End:    end.Format(time.RFC3339),
+	}
+	_, err = ctl.do("POST", "/grants", &body, &entry)
+	if !isCtlDialError(err) {
+		return
+	}
+	grant, err := createAccessGrant(device, port, start, end)
+	if err != nil {
+		return
+	}
+	return newGrantEntry(grant, time.Now()), nil
+}
+
+// revokeGrant revokes the grant through the config api of the running diode
+// or in the database when no diode listens on the config api
+func revokeGrant(ctl *ctlClient, id string) (err error) {
+	_, err = ctl.do("DELETE", "/grants/"+id, nil, nil)
+	if !isCtlDialError(err) {
+		return
+	}
+	if _, ok := findAccessGrant(decodeAccessGrants(dbValues()), id); !ok {
+		return errAccessGrantNotFound
+	}
+	return db.DB.Del(accessGrantPrefix + id)
+}
+
+func grantHandler() (err error) {
+	if db.DB == nil {
+		return fmt.Errorf("database is not available")
+	}
+	args := grantCmd.Flag.Args()
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	ctl, err := newCtlClient(config.AppConfig)
+	if err != nil {
+		printError("Couldn't connect to the config api", err)
+		return
+	}
+	switch {
+	case action == "list" && len(args) <= 1:
+		var entries []grantEntry
+		entries, err = listGrants(ctl)
+		if err != nil {
+			printError("Couldn't list grants", err)
+			return
+		}
+		printLabel("<ID>", "<DEVICE>                                    <PORT>  <STATUS>  <START>                    <END>")
+		for _, entry := range entries {
+			printLabel(entry.ID, fmt.Sprintf("%-42s  %6d  %-8s  %-25s  %s", entry.Device, entry.Port, entry.Status, entry.Start, entry.End))
+		}
+	case action == "add" && len(args) == 3:
+		var port int
+		port, err = strconv.Atoi(args[2])
+		if err != nil {
+			printError("Invalid port", err)
+			return
+		}
+		var start, end time.Time
+		start, end, err = parseGrantTimes(grantStart, grantEnd, grantDuration)
+		if err != nil {
+			printError("Invalid grant time", err)
+			return
+		}
+		var entry grantEntry
+		entry, err = addGrant(ctl, args[1], port, start, end)
+		if err != nil {
+			printError("Couldn't create grant", err)
+			printGrantHint(err)
+			return
+		}
+		printLabel("Grant ID", entry.ID)
+		printLabel("Device", entry.Device)
+		printLabel("Port", strconv.Itoa(entry.Port))
+		printLabel("Valid", fmt.Sprintf("%s - %s", entry.Start, entry.End))
+	case action == "revoke" && len(args) == 2:
+		id := args[1]
+		err = revokeGrant(ctl, id)
+		if err != nil {
+			printError("Couldn't revoke grant", err)
+			printGrantHint(err)
+			return
+		}
+		printLabel("Revoked grant", id)
+	default:
+		printError("Argument Error: ", errGrantArgs)
+	}
+	return
+}
+
+// printGrantHint explains how grants can be changed while another diode
+// holds the database
+func printGrantHint(err error) {
+	if err == db.ErrReadOnly {
+		printInfo("Start the running diode with -api to change grants while it runs")
+	}
+}
+
+// grantWatcher keeps the access grants of the database in sync with the
+// data pool, closes the tunnels of expired and revoked grants and reports
+// expired grants
+type grantWatcher struct {
+	dio      *Diode
+	rm       sync.Mutex
+	grants   []accessGrant
+	resolved []rpc.AccessGrant
+	// resolvedAt is the last time the bns names of the grants were resolved
+	resolvedAt time.Time
+	unresolved bool
+	expired    map[string]bool
+	startedAt  time.Time
+}
+
+// WatchGrants applies the access grants of the database and checks them
+// periodically
+func (dio *Diode) WatchGrants() {
+	watcher := &grantWatcher{
+		dio:       dio,
+		expired:   make(map[string]bool),
+		startedAt: time.Now(),
+	}
+	dio.grants = watcher
+	go func() {
+		watcher.refresh()
+		ticker := time.NewTicker(grantCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-dio.closeCh:
+				return
+			case <-ticker.C:
+				watcher.check()
+			}
+		}
+	}()
+}
+
+// RefreshGrants applies the access grants of the database, the config api
+// calls it after it changed them
+func (dio *Diode) RefreshGrants() {
+	if dio.grants == nil {
+		return
+	}
+	dio.grants.refresh()
+}
+
+// refresh reads the grants from the database and applies them
+func (watcher *grantWatcher) refresh() {
+	watcher.rm.Lock()
+	defer watcher.rm.Unlock()
+	watcher.grants = decodeAccessGrants(dbValues())
+	watcher.resolve()
+	watcher.checkExpired()
+}
+
+// resolve resolves the bns names of the grants and applies them
+func (watcher *grantWatcher) resolve() {
+	resolved := make([]rpc.AccessGrant, 0, len(watcher.grants))
+	names := make(map[string]util.Address)
+	watcher.unresolved = false
+	for _, grant := range watcher.grants {
+		addr, err := util.DecodeAddress(grant.Device)
+		if err != nil {
+			var ok bool
+			addr, ok = names[grant.Device]
+			if !ok {
+				addr, err = watcher.dio.resolveBNS(grant.Device)
+				if err != nil {
+					watcher.dio.config.Logger.Warn("Couldn't resolve bns name %s of access grant %s: %v", grant.Device, grant.ID, err)
+					watcher.unresolved = true
+					continue
+				}
+				names[grant.Device] = addr
+			}
+		}
+		resolved = append(resolved, rpc.AccessGrant{
+			ID:     grant.ID,
+			Port:   grant.Port,
+			Device: addr,
+			Start:  time.Unix(grant.Start, 0),
+			End:    time.Unix(grant.End, 0),
+		})
+	}
+	revoked := []rpc.AccessGrant{}
+	for _, old := range watcher.resolved {
+		if _, ok := findAccessGrant(watcher.grants, old.ID); !ok && !watcher.expired[old.ID] {
+			revoked = append(revoked, old)
+		}
+	}
+	watcher.resolved = resolved
+	watcher.resolvedAt = time.Now()
+	watcher.dio.datapool.SetAccessGrants(resolved)
+	for _, grant := range revoked {
+		watcher.dio.config.Logger.Info("Access grant %s of %s to port %d was revoked", grant.ID, grant.Device.HexString(), grant.Port)
+		watcher.dio.closeUngrantedTunnels(grant)
+	}
+}
+
+// check resolves the bns names again when they are outdated and closes the
+// tunnels of expired grants
+func (watcher *grantWatcher) check() {
+	watcher.rm.Lock()
+	defer watcher.rm.Unlock()
+	if watcher.unresolved || time.Since(watcher.resolvedAt) > bnsResolveInterval {
+		watcher.resolve()
+	}
+	watcher.checkExpired()
+}
+
+func (watcher *grantWatcher) checkExpired() {
+	now := time.Now()
+	for _, grant := range watcher.resolved {
+		if now.Before(grant.End) || watcher.expired[grant.ID] {
+			continue
+		}
+		watcher.expired[grant.ID] = true
+		// grants that expired before the start were reported already
+		if grant.End.Before(watcher.startedAt) {
+			continue
+		}
+		device := grant.Device.HexString()
+		watcher.dio.config.Logger.Info("Access grant %s of %s to port %d expired", grant.ID, device, grant.Port)
+		watcher.dio.datapool.Events().Publish(rpc.Event{
+			Type: rpc.EventGrantExpired,
+			Data: map[string]interface{}{
+				"id":     grant.ID,
+				"device": device,
+				"port":   grant.Port,
+			},
+		})
+		watcher.dio.closeUngrantedTunnels(grant)
+	}
+}
+
+// closeUngrantedTunnels closes the tunnels of the grant device to the
+// private port unless the device is still allowed
+func (dio *Diode) closeUngrantedTunnels(grant rpc.AccessGrant) {
+	port := dio.datapool.GetPublishedPort(grant.Port)
+	if port == nil || port.Mode != config.PrivatePublishedMode || port.Allowlist[grant.Device] {
+		return
+	}
+	if dio.datapool.IsGranted(grant.Port, grant.Device) {
+		return
+	}
+	for _, device := range dio.datapool.GetDevices() {
+		if device.DeviceID == grant.Device && device.PortNumber == grant.Port && len(device.SrcAddr) > 0 {
+			device.Close()
+		}
+	}
+}
+
+type grantEntry struct {
+	ID     string `json:"id"`
+	Device string `json:"device"`
+	Port   int    `json:"port"`
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	Status string `json:"status"`
+}
+
+type postGrantRequest struct {
+	Device   string `json:"device"`
+	Port     int    `json:"port"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Duration string `json:"duration"`
+}
+
+func newGrantEntry(grant accessGrant, now time.Time) grantEntry {
+	return grantEntry{
+		ID:     grant.ID,
+		Device: grant.Device,
+		Port:   grant.Port,
+		Start:  time.Unix(grant.Start, 0).Format(time.RFC3339),
+		End:    time.Unix(grant.End, 0).Format(time.RFC3339),
+		Status: grant.status(now),
+	}
+}
+
+// grantsHandleFunc lists (GET /grants), adds (POST /grants) and revokes
+// (DELETE /grants/<id>) access grants
+func (configAPIServer *ConfigAPIServer) grantsHandleFunc() func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		cfg := configAPIServer.appConfig
+		id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/grants"), "/")
+		switch {
+		case req.Method == "GET" && len(id) == 0:
+			if !configAPIServer.authorize(w, req, apiScopeRead) {
+				return
+			}
+			grants := decodeAccessGrants(dbValues())
+			now := time.Now()
+			entries := make([]grantEntry, len(grants))
+			for i, grant := range grants {
+				entries[i] = newGrantEntry(grant, now)
+			}
+			configAPIServer.statusResponse(w, entries)
+		case req.Method == "POST" && len(id) == 0:
+			if !configAPIServer.authorize(w, req, apiScopeAdmin) {
+				return
+			}
+			req.Body = http.MaxBytesReader(w, req.Body, 1048576)
+			dec := json.NewDecoder(req.Body)
+			dec.DisallowUnknownFields()
+			var body postGrantRequest
+			err := dec.Decode(&body)
+			if err != nil {
+				configAPIServer.clientError(w, map[string]string{"body": fmt.Sprintf("couldn't decode request: %s", err.Error())})
+				return
+			}
+			duration := time.Hour
+			if len(body.Duration) > 0 {
+				duration, err = time.ParseDuration(body.Duration)
+				if err != nil || duration <= 0 {
+					configAPIServer.clientError(w, map[string]string{"duration": fmt.Sprintf("invalid duration value %s", body.Duration)})
+					return
+				}
+			}
+			start, end, err := parseGrantTimes(body.Start, body.End, duration)
+			if err != nil {
+				configAPIServer.clientError(w, map[string]string{"time": err.Error()})
+				return
+			}
+			grant, err := createAccessGrant(body.Device, body.Port, start, end)
+			if err != nil {
+				configAPIServer.clientError(w, map[string]string{"grant": err.Error()})
+				return
+			}
+			cfg.Logger.Info("Added access grant %s of %s to port %d through config api", grant.ID, grant.Device, grant.Port)
+			app.RefreshGrants()
+			configAPIServer.statusResponse(w, newGrantEntry(grant, time.Now()))
+		case req.Method == "DELETE" && len(id) > 0 && !strings.Contains(id, "/"):
+			if !configAPIServer.authorize(w, req, apiScopeAdmin) {
+				return
+			}
+			if _, ok := findAccessGrant(decodeAccessGrants(dbValues()), id); !ok {
+				configAPIServer.notFoundError(w)
+				return
+			}
+			err := db.DB.Del(accessGrantPrefix + id)
+			if err != nil {
+				cfg.Logger.Error("Couldn't revoke access grant: %v", err)
+				configAPIServer.serverError(w)
+				return
+			}
+			cfg.Logger.Info("Revoked access grant %s through config api", id)
+			app.RefreshGrants()
+			configAPIServer.successResponse(w, "ok")
+		default:
+			configAPIServer.notFoundError(w)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	healthCheckTimeout = 5 * time.Second
+)
+
+// WatchHealth checks the services of the published ports periodically,
+// unhealthy ports reject new tunnels until they recover
+func (dio *Diode) WatchHealth() {
+	interval := dio.config.HealthCheckInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for _, change := range dio.datapool.CheckHealth(healthCheckTimeout) {
+				if change.Healthy {
+					printLabel("Port healthy", fmt.Sprintf("%d", change.Port))
+				} else {
+					dio.config.Logger.Warn("Port %d is unhealthy: %v", change.Port, change.Err)
+				}
+			}
+			select {
+			case <-dio.closeCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,132 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/diodechain/diode_go_client/command"
+	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/crypto"
+	"github.com/diodechain/diode_go_client/db"
+	"github.com/diodechain/diode_go_client/util"
+)
+
+var (
+	profileCmd = &command.Command{
+		Name:        "profile",
+		HelpText:    `  Manage identity profiles (list|create|delete|use).`,
+		ExampleText: `  diode profile create testing && diode profile use testing && diode profile list`,
+		Type:        command.EmptyConnectionCommand,
+	}
+	errProfileArgs = fmt.Errorf("expected 'list', 'create <name>', 'delete <name>' or 'use <name>'")
+)
+
+func init() {
+	profileCmd.Run = profileHandler
+}
+
+func profileHandler() (err error) {
+	args := profileCmd.Flag.Args()
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	if action == "list" {
+		return listProfiles()
+	}
+	if len(args) != 2 {
+		printError("Argument Error: ", errProfileArgs)
+		return
+	}
+	name := args[1]
+	switch action {
+	case "create":
+		err = config.CreateProfile(name)
+		if err != nil {
+			printError("Couldn't create profile", err)
+			return
+		}
+		printLabel("Created profile", name)
+		printLabel("Database", config.ProfileDBPath(name))
+		printInfo(fmt.Sprintf("A new key will be generated on first use, try 'diode -profile %s config'", name))
+	case "delete":
+		err = config.DeleteProfile(name)
+		if err != nil {
+			printError("Couldn't delete profile", err)
+			return
+		}
+		printLabel("Deleted profile", name)
+	case "use":
+		err = config.SetActiveProfile(name)
+		if err != nil {
+			printError("Couldn't use profile", err)
+			return
+		}
+		printLabel("Active profile", name)
+	default:
+		printError("Argument Error: ", errProfileArgs)
+	}
+	return
+}
+
+func listProfiles() error {
+	profiles, err := config.ListProfiles()
+	if err != nil {
+		printError("Couldn't list profiles", err)
+		return err
+	}
+	active := config.ActiveProfile()
+	printLabel("<PROFILE>", "<ADDRESS>")
+	for _, name := range profiles {
+		label := name
+		if name == active {
+			label = fmt.Sprintf("%s (active)", name)
+		}
+		printLabel(label, profileAddress(name))
+	}
+	return nil
+}
+
+// profileAddress returns the client address stored in the database of the given profile
+func profileAddress(name string) string {
+	dbPath := config.ProfileDBPath(name)
+	if dbPath == config.AppConfig.DBPath && db.DB != nil {
+		return config.AppConfig.ClientAddr.HexString()
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		return "<not initialized>"
+	}
+	profileDB, err := db.OpenFile(dbPath)
+	if err != nil {
+		return "<invalid database>"
+	}
+	defer profileDB.Close()
+	key, err := profileDB.Get("private")
+	if err != nil {
+		return "<not initialized>"
+	}
+	addr, err := privateKeyAddress(key)
+	if err != nil {
+		return "<invalid key>"
+	}
+	return addr.HexString()
+}
+
+// privateKeyAddress returns the address of the PEM encoded private key
+func privateKeyAddress(key []byte) (addr util.Address, err error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		err = fmt.Errorf("invalid pem block")
+		return
+	}
+	privKey, err := crypto.DerToECDSA(block.Bytes)
+	if err != nil {
+		return
+	}
+	addr = util.PubkeyToAddress(crypto.MarshalPubkey(&privKey.PublicKey))
+	return
+}
@@ -5,11 +5,15 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/diodechain/diode_go_client/command"
 	"github.com/diodechain/diode_go_client/config"
@@ -25,7 +29,7 @@ var (
 	publishCmd = &command.Command{
 		Name:             "publish",
 		HelpText:         `  Publish ports of the local device to the Diode Network.`,
-		ExampleText:      `  diode publish -public 80:80 -public 8080:8080 -protected 3000:3000 -protected 3001:3001 -private 22:22,0x......,0x...... -private 33:33,0x......,0x......`,
+		ExampleText:      `  diode publish -public 80:80 -public 8080:8080 -protected 3000:3000 -protected 3001:3001 -private 22:22,0x......,0x...... -private 33:33,0x......,alice-laptop -public 192.168.1.10:80:8080 -public unix:/run/app.sock:8081 -private 22:2222:tcp:proxy,0x......`,
 		Run:              publishHandler,
 		Type:             command.DaemonCommand,
 		SingleConnection: true,
@@ -37,7 +41,12 @@ func init() {
 	cfg := config.AppConfig
 	publishCmd.Flag.Var(&cfg.PublicPublishedPorts, "public", "expose ports to public users, so that user could connect to")
 	publishCmd.Flag.Var(&cfg.ProtectedPublishedPorts, "protected", "expose ports to protected users (in fleet contract), so that user could connect to")
-	publishCmd.Flag.Var(&cfg.PrivatePublishedPorts, "private", "expose ports to private users, so that user could connect to")
+	publishCmd.Flag.Var(&cfg.PrivatePublishedPorts, "private", "expose ports to private users (addresses or bns names), so that user could connect to")
+	publishCmd.Flag.Var(&cfg.PublishedTargetHosts, "target_hosts", "hosts, ip addresses or ip ranges (CIDR) that published ports may target, all hosts when empty")
+	publishCmd.Flag.Var(&cfg.SHealthChecks, "health", "check the service of the published port periodically, <port> for tcp or <port>:<path> for http checks")
+	publishCmd.Flag.DurationVar(&cfg.HealthCheckInterval, "health_interval", 10*time.Second, "interval of the health checks")
+	publishCmd.Flag.StringVar(&cfg.MaintenancePage, "maintenance_page", "", "html file that is served by unhealthy http ports")
+	publishCmd.Flag.Uint64Var(&cfg.AllowlistCacheAge, "allowlist_cache_age", 20, "number of blocks the fleet allowlist lookups of protected ports are cached, 0 disables the cache")
 	publishCmd.Flag.StringVar(&cfg.SocksServerHost, "proxy_host", "127.0.0.1", "host of socksd proxy server")
 	publishCmd.Flag.IntVar(&cfg.SocksServerPort, "proxy_port", 1080, "port of socksd proxy server")
 	publishCmd.Flag.BoolVar(&cfg.EnableSocksServer, "socksd", false, "enable socksd proxy server")
@@ -46,78 +55,147 @@ func init() {
 	publishCmd.Flag.StringVar(&staticServer.RootDirectory, "http_dir", "", "the root directory of http static file server")
 	publishCmd.Flag.StringVar(&staticServer.Host, "http_host", "127.0.0.1", "the host of http static file server")
 	publishCmd.Flag.IntVar(&staticServer.Port, "http_port", 8080, "the port of http static file server")
+	publishCmd.Flag.BoolVar(&staticServer.WebDAV, "http_webdav", false, "enable webdav uploads to the http static file server")
+	publishCmd.Flag.Var(&cfg.SHTTPWriters, "http_writers", "device addresses that are allowed to upload files with webdav")
+	publishCmd.Flag.Int64Var(&staticServer.MaxUploadSize, "http_max_upload", rpc.DefaultMaxUploadSize, "the maximum size in bytes of a webdav upload")
+	publishCmd.Flag.BoolVar(&staticServer.TLS, "http_tls", false, "serve https with a self-signed or the given certificate in http static file server")
+	publishCmd.Flag.StringVar(&staticServer.CertFile, "http_cert", "", "the certificate file of https static file server")
+	publishCmd.Flag.StringVar(&staticServer.KeyFile, "http_key", "", "the private key file of https static file server")
+	publishCmd.Flag.BoolVar(&staticServer.SPA, "http_spa", false, "serve index.html for unknown paths of single page apps in http static file server")
+	publishCmd.Flag.BoolVar(&staticServer.Precompressed, "http_precompressed", false, "serve the precompressed .br and .gz files in http static file server")
+	publishCmd.Flag.DurationVar(&staticServer.MaxAge, "http_max_age", 0, "the time clients may cache files other than html pages, 0 disables the cache headers")
 }
 
-var portPattern = regexp.MustCompile(`^(\d+)(:(\d*)(:(tcp|tls|udp))?)?$`)
+var portPattern = regexp.MustCompile(`^(\d+)(:(\d*)(:(tcp|tls|udp|http))?(:proxy)?)?$`)
+var hostPortPattern = regexp.MustCompile(`^([a-zA-Z0-9\-\.]*[a-zA-Z\.][a-zA-Z0-9\-\.]*|\[[a-fA-F0-9:\.]+\]):(\d+):(\d+)(:(tcp|tls|udp|http))?(:proxy)?$`)
+var socketPortPattern = regexp.MustCompile(`^unix:(/.*):(\d+)(:(tcp|tls|udp|http))?(:proxy)?$`)
 var accessPattern = regexp.MustCompile(`^0x[a-fA-F0-9]{40}$`)
+var healthPattern = regexp.MustCompile(`^(\d+)(:(/\S*))?$`)
+var vhostPattern = regexp.MustCompile(`^(\*\.)?[a-z0-9]([a-z0-9\-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9\-]*[a-z0-9])?)*$`)
+
+// parsePort returns the port of a <from>:<to>(:<protocol>),
+// <host>:<from>:<to>(:<protocol>) or unix:<path>:<to>(:<protocol>) segment,
+// the port is nil when the segment isn't a port definition. A trailing
+// :proxy sends a PROXY protocol header to the published service.
+func parsePort(segment string, enableEdgeE2E bool) (*config.Port, error) {
+	port := &config.Port{
+		Protocol: config.AnyProtocol,
+	}
+	var err error
+	var protocol string
+	if portDef := portPattern.FindStringSubmatch(segment); len(portDef) >= 2 {
+		port.Src, err = strconv.Atoi(portDef[1])
+		if err != nil {
+			return nil, err
+		}
+		if len(portDef) < 4 || portDef[3] == "" {
+			port.To = port.Src
+		} else {
+			port.To, err = strconv.Atoi(portDef[3])
+			if err != nil {
+				err = fmt.Errorf("to port number expected but got: %v in %v", portDef[3], segment)
+				return nil, err
+			}
+		}
+		if len(portDef) >= 7 {
+			protocol = portDef[5]
+			port.ProxyProtocol = portDef[6] != ""
+		}
+	} else if portDef := hostPortPattern.FindStringSubmatch(segment); len(portDef) >= 4 {
+		port.SrcHost = strings.TrimSuffix(strings.TrimPrefix(portDef[1], "["), "]")
+		if strings.HasPrefix(portDef[1], "[") && net.ParseIP(port.SrcHost) == nil {
+			err = fmt.Errorf("port host should be a valid ip address but got: %v in %v", portDef[1], segment)
+			return nil, err
+		}
+		port.Src, err = strconv.Atoi(portDef[2])
+		if err != nil {
+			return nil, err
+		}
+		port.To, err = strconv.Atoi(portDef[3])
+		if err != nil {
+			err = fmt.Errorf("to port number expected but got: %v in %v", portDef[3], segment)
+			return nil, err
+		}
+		if len(portDef) >= 7 {
+			protocol = portDef[5]
+			port.ProxyProtocol = portDef[6] != ""
+		}
+	} else if portDef := socketPortPattern.FindStringSubmatch(segment); len(portDef) >= 3 {
+		port.SrcSocket = filepath.Clean(portDef[1])
+		port.To, err = strconv.Atoi(portDef[2])
+		if err != nil {
+			err = fmt.Errorf("to port number expected but got: %v in %v", portDef[2], segment)
+			return nil, err
+		}
+		if len(portDef) >= 6 {
+			protocol = portDef[4]
+			port.ProxyProtocol = portDef[5] != ""
+		}
+	} else {
+		return nil, nil
+	}
+
+	if len(port.SrcSocket) == 0 && !util.IsPort(port.Src) {
+		err = fmt.Errorf("src port number should be bigger than 1 and smaller than 65535")
+		return nil, err
+	}
+	if !util.IsPort(port.To) {
+		err = fmt.Errorf("to port number should be bigger than 1 and smaller than 65535")
+		return nil, err
+	}
+
+	switch protocol {
+	case "tls":
+		if !enableEdgeE2E {
+			err = fmt.Errorf("should enable e2e to use tle protocol")
+			return nil, err
+		}
+		port.Protocol = config.TLSProtocol
+	case "tcp":
+		port.Protocol = config.TCPProtocol
+	case "udp":
+		port.Protocol = config.UDPProtocol
+	case "http":
+		port.Protocol = config.HTTPProtocol
+	case "any":
+		port.Protocol = config.AnyProtocol
+	case "":
+		port.Protocol = config.AnyProtocol
+	default:
+		err = fmt.Errorf("port unknown protocol %v in: %v", protocol, segment)
+		return nil, err
+	}
+	if port.ProxyProtocol && (port.Protocol == config.UDPProtocol || port.Protocol == config.HTTPProtocol) {
+		err = fmt.Errorf("proxy protocol is not supported for %s ports in: %v", protocol, segment)
+		return nil, err
+	}
+	return port, nil
+}
 
 func parsePorts(portStrings []string, mode int, enableEdgeE2E bool) ([]*config.Port, error) {
 	ports := []*config.Port{}
 	for _, portString := range portStrings {
 		segments := strings.Split(portString, ",")
 		allowlist := make(map[util.Address]bool)
+		names := []string{}
+		first := len(ports)
 		for _, segment := range segments {
-			portDef := portPattern.FindStringSubmatch(segment)
-			// fmt.Printf("%+v (%v)\n", portDef, len(portDef))
-
-			if len(portDef) >= 2 {
-				srcPort, err := strconv.Atoi(portDef[1])
-				if err != nil {
-					return nil, err
-				}
-				if !util.IsPort(srcPort) {
-					err = fmt.Errorf("src port number should be bigger than 1 and smaller than 65535")
-					return nil, err
-				}
-				var toPort int
-				if len(portDef) < 4 || portDef[3] == "" {
-					toPort = srcPort
-				} else {
-					toPort, err = strconv.Atoi(portDef[3])
-					if err != nil {
-						err = fmt.Errorf("to port number expected but got: %v in %v", portDef[3], segment)
-						return nil, err
-					}
-					if !util.IsPort(toPort) {
-						err = fmt.Errorf("to port number should be bigger than 1 and smaller than 65535")
-						return nil, err
-					}
-				}
-
-				port := &config.Port{
-					Src:       srcPort,
-					To:        toPort,
-					Mode:      mode,
-					Protocol:  config.AnyProtocol,
-					Allowlist: allowlist,
-				}
-
-				if len(portDef) >= 6 {
-					switch portDef[5] {
-					case "tls":
-						if !enableEdgeE2E {
-							err = fmt.Errorf("should enable e2e to use tle protocol")
-							return nil, err
-						}
-						port.Protocol = config.TLSProtocol
-					case "tcp":
-						port.Protocol = config.TCPProtocol
-					case "udp":
-						port.Protocol = config.UDPProtocol
-					case "any":
-						port.Protocol = config.AnyProtocol
-					case "":
-						port.Protocol = config.AnyProtocol
-					default:
-						err = fmt.Errorf("port unknown protocol %v in: %v", portDef[4], segment)
-						return nil, err
-					}
-				}
+			port, err := parsePort(segment, enableEdgeE2E)
+			if err != nil {
+				return nil, err
+			}
+			if port != nil {
+				port.Mode = mode
+				port.Allowlist = allowlist
 				ports = append(ports, port)
+			} else if isValidBNS(segment) {
+				if !util.StringsContain(names, segment) {
+					names = append(names, segment)
+				}
 			} else {
 				access := accessPattern.FindString(segment)
 				if access == "" {
-					err := fmt.Errorf("port format expected <from>:<to>(:<protocol>) or <address> but got: %v", segment)
+					err := fmt.Errorf("port format expected <from>:<to>(:<protocol>), <host>:<from>:<to>(:<protocol>), unix:<path>:<to>(:<protocol>), <address> or <bns name> but got: %v", segment)
 					return nil, err
 				}
 
@@ -130,19 +208,23 @@ func parsePorts(portStrings []string, mode int, enableEdgeE2E bool) ([]*config.P
 				allowlist[addr] = true
 			}
 		}
+		for _, port := range ports[first:] {
+			port.BNSNames = names
+		}
 	}
 
 	for _, v := range ports {
-		if mode == config.PublicPublishedMode && len(v.Allowlist) > 0 {
+		allowed := len(v.Allowlist) + len(v.BNSNames)
+		if mode == config.PublicPublishedMode && allowed > 0 {
 			err := fmt.Errorf("public port publishing does not support providing addresses")
 			return nil, err
 		}
-		if mode == config.PrivatePublishedMode && len(v.Allowlist) == 0 {
+		if mode == config.PrivatePublishedMode && allowed == 0 {
 			err := fmt.Errorf("private port publishing reuquires providing at least one address")
 			return nil, err
 		}
 		// limit fleet address size when publish protected port
-		if mode == config.ProtectedPublishedMode && len(v.Allowlist) > 5 {
+		if mode == config.ProtectedPublishedMode && allowed > 5 {
 			err := fmt.Errorf("fleet address size should not exceeds 5 when publish protected port")
 			return nil, err
 		}
@@ -151,6 +233,18 @@ func parsePorts(portStrings []string, mode int, enableEdgeE2E bool) ([]*config.P
 	return ports, nil
 }
 
+func parseBinds(binds []string) ([]config.Bind, error) {
+	ret := make([]config.Bind, 0, len(binds))
+	for _, str := range binds {
+		bind, err := parseBind(str)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, *bind)
+	}
+	return ret, nil
+}
+
 func parseBind(bind string) (*config.Bind, error) {
 	elements := strings.Split(bind, ":")
 	if len(elements) == 3 {
@@ -191,11 +285,10 @@ func parseBind(bind string) (*config.Bind, error) {
 	return ret, nil
 }
 
-func publishHandler() (err error) {
-	cfg := config.AppConfig
-	portString := make(map[int]*config.Port)
-
-	// copy to config
+// parsePublishedPorts returns the published ports of all modes, the static
+// file server is published on the http port when no other port is given
+func parsePublishedPorts(cfg *config.Config) (portString map[int]*config.Port, err error) {
+	portString = make(map[int]*config.Port)
 	ports, err := parsePorts(cfg.PublicPublishedPorts, config.PublicPublishedMode, cfg.EnableEdgeE2E)
 	if err != nil {
 		return
@@ -229,9 +322,235 @@ func publishHandler() (err error) {
 		}
 		portString[port.To] = port
 	}
-	cfg.PublishedPorts = portString
+	if (staticServer.Enabled || len(staticServer.RootDirectory) > 0) && len(portString) == 0 {
+		// publish the static by default if enabled
+		portString[httpPort] = &config.Port{
+			Src:      staticServer.Port,
+			To:       httpPort,
+			Mode:     config.PublicPublishedMode,
+			Protocol: config.AnyProtocol,
+		}
+		if staticServer.WebDAV {
+			// webdav writes are authorized by the identity headers
+			portString[httpPort].Protocol = config.HTTPProtocol
+		}
+	}
+	if staticServer.WebDAV {
+		for _, port := range portString {
+			if port.Src == staticServer.Port && len(port.SrcHost) == 0 && len(port.SrcSocket) == 0 && port.Protocol != config.HTTPProtocol {
+				err = fmt.Errorf("port %d of the webdav server should use the http protocol", port.To)
+				return
+			}
+		}
+	}
+	err = parseVirtualHosts(portString, cfg.VirtualHosts)
+	if err != nil {
+		return
+	}
+	err = parseHealthChecks(portString, cfg.SHealthChecks, cfg.MaintenancePage)
+	if err != nil {
+		return
+	}
+	for _, port := range portString {
+		targets := []*config.Port{port}
+		for _, hostPort := range port.Hosts {
+			targets = append(targets, hostPort)
+		}
+		for _, target := range targets {
+			if !isTargetHostAllowed(target.SrcHost, cfg.PublishedTargetHosts) {
+				err = fmt.Errorf("port target host %s is not one of the target hosts: %s", target.SrcHost, cfg.PublishedTargetHosts.String())
+				return
+			}
+		}
+	}
+	return
+}
+
+// parseHealthChecks enables the <port> tcp and <port>:<path> http health
+// checks of the published ports, unhealthy http ports serve the maintenance
+// page when it's given
+func parseHealthChecks(ports map[int]*config.Port, checks []string, maintenancePage string) error {
+	if len(maintenancePage) > 0 {
+		if _, err := os.Stat(maintenancePage); err != nil {
+			return fmt.Errorf("maintenance page not found: %v", err)
+		}
+	}
+	for _, check := range checks {
+		def := healthPattern.FindStringSubmatch(check)
+		if len(def) == 0 {
+			return fmt.Errorf("health check expected <port> or <port>:<path> but got: %v", check)
+		}
+		to, _ := strconv.Atoi(def[1])
+		port := ports[to]
+		if port == nil {
+			return fmt.Errorf("health check port %d is not published", to)
+		}
+		if port.Protocol == config.UDPProtocol {
+			return fmt.Errorf("health check port %d should not be an udp port", to)
+		}
+		port.HealthCheck = true
+		port.HealthPath = def[3]
+		if len(port.HealthPath) > 0 || port.Protocol == config.HTTPProtocol {
+			port.MaintenancePage = maintenancePage
+		}
+	}
+	return nil
+}
+
+// parseVirtualHostTarget returns a copy of the port with the service of the
+// <port>, <host>:<port> or unix:<path> target
+func parseVirtualHostTarget(port *config.Port, target string) (*config.Port, error) {
+	hostPort := *port
+	hostPort.Hosts = nil
+	hostPort.SrcHost = ""
+	hostPort.SrcSocket = ""
+	if strings.HasPrefix(target, "unix:/") {
+		hostPort.SrcSocket = filepath.Clean(strings.TrimPrefix(target, "unix:"))
+		return &hostPort, nil
+	}
+	src := target
+	if host, p, err := net.SplitHostPort(target); err == nil {
+		hostPort.SrcHost = host
+		src = p
+	}
+	var err error
+	hostPort.Src, err = strconv.Atoi(src)
+	if err != nil || !util.IsPort(hostPort.Src) {
+		return nil, fmt.Errorf("virtual host target expected <port>, <host>:<port> or unix:<path> but got: %v", target)
+	}
+	return &hostPort, nil
+}
+
+// parseVirtualHosts adds the virtual hosts to the published ports
+func parseVirtualHosts(ports map[int]*config.Port, vhosts []config.VirtualHost) error {
+	for _, vhost := range vhosts {
+		port := ports[vhost.Port]
+		if port == nil {
+			return fmt.Errorf("virtual host %s port %d is not published", vhost.Host, vhost.Port)
+		}
+		if port.Protocol == config.UDPProtocol {
+			return fmt.Errorf("virtual host %s port %d should not be an udp port", vhost.Host, vhost.Port)
+		}
+		name := strings.TrimSuffix(strings.ToLower(vhost.Host), ".")
+		if !vhostPattern.MatchString(name) {
+			return fmt.Errorf("virtual host expected <host> or *.<host> but got: %v", vhost.Host)
+		}
+		hostPort, err := parseVirtualHostTarget(port, vhost.Target)
+		if err != nil {
+			return err
+		}
+		if port.Hosts == nil {
+			port.Hosts = make(map[string]*config.Port)
+		}
+		if port.Hosts[name] != nil {
+			return fmt.Errorf("virtual host %s specified twice for port %d", vhost.Host, vhost.Port)
+		}
+		port.Hosts[name] = hostPort
+	}
+	return nil
+}
+
+// isTargetHostAllowed returns true when the published service host is
+// local or matches one of the allowed hosts, ip addresses or ip ranges.
+// All hosts are allowed when no allowed hosts are given.
+func isTargetHostAllowed(host string, allowed []string) bool {
+	if len(host) == 0 || len(allowed) == 0 || host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, host) {
+			return true
+		}
+		if ip == nil {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(a); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+		if allowedIP := net.ParseIP(a); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// portSource returns the human readable published service of the port
+func portSource(port *config.Port) string {
+	if len(port.SrcSocket) > 0 {
+		return "unix:" + port.SrcSocket
+	}
+	if len(port.SrcHost) > 0 {
+		return net.JoinHostPort(port.SrcHost, strconv.Itoa(port.Src))
+	}
+	return strconv.Itoa(port.Src)
+}
+
+// sortedHosts returns the sorted virtual host names of the port
+func sortedHosts(port *config.Port) []string {
+	names := make([]string, 0, len(port.Hosts))
+	for name := range port.Hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// portProtocol returns the human readable protocol of the port
+func portProtocol(port *config.Port) string {
+	if port.ProxyProtocol {
+		return config.ProtocolName(port.Protocol) + "+proxy"
+	}
+	return config.ProtocolName(port.Protocol)
+}
+
+// isLoopbackHost returns true if the host is localhost or a loopback address
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func publishHandler() (err error) {
+	cfg := config.AppConfig
+	cfg.PublishedPorts, err = parsePublishedPorts(cfg)
+	if err != nil {
+		return
+	}
 
 	if staticServer.Enabled || len(staticServer.RootDirectory) > 0 {
+		staticServer.WriteAllowlist, err = parseAccessList(cfg.SHTTPWriters)
+		if err != nil {
+			return
+		}
+		if staticServer.WebDAV && !isLoopbackHost(staticServer.Host) {
+			err = fmt.Errorf("-http_webdav needs a loopback -http_host, the static server would be reachable by other hosts")
+			return
+		}
+		if staticServer.WebDAV && len(staticServer.WriteAllowlist) == 0 {
+			cfg.Logger.Warn("WebDAV is enabled without -http_writers, all uploads will be rejected")
+		}
+		if len(staticServer.CertFile) > 0 || len(staticServer.KeyFile) > 0 {
+			if len(staticServer.CertFile) == 0 || len(staticServer.KeyFile) == 0 {
+				err = fmt.Errorf("-http_cert and -http_key should be given together")
+				return
+			}
+			staticServer.TLS = true
+		}
+		if staticServer.TLS {
+			if len(staticServer.CertFile) == 0 {
+				addr := net.JoinHostPort(staticServer.Host, strconv.Itoa(staticServer.Port))
+				staticServer.TLSConfig, err = staticTLSConfig(addr)
+				if err != nil {
+					return
+				}
+			}
+		}
 		go func() {
 			err := staticServer.ListenAndServe()
 			if err != nil {
@@ -244,14 +563,10 @@ func publishHandler() (err error) {
 		app.Defer(func() {
 			staticServer.Close()
 		})
-		// publish the static by default if enabled
-		if len(cfg.PublishedPorts) == 0 {
-			cfg.PublishedPorts[httpPort] = &config.Port{
-				Src:      staticServer.Port,
-				To:       httpPort,
-				Mode:     config.PublicPublishedMode,
-				Protocol: config.AnyProtocol,
-			}
+		if staticServer.WebDAV {
+			// webdav requests of devices are served in process, so only
+			// they carry a trusted device identity
+			app.datapool.SetLocalHandler(staticServer.Port, staticServer.Handler())
 		}
 	}
 
@@ -270,8 +585,15 @@ func publishHandler() (err error) {
 	}
 	if len(cfg.PublishedPorts) > 0 {
 		printInfo("")
-		pool.SetPublishedPorts(cfg.PublishedPorts)
-		for _, port := range cfg.PublishedPorts {
+		ports := cfg.PublishedPorts
+		err = app.SetPublishedPorts(ports)
+		if err != nil {
+			return
+		}
+		app.WatchBNSNames()
+		app.WatchHealth()
+		app.WatchGrants()
+		for _, port := range ports {
 			if port.To == httpPort {
 				if port.Mode == config.PublicPublishedMode {
 					printLabel("HTTP Gateway Enabled", fmt.Sprintf("http://%s.diode.link/", cfg.ClientAddr.HexString()))
@@ -280,13 +602,13 @@ func publishHandler() (err error) {
 			}
 		}
 		printLabel("Port      <name>", "<extern>     <mode>    <protocol>     <allowlist>")
-		for _, port := range cfg.PublishedPorts {
-			addrs := make([]string, 0, len(port.Allowlist))
-			for addr := range port.Allowlist {
-				addrs = append(addrs, addr.HexString())
+		for _, port := range ports {
+			printLabel(fmt.Sprintf("Port      %5s", portSource(port)), fmt.Sprintf("%8d  %10s       %s        %s", port.To, config.ModeName(port.Mode), portProtocol(port), allowlistString(port)))
+		}
+		for _, port := range ports {
+			for _, name := range sortedHosts(port) {
+				printLabel("Virtual host", fmt.Sprintf("%s:%d => %s", name, port.To, portSource(port.Hosts[name])))
 			}
-
-			printLabel(fmt.Sprintf("Port      %5d", port.Src), fmt.Sprintf("%8d  %10s       %s        %s", port.To, config.ModeName(port.Mode), config.ProtocolName(port.Protocol), strings.Join(addrs, ",")))
 		}
 	}
 	if cfg.EnableAPIServer {
@@ -296,17 +618,26 @@ func publishHandler() (err error) {
 		app.SetConfigAPIServer(configAPIServer)
 	}
 	socksServer := rpc.NewSocksServer(app.datapool)
+	users, err := socksUsers(cfg)
+	if err != nil {
+		return
+	}
+	routes, err := rpc.NewRouter(cfg.SocksRoutes)
+	if err != nil {
+		return
+	}
 	socksServer.SetConfig(&rpc.Config{
 		Addr:            cfg.SocksServerAddr(),
 		FleetAddr:       cfg.FleetAddr,
-		Blocklists:      cfg.Blocklists,
-		Allowlists:      cfg.Allowlists,
 		EnableProxy:     true,
 		ProxyServerAddr: cfg.ProxyServerAddr(),
 		Fallback:        cfg.SocksFallback,
+		Users:           users,
+		Routes:          routes,
 	})
+	// the socks server also keeps the binds
+	app.SetSocksServer(socksServer)
 	if cfg.EnableSocksServer {
-		app.SetSocksServer(socksServer)
 		if err = socksServer.Start(); err != nil {
 			cfg.Logger.Error(err.Error())
 			return
@@ -0,0 +1,164 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/util"
+)
+
+const (
+	// bnsResolveInterval is how often the bns names of published port
+	// allowlists are resolved again
+	bnsResolveInterval = 5 * time.Minute
+)
+
+// allowlistString returns the addresses and bns names of the port allowlist
+func allowlistString(port *config.Port) string {
+	addrs := make([]string, 0, len(port.Allowlist))
+	for addr := range port.Allowlist {
+		addrs = append(addrs, addr.HexString())
+	}
+	sort.Strings(addrs)
+	return strings.Join(append(addrs, port.BNSNames...), ",")
+}
+
+// portBNSNames returns the bns names used in the allowlists of the ports
+func portBNSNames(ports map[int]*config.Port) []string {
+	names := []string{}
+	for _, port := range ports {
+		for _, name := range port.BNSNames {
+			if !util.StringsContain(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolvedPorts returns copies of the ports with the resolved bns names
+// added to the allowlists, the given ports are not modified
+func resolvedPorts(ports map[int]*config.Port, names map[string]util.Address) map[int]*config.Port {
+	ret := make(map[int]*config.Port, len(ports))
+	for to, port := range ports {
+		if len(port.BNSNames) == 0 {
+			ret[to] = port
+			continue
+		}
+		resolved := *port
+		resolved.Allowlist = make(map[util.Address]bool, len(port.Allowlist)+len(port.BNSNames))
+		for addr := range port.Allowlist {
+			resolved.Allowlist[addr] = true
+		}
+		for _, name := range port.BNSNames {
+			if addr, ok := names[name]; ok {
+				resolved.Allowlist[addr] = true
+			}
+		}
+		ret[to] = &resolved
+	}
+	return ret
+}
+
+func (dio *Diode) resolveBNS(name string) (addr util.Address, err error) {
+	client := dio.datapool.GetNearestClient()
+	if client == nil {
+		err = fmt.Errorf("not connected to any node")
+		return
+	}
+	return client.ResolveBNS(name)
+}
+
+// resolvePortNames resolves the bns names of the port allowlists, names that
+// were resolved before are taken from the last resolution
+func (dio *Diode) resolvePortNames(ports map[int]*config.Port) (map[string]util.Address, error) {
+	names := make(map[string]util.Address)
+	for _, name := range portBNSNames(ports) {
+		if addr, ok := dio.bnsNames[name]; ok {
+			names[name] = addr
+			continue
+		}
+		addr, err := dio.resolveBNS(name)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't resolve bns name %s of port allowlist: %v", name, err)
+		}
+		names[name] = addr
+	}
+	return names, nil
+}
+
+// applyPublishedPorts publishes the ports with the resolved bns names
+func (dio *Diode) applyPublishedPorts(ports map[int]*config.Port, names map[string]util.Address) {
+	dio.publishedPorts = ports
+	dio.bnsNames = names
+	dio.config.PublishedPorts = resolvedPorts(ports, names)
+	dio.datapool.SetPublishedPorts(dio.config.PublishedPorts)
+}
+
+// SetPublishedPorts resolves the bns names of the port allowlists and
+// publishes the ports
+func (dio *Diode) SetPublishedPorts(ports map[int]*config.Port) error {
+	dio.reloadMx.Lock()
+	defer dio.reloadMx.Unlock()
+	names, err := dio.resolvePortNames(ports)
+	if err != nil {
+		return err
+	}
+	for _, name := range portBNSNames(ports) {
+		addr := names[name]
+		printLabel("Resolved BNS name", fmt.Sprintf("%s => %s", name, addr.HexString()))
+	}
+	dio.applyPublishedPorts(ports, names)
+	return nil
+}
+
+// WatchBNSNames resolves the bns names of the port allowlists periodically
+// so that the allowlists follow names that were moved to another device
+func (dio *Diode) WatchBNSNames() {
+	ticker := time.NewTicker(bnsResolveInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-dio.closeCh:
+				return
+			case <-ticker.C:
+				dio.refreshBNSNames()
+			}
+		}
+	}()
+}
+
+func (dio *Diode) refreshBNSNames() {
+	dio.reloadMx.Lock()
+	defer dio.reloadMx.Unlock()
+	if len(dio.bnsNames) == 0 {
+		return
+	}
+	names := make(map[string]util.Address, len(dio.bnsNames))
+	changed := false
+	for name, old := range dio.bnsNames {
+		addr, err := dio.resolveBNS(name)
+		if err != nil {
+			// keep the last known address, the node might be unreachable
+			dio.config.Logger.Warn("Couldn't resolve bns name %s of port allowlist: %v", name, err)
+			names[name] = old
+			continue
+		}
+		if addr != old {
+			printLabel("BNS name moved", fmt.Sprintf("%s => %s (was %s)", name, addr.HexString(), old.HexString()))
+			changed = true
+		}
+		names[name] = addr
+	}
+	if changed {
+		dio.applyPublishedPorts(dio.publishedPorts, names)
+	}
+}
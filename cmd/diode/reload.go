@@ -0,0 +1,402 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/rpc"
+	"github.com/diodechain/diode_go_client/util"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// configWatchInterval is how often the config file is checked for changes
+	configWatchInterval = 2 * time.Second
+)
+
+// WatchConfig reloads the config file when it was modified or when
+// the process receives SIGHUP
+func (dio *Diode) WatchConfig() {
+	cfg := dio.config
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	ticker := time.NewTicker(configWatchInterval)
+	lastMod := configModTime(cfg.ConfigFilePath)
+	go func() {
+		defer signal.Stop(sigChan)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-dio.closeCh:
+				return
+			case <-sigChan:
+				if len(cfg.ConfigFilePath) == 0 {
+					cfg.Logger.Warn("Received SIGHUP but no config file was given (use -configpath)")
+					continue
+				}
+				cfg.Logger.Info("Received SIGHUP, reloading config file %s", cfg.ConfigFilePath)
+			case <-ticker.C:
+				if len(cfg.ConfigFilePath) == 0 {
+					continue
+				}
+				modTime := configModTime(cfg.ConfigFilePath)
+				if modTime.Equal(lastMod) {
+					continue
+				}
+				lastMod = modTime
+				cfg.Logger.Info("Config file %s changed, reloading", cfg.ConfigFilePath)
+			}
+			err := dio.ReloadConfig()
+			if err != nil {
+				cfg.Logger.Error("Rejected config file %s: %v", cfg.ConfigFilePath, err)
+			}
+		}
+	}()
+}
+
+func configModTime(filePath string) (modTime time.Time) {
+	if len(filePath) == 0 {
+		return
+	}
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return
+	}
+	return fi.ModTime()
+}
+
+// ReloadConfig reads the config file and applies published ports, binds,
+// socks users and routes, block and allow lists and node addresses. The
+// config is validated before anything is applied, an invalid config leaves
+// the running state untouched.
+func (dio *Diode) ReloadConfig() error {
+	dio.reloadMx.Lock()
+	defer dio.reloadMx.Unlock()
+	cfg := dio.config
+	cfgBytes, err := config.LoadConfigFromFile(cfg.ConfigFilePath)
+	if err != nil {
+		return err
+	}
+	next := dio.flagConfig
+	err = yaml.Unmarshal(cfgBytes, &next)
+	if err != nil {
+		return err
+	}
+	dio.overrides.Apply(&next)
+
+	// validate everything first
+	if len(next.RemoteRPCAddrs) == 0 {
+		next.RemoteRPCAddrs = bootDiodeAddrs[:]
+	}
+	for _, addr := range next.RemoteRPCAddrs {
+		if !isValidRPCAddress(addr) {
+			return fmt.Errorf("invalid node address: %v", addr)
+		}
+	}
+	binds, err := parseBinds(next.SBinds)
+	if err != nil {
+		return err
+	}
+	blocklists, err := parseAccessList(next.SBlocklists)
+	if err != nil {
+		return err
+	}
+	allowlists, err := parseAccessList(next.SAllowlists)
+	if err != nil {
+		return err
+	}
+	users, err := loadSocksUsers(&next)
+	if err != nil {
+		return err
+	}
+	routes, err := rpc.NewRouter(next.SocksRoutes)
+	if err != nil {
+		return err
+	}
+	publishing := dio.cmd != nil && dio.cmd.Name == "publish"
+	var ports map[int]*config.Port
+	var names map[string]util.Address
+	if publishing {
+		ports, err = parsePublishedPorts(&next)
+		if err != nil {
+			return err
+		}
+		names, err = dio.resolvePortNames(ports)
+		if err != nil {
+			return err
+		}
+	}
+
+	changes := 0
+	if publishing {
+		changes += dio.reloadPorts(ports, names)
+		cfg.PublicPublishedPorts = next.PublicPublishedPorts
+		cfg.ProtectedPublishedPorts = next.ProtectedPublishedPorts
+		cfg.PrivatePublishedPorts = next.PrivatePublishedPorts
+		cfg.PublishedTargetHosts = next.PublishedTargetHosts
+		cfg.VirtualHosts = next.VirtualHosts
+		cfg.SHealthChecks = next.SHealthChecks
+		cfg.MaintenancePage = next.MaintenancePage
+		cfg.AllowlistCacheAge = next.AllowlistCacheAge
+	}
+	changes += dio.reloadBinds(binds)
+	cfg.SBinds = next.SBinds
+	changes += dio.reloadSocksUsers(users)
+	cfg.SocksUsers = next.SocksUsers
+	changes += dio.reloadSocksRoutes(routes, next.SocksRoutes)
+	cfg.SocksRoutes = next.SocksRoutes
+	changes += dio.reloadAccessLists(blocklists, allowlists)
+	cfg.SBlocklists = next.SBlocklists
+	cfg.SAllowlists = next.SAllowlists
+	changes += dio.reloadNodes(next.RemoteRPCAddrs)
+	if changes == 0 {
+		printInfo("Config reloaded, nothing changed")
+	} else {
+		printLabel("Config reloaded", fmt.Sprintf("%d changes", changes))
+	}
+	return nil
+}
+
+func (dio *Diode) reloadPorts(ports map[int]*config.Port, names map[string]util.Address) (changes int) {
+	for to, port := range ports {
+		old := dio.publishedPorts[to]
+		if old == nil {
+			printLabel("Added port", portString(port))
+			changes++
+		} else if !samePort(old, port) {
+			printLabel("Changed port", portString(port))
+			changes++
+		}
+	}
+	for to, port := range dio.publishedPorts {
+		if ports[to] == nil {
+			printLabel("Removed port", portString(port))
+			changes++
+		}
+	}
+	if changes > 0 {
+		dio.applyPublishedPorts(ports, names)
+	}
+	return
+}
+
+func portString(port *config.Port) string {
+	str := strings.TrimSpace(fmt.Sprintf("%s:%d %s %s %s", portSource(port), port.To, config.ModeName(port.Mode), portProtocol(port), allowlistString(port)))
+	for _, name := range sortedHosts(port) {
+		str += fmt.Sprintf(" %s=%s", name, portSource(port.Hosts[name]))
+	}
+	return str
+}
+
+func samePort(a *config.Port, b *config.Port) bool {
+	if a.Src != b.Src || a.To != b.To || a.Mode != b.Mode || a.Protocol != b.Protocol || a.SrcHost != b.SrcHost || a.SrcSocket != b.SrcSocket || a.ProxyProtocol != b.ProxyProtocol {
+		return false
+	}
+	if len(a.BNSNames) != len(b.BNSNames) {
+		return false
+	}
+	for _, name := range a.BNSNames {
+		if !util.StringsContain(b.BNSNames, name) {
+			return false
+		}
+	}
+	if a.HealthCheck != b.HealthCheck || a.HealthPath != b.HealthPath || a.MaintenancePage != b.MaintenancePage {
+		return false
+	}
+	if len(a.Hosts) != len(b.Hosts) {
+		return false
+	}
+	for name, hostPort := range a.Hosts {
+		if b.Hosts[name] == nil || portSource(hostPort) != portSource(b.Hosts[name]) {
+			return false
+		}
+	}
+	return sameAddresses(a.Allowlist, b.Allowlist)
+}
+
+func sameAddresses(a map[util.Address]bool, b map[util.Address]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for addr := range a {
+		if !b[addr] {
+			return false
+		}
+	}
+	return true
+}
+
+func bindString(bind config.Bind) string {
+	return fmt.Sprintf("%d:%s:%d:%s", bind.LocalPort, bind.To, bind.ToPort, config.ProtocolName(bind.Protocol))
+}
+
+func (dio *Diode) reloadBinds(binds []config.Bind) (changes int) {
+	cfg := dio.config
+	for _, bind := range binds {
+		if !containsBind(cfg.Binds, bind) {
+			printLabel("Added bind", bindString(bind))
+			changes++
+		}
+	}
+	for _, bind := range cfg.Binds {
+		if !containsBind(binds, bind) {
+			printLabel("Removed bind", bindString(bind))
+			changes++
+		}
+	}
+	if changes == 0 {
+		return
+	}
+	cfg.Binds = binds
+	if dio.socksServer != nil {
+		dio.socksServer.SetBinds(binds)
+	} else {
+		cfg.Logger.Warn("Binds changed but %s doesn't support binds", dio.cmd.Name)
+	}
+	return
+}
+
+// reloadSocksUsers replaces the users of the socks server, open connections
+// are kept
+func (dio *Diode) reloadSocksUsers(users map[string]*config.SocksUser) (changes int) {
+	if dio.socksServer == nil {
+		return
+	}
+	old := dio.socksServer.Config()
+	for name, user := range users {
+		if prev, ok := old.Users[name]; !ok {
+			printLabel("Added socks user", name)
+			changes++
+		} else if !reflect.DeepEqual(prev, user) {
+			printLabel("Changed socks user", name)
+			changes++
+		}
+	}
+	for name := range old.Users {
+		if _, ok := users[name]; !ok {
+			printLabel("Removed socks user", name)
+			changes++
+		}
+	}
+	if changes == 0 {
+		return
+	}
+	next := *old
+	next.Users = users
+	dio.socksServer.SetConfig(&next)
+	return
+}
+
+// reloadSocksRoutes replaces the routes of the socks server, open
+// connections keep their route
+func (dio *Diode) reloadSocksRoutes(routes *rpc.Router, defs []config.SocksRoute) (changes int) {
+	if dio.socksServer == nil || reflect.DeepEqual(dio.config.SocksRoutes, defs) {
+		return
+	}
+	printLabel("Changed socks routes", fmt.Sprintf("%d routes", len(defs)))
+	next := *dio.socksServer.Config()
+	next.Routes = routes
+	dio.socksServer.SetConfig(&next)
+	return 1
+}
+
+func containsBind(binds []config.Bind, bind config.Bind) bool {
+	for _, b := range binds {
+		if b == bind {
+			return true
+		}
+	}
+	return false
+}
+
+func (dio *Diode) reloadAccessLists(blocklists map[util.Address]bool, allowlists map[util.Address]bool) (changes int) {
+	cfg := dio.config
+	if !sameAddresses(cfg.Blocklists, blocklists) {
+		printLabel("Changed blocklists", fmt.Sprintf("%d addresses", len(blocklists)))
+		changes++
+	}
+	if !sameAddresses(cfg.Allowlists, allowlists) {
+		printLabel("Changed allowlists", fmt.Sprintf("%d addresses", len(allowlists)))
+		changes++
+	}
+	if changes > 0 {
+		cfg.Blocklists = blocklists
+		cfg.Allowlists = allowlists
+		dio.datapool.SetAccessLists(blocklists, allowlists)
+	}
+	return
+}
+
+// reloadNodes connects to added node addresses and disconnects from removed
+// ones, the last connected node is never closed so that running tunnels
+// survive a config without any reachable node
+func (dio *Diode) reloadNodes(addrs []string) (changes int) {
+	cfg := dio.config
+	added := []string{}
+	for _, addr := range addrs {
+		if !util.StringsContain(cfg.RemoteRPCAddrs, addr) {
+			printLabel("Added node", addr)
+			added = append(added, addr)
+			changes++
+		}
+	}
+	removed := []string{}
+	for _, addr := range cfg.RemoteRPCAddrs {
+		if !util.StringsContain(addrs, addr) {
+			printLabel("Removed node", addr)
+			removed = append(removed, addr)
+			changes++
+		}
+	}
+	if changes == 0 {
+		return
+	}
+	cfg.RemoteRPCAddrs = addrs
+	clients := dio.datapool.GetClients()
+	connected := 0
+	for _, client := range clients {
+		if !util.StringsContain(removed, client.Host()) {
+			connected++
+		}
+	}
+	// single connection commands only reconnect when their node was removed
+	if dio.cmd != nil && dio.cmd.SingleConnection && connected > 0 {
+		added = []string{}
+	}
+	for _, addr := range added {
+		client, err := rpc.DoConnect(addr, cfg, dio.datapool)
+		if err != nil {
+			cfg.Logger.Error("Connection to host: %s failed: %v", addr, err)
+			if client != nil {
+				client.Close()
+			}
+			continue
+		}
+		if dio.addClient(client, true) {
+			connected++
+			if dio.cmd != nil && dio.cmd.SingleConnection {
+				break
+			}
+		}
+	}
+	for _, client := range clients {
+		if !util.StringsContain(removed, client.Host()) {
+			continue
+		}
+		if connected == 0 {
+			cfg.Logger.Warn("Keeping connection to removed node %s, no other node is connected", client.Host())
+			continue
+		}
+		client.Close()
+	}
+	return
+}
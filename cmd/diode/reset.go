@@ -192,6 +192,12 @@ func resetHandler() (err error) {
 		return err
 	}
 	cfg := config.AppConfig
+	backup, err := db.DB.AutoBackup()
+	if err != nil {
+		printError("Couldn't backup database", err)
+		return err
+	}
+	printLabel("Backup", backup)
 	client := app.datapool.GetNearestClient()
 	if cfg.Experimental {
 		err = doInitExp(cfg, client)
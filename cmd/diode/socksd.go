@@ -5,6 +5,10 @@ package main
 
 import (
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/diodechain/diode_go_client/command"
 	"github.com/diodechain/diode_go_client/config"
@@ -15,7 +19,7 @@ var (
 	socksdCmd = &command.Command{
 		Name:        "socksd",
 		HelpText:    `  Enable a socks proxy for use with browsers and other apps.`,
-		ExampleText: `  diode socksd -socksd_port 8082 -socksd_host 127.0.0.1`,
+		ExampleText: `  diode socksd -socksd_port 8082 -socksd_host 127.0.0.1 -http_proxy_port 8118 -dns_addr 127.0.0.1:5353`,
 		Run:         socksdHandler,
 		Type:        command.DaemonCommand,
 	}
@@ -26,6 +30,47 @@ func init() {
 	socksdCmd.Flag.StringVar(&cfg.SocksServerHost, "socksd_host", "127.0.0.1", "host of socks server listening to")
 	socksdCmd.Flag.IntVar(&cfg.SocksServerPort, "socksd_port", 1080, "port of socks server listening to")
 	socksdCmd.Flag.StringVar(&cfg.SocksFallback, "fallback", "localhost", "how to resolve web2 addresses")
+	socksdCmd.Flag.IntVar(&cfg.HTTPProxyPort, "http_proxy_port", 0, "port of the http forward proxy on the socksd host, 0 to disable")
+	socksdCmd.Flag.IntVar(&cfg.PACPort, "pac_port", 0, "port of the proxy auto-config file on the socksd host, 0 to disable")
+	socksdCmd.Flag.Var(&cfg.PACNames, "pac_bns", "bns name that is routed through the socks server, can be given multiple times")
+	socksdCmd.Flag.StringVar(&cfg.DNSServerAddr, "dns_addr", "", "address of the dns server for diode and bns names, empty to disable")
+	socksdCmd.Flag.StringVar(&cfg.DNSPool, "dns_pool", "127.77.0.0/16", "loopback network of the addresses handed out by the dns server")
+	socksdCmd.Flag.StringVar(&cfg.DNSPorts, "dns_ports", "80,443", "comma separated ports that are forwarded to resolved devices")
+	socksdCmd.Flag.DurationVar(&cfg.DNSTTL, "dns_ttl", time.Minute, "ttl of the dns answers")
+	socksdCmd.Flag.DurationVar(&cfg.DNSIdleTimeout, "dns_idle", 10*time.Minute, "time after which unused dns addresses are released")
+	socksdCmd.Flag.StringVar(&cfg.DNSUpstream, "dns_upstream", "", "dns server for other names, they are refused when empty")
+	socksdCmd.Flag.BoolVar(&cfg.DNSWithoutAuth, "dns_without_auth", false, "serve the dns server although socks users are configured, every local process can connect the resolved devices")
+}
+
+// dnsConfig returns the configuration of the dns server
+func dnsConfig(cfg *config.Config) (dnsCfg rpc.DNSConfig, err error) {
+	_, pool, err := net.ParseCIDR(cfg.DNSPool)
+	if err != nil {
+		return
+	}
+	ports := []int{}
+	for _, item := range strings.Split(cfg.DNSPorts, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(item))
+		if err != nil || port <= 0 || port > 65535 {
+			return dnsCfg, fmt.Errorf("invalid dns port: %v", item)
+		}
+		ports = append(ports, port)
+	}
+	if len(cfg.DNSUpstream) > 0 {
+		if _, _, err = net.SplitHostPort(cfg.DNSUpstream); err != nil {
+			cfg.DNSUpstream = net.JoinHostPort(cfg.DNSUpstream, "53")
+		}
+	}
+	dnsCfg = rpc.DNSConfig{
+		Addr:        cfg.DNSServerAddr,
+		Pool:        pool,
+		Ports:       ports,
+		TTL:         cfg.DNSTTL,
+		IdleTimeout: cfg.DNSIdleTimeout,
+		Upstream:    cfg.DNSUpstream,
+		WithoutAuth: cfg.DNSWithoutAuth,
+	}
+	return dnsCfg, nil
 }
 
 func socksdHandler() (err error) {
@@ -52,14 +97,29 @@ func socksdHandler() (err error) {
 			printLabel(fmt.Sprintf("Port      %5d", bind.LocalPort), fmt.Sprintf("%5s     %11s:%d", config.ProtocolName(bind.Protocol), bind.To, bind.ToPort))
 		}
 	}
+	users, err := socksUsers(cfg)
+	if err != nil {
+		return
+	}
+	if len(users) > 0 && len(cfg.DNSServerAddr) > 0 && !cfg.DNSWithoutAuth {
+		err = fmt.Errorf("-dns_addr doesn't authenticate socks users, every local process could connect the devices, add -dns_without_auth to allow it")
+		return
+	}
+	routes, err := rpc.NewRouter(cfg.SocksRoutes)
+	if err != nil {
+		return
+	}
 	socksServer.SetConfig(&rpc.Config{
 		Addr:            cfg.SocksServerAddr(),
 		FleetAddr:       cfg.FleetAddr,
-		Blocklists:      cfg.Blocklists,
-		Allowlists:      cfg.Allowlists,
 		EnableProxy:     false,
 		ProxyServerAddr: cfg.ProxyServerAddr(),
 		Fallback:        cfg.SocksFallback,
+		Users:           users,
+		Routes:          routes,
+		HTTPProxyAddr:   cfg.HTTPProxyAddr(),
+		PACAddr:         cfg.PACAddr(),
+		BNSNames:        cfg.PACNames,
 	})
 	app.SetSocksServer(socksServer)
 	if err = socksServer.Start(); err != nil {
@@ -67,6 +127,22 @@ func socksdHandler() (err error) {
 		return
 	}
 	app.SetSocksServer(socksServer)
+	if len(cfg.DNSServerAddr) > 0 {
+		var dnsCfg rpc.DNSConfig
+		dnsCfg, err = dnsConfig(cfg)
+		if err != nil {
+			return
+		}
+		dnsServer := rpc.NewDNSServer(socksServer)
+		if err = dnsServer.SetConfig(dnsCfg); err != nil {
+			return
+		}
+		if err = dnsServer.Start(); err != nil {
+			cfg.Logger.Error(err.Error())
+			return
+		}
+		app.Defer(dnsServer.Close)
+	}
 	app.Wait()
 	return
 }
@@ -0,0 +1,59 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/diodechain/diode_go_client/command"
+	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/rpc"
+)
+
+var (
+	socksRouteCmd = &command.Command{
+		Name:        "socksroute",
+		HelpText:    `  Show which socks route of the config file matches the hosts, nothing is connected.`,
+		ExampleText: `  diode -configpath diode.yml socksroute example.com:443 10.0.0.1:22 pi-taipei.diode`,
+		Type:        command.EmptyConnectionCommand,
+	}
+	errSocksRouteArgs = fmt.Errorf("expected one or more <host>[:port], the port defaults to 80")
+)
+
+func init() {
+	cfg := config.AppConfig
+	socksRouteCmd.Run = socksRouteHandler
+	socksRouteCmd.Flag.StringVar(&cfg.SocksFallback, "fallback", "localhost", "how to resolve web2 addresses")
+	socksRouteCmd.Flag.Var(&cfg.PACNames, "pac_bns", "bns name that is routed through the socks server, can be given multiple times")
+}
+
+func socksRouteHandler() (err error) {
+	cfg := config.AppConfig
+	args := socksRouteCmd.Flag.Args()
+	if len(args) == 0 {
+		printError("Argument Error: ", errSocksRouteArgs)
+		return
+	}
+	router, err := rpc.NewRouter(cfg.SocksRoutes)
+	if err != nil {
+		printError("Invalid socks routes: ", err)
+		return
+	}
+	printLabel("<HOST>", "<ROUTE> <ACTION>")
+	for _, host := range args {
+		if _, _, splitErr := net.SplitHostPort(host); splitErr != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), "80")
+		}
+		host = rpc.DiodeHost(host, cfg.PACNames)
+		route, routeErr := router.Route(host, cfg.SocksFallback)
+		if routeErr != nil {
+			printError(fmt.Sprintf("Couldn't route %s: ", host), routeErr)
+			continue
+		}
+		printLabel(host, fmt.Sprintf("%s %s", route.Name, route))
+	}
+	return
+}
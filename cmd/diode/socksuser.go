@@ -0,0 +1,237 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/diodechain/diode_go_client/command"
+	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/db"
+	"github.com/diodechain/diode_go_client/util"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	socksUserPrefix = "socks_user:"
+)
+
+var (
+	socksUserCmd = &command.Command{
+		Name:        "socksuser",
+		HelpText:    `  Manage the users of the socks server (add|list|remove).`,
+		ExampleText: `  diode socksuser add alice && diode socksuser add -password secret bob 0x1234... nas-office && diode socksuser remove alice`,
+		Type:        command.EmptyConnectionCommand,
+	}
+	socksUserPassword      string
+	errSocksUserArgs       = fmt.Errorf("expected 'add <name> [address|bns name...]', 'list' or 'remove <name>'")
+	errSocksUserNotFound   = fmt.Errorf("socks user not found")
+	socksUserNamePattern   = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,255}$`)
+	errSocksUserName       = fmt.Errorf("socks user name should have 1 to 255 letters, digits or . _ @ -")
+	errSocksUserNoPassword = fmt.Errorf("socks user password shouldn't be empty")
+)
+
+func init() {
+	socksUserCmd.Run = socksUserHandler
+	socksUserCmd.Flag.StringVar(&socksUserPassword, "password", "", "password of the new user, a random password is generated when empty")
+}
+
+// socksUser is stored in the database, only the bcrypt hash of the password
+// is kept
+type socksUser struct {
+	Hash      string   `json:"hash"`
+	Devices   []string `json:"devices,omitempty"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+func validateSocksUser(user *config.SocksUser) error {
+	if !socksUserNamePattern.MatchString(user.Name) {
+		return errSocksUserName
+	}
+	if len(user.Password) == 0 || len(user.Password) > 255 {
+		return errSocksUserNoPassword
+	}
+	for _, device := range user.Devices {
+		if !util.IsAddress([]byte(device)) && !isValidBNS(device) {
+			return fmt.Errorf("socks user %s device should be an address or a bns name but is: %v", user.Name, device)
+		}
+	}
+	return nil
+}
+
+func decodeSocksUser(key string, value []byte) (user config.SocksUser, err error) {
+	var stored socksUser
+	err = json.Unmarshal(value, &stored)
+	if err != nil {
+		return
+	}
+	user = config.SocksUser{
+		Name:     strings.TrimPrefix(key, socksUserPrefix),
+		Password: stored.Hash,
+		Devices:  stored.Devices,
+	}
+	if !strings.HasPrefix(stored.Hash, "$2") {
+		err = fmt.Errorf("invalid socks user %s: password isn't hashed", key)
+		return
+	}
+	err = validateSocksUser(&user)
+	return
+}
+
+// decodeSocksUsers returns the socks users of the given database values
+func decodeSocksUsers(values map[string][]byte) map[string]config.SocksUser {
+	users := make(map[string]config.SocksUser)
+	for key, value := range values {
+		if !strings.HasPrefix(key, socksUserPrefix) {
+			continue
+		}
+		user, err := decodeSocksUser(key, value)
+		if err != nil {
+			continue
+		}
+		users[user.Name] = user
+	}
+	return users
+}
+
+// loadSocksUsers returns the socks users of the config file and of the
+// database, names have to be unique
+func loadSocksUsers(cfg *config.Config) (map[string]*config.SocksUser, error) {
+	users := make(map[string]*config.SocksUser)
+	for i := range cfg.SocksUsers {
+		user := cfg.SocksUsers[i]
+		if err := validateSocksUser(&user); err != nil {
+			return nil, err
+		}
+		if users[user.Name] != nil {
+			return nil, fmt.Errorf("duplicate socks user: %s", user.Name)
+		}
+		users[user.Name] = &user
+	}
+	if db.DB != nil {
+		for name, user := range decodeSocksUsers(dbValues()) {
+			if users[name] != nil {
+				return nil, fmt.Errorf("socks user %s is defined in the config file and the database", name)
+			}
+			user := user
+			users[name] = &user
+		}
+	}
+	return users, nil
+}
+
+// socksUsers loads the socks users and warns when the socks server accepts
+// anyone from other hosts
+func socksUsers(cfg *config.Config) (map[string]*config.SocksUser, error) {
+	users, err := loadSocksUsers(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		if !isLoopbackHost(cfg.SocksServerHost) {
+			cfg.Logger.Warn("The socks server on %s accepts everyone, add users with 'diode socksuser add <name>'", cfg.SocksServerAddr())
+		}
+	}
+	return users, nil
+}
+
+func createSocksUser(name string, password string, devices []string) (err error) {
+	user := config.SocksUser{Name: name, Password: password, Devices: devices}
+	err = validateSocksUser(&user)
+	if err != nil {
+		return
+	}
+	if _, ok := decodeSocksUsers(dbValues())[name]; ok {
+		return fmt.Errorf("socks user %s exists already", name)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return
+	}
+	value, err := json.Marshal(&socksUser{
+		Hash:      string(hash),
+		Devices:   devices,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return
+	}
+	return db.DB.Put(socksUserPrefix+name, value)
+}
+
+func socksUserHandler() (err error) {
+	if db.DB == nil {
+		return fmt.Errorf("database is not available")
+	}
+	args := socksUserCmd.Flag.Args()
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch {
+	case action == "list" && len(args) <= 1:
+		users := decodeSocksUsers(dbValues())
+		names := make([]string, 0, len(users))
+		for name := range users {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		printLabel("<NAME>", "<DEVICES>")
+		for _, name := range names {
+			devices := "all"
+			if len(users[name].Devices) > 0 {
+				devices = strings.Join(users[name].Devices, ",")
+			}
+			printLabel(name, devices)
+		}
+	case action == "add" && len(args) >= 2:
+		name := args[1]
+		devices := make([]string, 0, len(args)-2)
+		for _, device := range args[2:] {
+			devices = append(devices, strings.ToLower(device))
+		}
+		password := socksUserPassword
+		if len(password) == 0 {
+			buf := make([]byte, 12)
+			if _, err = rand.Read(buf); err != nil {
+				return
+			}
+			password = hex.EncodeToString(buf)
+		}
+		err = createSocksUser(name, password, devices)
+		if err != nil {
+			printError("Couldn't add socks user", err)
+			return
+		}
+		printLabel("Socks user", name)
+		if len(socksUserPassword) == 0 {
+			printLabel("Password", password)
+			printInfo("Store the password now, it can't be shown again")
+		}
+		printInfo("The user is applied when diode starts")
+	case action == "remove" && len(args) == 2:
+		name := args[1]
+		if _, ok := decodeSocksUsers(dbValues())[name]; !ok {
+			err = errSocksUserNotFound
+			printError("Couldn't remove socks user", err)
+			return
+		}
+		err = db.DB.Del(socksUserPrefix + name)
+		if err != nil {
+			printError("Couldn't remove socks user", err)
+			return
+		}
+		printLabel("Removed socks user", name)
+	default:
+		printError("Argument Error: ", errSocksUserArgs)
+	}
+	return
+}
@@ -0,0 +1,213 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/diodechain/diode_go_client/command"
+	"github.com/diodechain/diode_go_client/db"
+)
+
+const (
+	apiTokenPrefix = "api_token:"
+	apiScopeRead   = "read"
+	apiScopeAdmin  = "admin"
+	// apiAuthHint is shown when the api requires tokens but none was created yet
+	apiAuthHint = "No api token was created, create one with 'diode token create -scope admin' or disable authentication with -apiauth=false"
+)
+
+var (
+	tokenCmd = &command.Command{
+		Name:        "token",
+		HelpText:    `  Manage bearer tokens of the config api (create|list|revoke).`,
+		ExampleText: `  diode token create -scope read && diode token list && diode token revoke 1a2b3c4d5e6f7a8b`,
+		Type:        command.EmptyConnectionCommand,
+	}
+	tokenScope          string
+	errTokenArgs        = fmt.Errorf("expected 'create', 'list' or 'revoke <id>'")
+	errInvalidScope     = fmt.Errorf("scope should be '%s' or '%s'", apiScopeRead, apiScopeAdmin)
+	errAPITokenNotFound = fmt.Errorf("api token not found")
+)
+
+func init() {
+	tokenCmd.Run = tokenHandler
+	tokenCmd.Flag.StringVar(&tokenScope, "scope", apiScopeRead, "scope of the new token: 'read' or 'admin'")
+}
+
+// apiToken is stored in the database, only the hash of the secret is kept
+type apiToken struct {
+	ID        string `json:"-"`
+	Scope     string `json:"scope"`
+	Hash      string `json:"hash"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func isValidScope(scope string) bool {
+	return scope == apiScopeRead || scope == apiScopeAdmin
+}
+
+// hasScope returns true if the granted scope includes the required scope
+func hasScope(granted string, required string) bool {
+	return granted == apiScopeAdmin || granted == required
+}
+
+func hashAPIToken(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// createAPIToken stores a new token and returns it, the token has the
+// format <id>.<secret> and can't be recovered later
+func createAPIToken(scope string) (id string, token string, err error) {
+	if !isValidScope(scope) {
+		err = errInvalidScope
+		return
+	}
+	buf := make([]byte, 40)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return
+	}
+	id = hex.EncodeToString(buf[:8])
+	secret := hex.EncodeToString(buf[8:])
+	value, err := json.Marshal(&apiToken{
+		Scope:     scope,
+		Hash:      hashAPIToken(secret),
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return
+	}
+	err = db.DB.Put(apiTokenPrefix+id, value)
+	token = fmt.Sprintf("%s.%s", id, secret)
+	return
+}
+
+func decodeAPIToken(key string, value []byte) (token apiToken, err error) {
+	err = json.Unmarshal(value, &token)
+	if err != nil {
+		return
+	}
+	if !isValidScope(token.Scope) || len(token.Hash) != sha256.Size*2 {
+		err = fmt.Errorf("invalid api token %s", key)
+		return
+	}
+	token.ID = strings.TrimPrefix(key, apiTokenPrefix)
+	return
+}
+
+// decodeAPITokens returns all api tokens of the given database values
+func decodeAPITokens(values map[string][]byte) map[string]apiToken {
+	tokens := make(map[string]apiToken)
+	for key, value := range values {
+		if !strings.HasPrefix(key, apiTokenPrefix) {
+			continue
+		}
+		token, err := decodeAPIToken(key, value)
+		if err != nil {
+			continue
+		}
+		tokens[token.ID] = token
+	}
+	return tokens
+}
+
+// apiTokens returns the tokens of the database, the tokens can only be
+// changed while no daemon holds the database
+func apiTokens() map[string]apiToken {
+	if db.DB == nil {
+		return make(map[string]apiToken)
+	}
+	return decodeAPITokens(dbValues())
+}
+
+// apiTokenScope returns the scope of the given bearer token
+func apiTokenScope(bearer string) (string, bool) {
+	parts := strings.SplitN(bearer, ".", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	token, ok := apiTokens()[parts[0]]
+	if !ok {
+		return "", false
+	}
+	hash := hashAPIToken(parts[1])
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(token.Hash)) != 1 {
+		return "", false
+	}
+	return token.Scope, true
+}
+
+func tokenHandler() (err error) {
+	if db.DB == nil {
+		return fmt.Errorf("database is not available")
+	}
+	args := tokenCmd.Flag.Args()
+	action := "list"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch {
+	case action == "list" && len(args) <= 1:
+		tokens := decodeAPITokens(dbValues())
+		ids := make([]string, 0, len(tokens))
+		for id := range tokens {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		printLabel("<ID>", "<SCOPE>     <CREATED>")
+		for _, id := range ids {
+			token := tokens[id]
+			printLabel(id, fmt.Sprintf("%-10s  %s", token.Scope, time.Unix(token.CreatedAt, 0).Format(time.RFC3339)))
+		}
+	case action == "create" && len(args) == 1:
+		var id, token string
+		id, token, err = createAPIToken(tokenScope)
+		if err != nil {
+			printError("Couldn't create token", err)
+			return
+		}
+		printLabel("Token ID", id)
+		printLabel("Scope", tokenScope)
+		printLabel("Token", token)
+		printInfo("Store the token now, it can't be shown again. Use it as 'Authorization: Bearer <token>'")
+	case action == "revoke" && len(args) == 2:
+		id := args[1]
+		if _, ok := decodeAPITokens(dbValues())[id]; !ok {
+			err = errAPITokenNotFound
+			printError("Couldn't revoke token", err)
+			return
+		}
+		err = db.DB.Del(apiTokenPrefix + id)
+		if err != nil {
+			printError("Couldn't revoke token", err)
+			return
+		}
+		printLabel("Revoked token", id)
+	default:
+		printError("Argument Error: ", errTokenArgs)
+	}
+	return
+}
+
+// dbValues returns all values of the database
+func dbValues() map[string][]byte {
+	values := make(map[string][]byte)
+	for _, key := range db.DB.List() {
+		value, err := db.DB.Get(key)
+		if err == nil {
+			values[key] = value
+		}
+	}
+	return values
+}
@@ -0,0 +1,192 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// EnvPrefix is the prefix of environment variables that set flag values
+	EnvPrefix = "DIODE_"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// Overrides keeps the values of explicitly passed flags and DIODE_*
+// environment variables so that they can be applied on top of the config
+// file. The precedence is flag > environment > config file > database.
+type Overrides struct {
+	fields []override
+}
+
+type override struct {
+	index    int
+	value    reflect.Value
+	explicit bool
+}
+
+// EnvName returns the environment variable name of the given flag
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// LookupEnv returns the value of the environment variable of the given flag
+func LookupEnv(flagName string) (string, bool) {
+	value, ok := os.LookupEnv(EnvName(flagName))
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return "", false
+	}
+	return value, true
+}
+
+// NewOverrides collects the flags of the given flag sets that were passed
+// explicitly or have an environment variable. The flags have to be parsed
+// into cfg before and the config file must not have been loaded yet.
+// Flags that don't belong to the config are set from the environment right
+// away.
+func NewOverrides(cfg *Config, flagSets ...*flag.FlagSet) (*Overrides, error) {
+	overrides := &Overrides{}
+	cfgValue := reflect.ValueOf(cfg).Elem()
+	var err error
+	for _, flagSet := range flagSets {
+		explicit := make(map[string]bool)
+		flagSet.Visit(func(f *flag.Flag) {
+			explicit[f.Name] = true
+		})
+		flagSet.VisitAll(func(f *flag.Flag) {
+			if err != nil {
+				return
+			}
+			index := fieldIndex(cfgValue, f.Value)
+			if explicit[f.Name] {
+				if index >= 0 {
+					overrides.add(index, copyValue(cfgValue.Field(index)), true)
+				}
+				return
+			}
+			env, ok := LookupEnv(f.Name)
+			if !ok {
+				return
+			}
+			if index < 0 {
+				if setErr := f.Value.Set(env); setErr != nil {
+					err = fmt.Errorf("invalid %s: %v", EnvName(f.Name), setErr)
+				}
+				return
+			}
+			value, parseErr := parseEnvValue(cfgValue.Field(index).Type(), env)
+			if parseErr != nil {
+				err = fmt.Errorf("invalid %s: %v", EnvName(f.Name), parseErr)
+				return
+			}
+			overrides.add(index, value, false)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+func (overrides *Overrides) add(index int, value reflect.Value, explicit bool) {
+	for i, field := range overrides.fields {
+		if field.index == index {
+			// an explicit flag wins over the environment of another flag
+			// of the same field
+			if explicit || !field.explicit {
+				overrides.fields[i] = override{index: index, value: value, explicit: explicit}
+			}
+			return
+		}
+	}
+	overrides.fields = append(overrides.fields, override{index: index, value: value, explicit: explicit})
+}
+
+// Apply sets the flag and environment values on the given config
+func (overrides *Overrides) Apply(cfg *Config) {
+	cfgValue := reflect.ValueOf(cfg).Elem()
+	for _, field := range overrides.fields {
+		cfgValue.Field(field.index).Set(copyValue(field.value))
+	}
+}
+
+// fieldIndex returns the index of the config field the flag value points to
+func fieldIndex(cfgValue reflect.Value, value flag.Value) int {
+	ptr := reflect.ValueOf(value)
+	if ptr.Kind() != reflect.Ptr {
+		return -1
+	}
+	for i := 0; i < cfgValue.NumField(); i++ {
+		field := cfgValue.Field(i)
+		if field.CanAddr() && field.Addr().Pointer() == ptr.Pointer() {
+			return i
+		}
+	}
+	return -1
+}
+
+func copyValue(value reflect.Value) reflect.Value {
+	ret := reflect.New(value.Type()).Elem()
+	if value.Kind() == reflect.Slice {
+		if value.IsNil() {
+			return ret
+		}
+		ret.Set(reflect.MakeSlice(value.Type(), value.Len(), value.Len()))
+		reflect.Copy(ret, value)
+		return ret
+	}
+	ret.Set(value)
+	return ret
+}
+
+// parseEnvValue parses the environment variable for a config field, list
+// values are separated by whitespace
+func parseEnvValue(typ reflect.Type, env string) (reflect.Value, error) {
+	value := reflect.New(typ).Elem()
+	if typ == durationType {
+		d, err := time.ParseDuration(env)
+		if err != nil {
+			return value, err
+		}
+		value.SetInt(int64(d))
+		return value, nil
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		value.SetString(env)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(env)
+		if err != nil {
+			return value, err
+		}
+		value.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(env)
+		if err != nil {
+			return value, err
+		}
+		value.SetInt(int64(i))
+	case reflect.Slice:
+		if typ.Elem().Kind() != reflect.String {
+			return value, fmt.Errorf("unsupported type %v", typ)
+		}
+		items := strings.Fields(env)
+		value.Set(reflect.MakeSlice(typ, len(items), len(items)))
+		for i, item := range items {
+			value.Index(i).SetString(item)
+		}
+	default:
+		return value, fmt.Errorf("unsupported type %v", typ)
+	}
+	return value, nil
+}
@@ -0,0 +1,341 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// testFlagSet registers a flag of each supported field kind and a flag that
+// doesn't belong to the config
+func testFlagSet(cfg *Config, other *string) *flag.FlagSet {
+	flagSet := flag.NewFlagSet("diode", flag.ContinueOnError)
+	flagSet.SetOutput(ioutil.Discard)
+	flagSet.StringVar(&cfg.DBPath, "dbpath", "default.db", "")
+	flagSet.IntVar(&cfg.RetryTimes, "retrytimes", 3, "")
+	flagSet.BoolVar(&cfg.Debug, "debug", false, "")
+	flagSet.DurationVar(&cfg.RemoteRPCTimeout, "timeout", 5*time.Second, "")
+	flagSet.Var(&cfg.RemoteRPCAddrs, "diodeaddrs", "")
+	flagSet.StringVar(other, "other-flag", "", "")
+	return flagSet
+}
+
+func setTestEnv(t *testing.T, env map[string]string) {
+	for key, value := range env {
+		os.Setenv(key, value)
+		key := key
+		t.Cleanup(func() { os.Unsetenv(key) })
+	}
+}
+
+// loadTestConfig loads the layers like the diode command, database values
+// replace the flag defaults and the config file is applied between the
+// overrides
+func loadTestConfig(db func(cfg *Config), args []string, file string) (*Config, *Overrides, error) {
+	cfg := &Config{}
+	var other string
+	flagSet := testFlagSet(cfg, &other)
+	if db != nil {
+		db(cfg)
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	overrides, err := NewOverrides(cfg, flagSet)
+	if err != nil {
+		return nil, nil, err
+	}
+	overrides.Apply(cfg)
+	if err = yaml.Unmarshal([]byte(file), cfg); err != nil {
+		return nil, nil, err
+	}
+	overrides.Apply(cfg)
+	return cfg, overrides, nil
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"dbpath":       "DIODE_DBPATH",
+		"socksd_port":  "DIODE_SOCKSD_PORT",
+		"http-webdav":  "DIODE_HTTP_WEBDAV",
+		"configpath":   "DIODE_CONFIGPATH",
+		"api-token_id": "DIODE_API_TOKEN_ID",
+	}
+	for flagName, want := range tests {
+		if name := EnvName(flagName); name != want {
+			t.Errorf("%s should be %s but is %s", flagName, want, name)
+		}
+	}
+}
+
+func TestParseEnvValue(t *testing.T) {
+	tests := []struct {
+		typ  reflect.Type
+		env  string
+		want interface{}
+		fail bool
+	}{
+		{typ: reflect.TypeOf(""), env: "/tmp/private.db", want: "/tmp/private.db"},
+		{typ: reflect.TypeOf(0), env: "42", want: 42},
+		{typ: reflect.TypeOf(0), env: "-1", want: -1},
+		{typ: reflect.TypeOf(0), env: "many", fail: true},
+		{typ: reflect.TypeOf(false), env: "true", want: true},
+		{typ: reflect.TypeOf(false), env: "0", want: false},
+		{typ: reflect.TypeOf(false), env: "maybe", fail: true},
+		{typ: durationType, env: "1m30s", want: 90 * time.Second},
+		{typ: durationType, env: "90", fail: true},
+		{typ: reflect.TypeOf(stringValues{}), env: " a.diode.io:41046\tb.diode.io:41046 ", want: stringValues{"a.diode.io:41046", "b.diode.io:41046"}},
+		{typ: reflect.TypeOf(stringValues{}), env: "single", want: stringValues{"single"}},
+		{typ: reflect.TypeOf([]int{}), env: "1 2", fail: true},
+		{typ: reflect.TypeOf(uint64(0)), env: "1", fail: true},
+	}
+	for _, test := range tests {
+		value, err := parseEnvValue(test.typ, test.env)
+		if test.fail {
+			if err == nil {
+				t.Errorf("%v %q should fail", test.typ, test.env)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v %q: %v", test.typ, test.env, err)
+			continue
+		}
+		if !reflect.DeepEqual(value.Interface(), test.want) {
+			t.Errorf("%v %q should be %v but is %v", test.typ, test.env, test.want, value.Interface())
+		}
+	}
+}
+
+func TestOverridesPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		db   func(cfg *Config)
+		file string
+		env  map[string]string
+		args []string
+		get  func(cfg *Config) interface{}
+		want interface{}
+	}{
+		// string
+		{
+			name: "string default",
+			get:  func(cfg *Config) interface{} { return cfg.DBPath },
+			want: "default.db",
+		},
+		{
+			name: "string db",
+			db:   func(cfg *Config) { cfg.DBPath = "db.db" },
+			get:  func(cfg *Config) interface{} { return cfg.DBPath },
+			want: "db.db",
+		},
+		{
+			name: "string file over db",
+			db:   func(cfg *Config) { cfg.DBPath = "db.db" },
+			file: "dbpath: file.db",
+			get:  func(cfg *Config) interface{} { return cfg.DBPath },
+			want: "file.db",
+		},
+		{
+			name: "string env over file",
+			db:   func(cfg *Config) { cfg.DBPath = "db.db" },
+			file: "dbpath: file.db",
+			env:  map[string]string{"DIODE_DBPATH": "env.db"},
+			get:  func(cfg *Config) interface{} { return cfg.DBPath },
+			want: "env.db",
+		},
+		{
+			name: "string flag over env",
+			db:   func(cfg *Config) { cfg.DBPath = "db.db" },
+			file: "dbpath: file.db",
+			env:  map[string]string{"DIODE_DBPATH": "env.db"},
+			args: []string{"-dbpath", "flag.db"},
+			get:  func(cfg *Config) interface{} { return cfg.DBPath },
+			want: "flag.db",
+		},
+		{
+			name: "blank env is ignored",
+			file: "dbpath: file.db",
+			env:  map[string]string{"DIODE_DBPATH": "  "},
+			get:  func(cfg *Config) interface{} { return cfg.DBPath },
+			want: "file.db",
+		},
+		// int
+		{
+			name: "int file over db",
+			db:   func(cfg *Config) { cfg.RetryTimes = 1 },
+			file: "retrytimes: 2",
+			get:  func(cfg *Config) interface{} { return cfg.RetryTimes },
+			want: 2,
+		},
+		{
+			name: "int env over file",
+			file: "retrytimes: 2",
+			env:  map[string]string{"DIODE_RETRYTIMES": "7"},
+			get:  func(cfg *Config) interface{} { return cfg.RetryTimes },
+			want: 7,
+		},
+		{
+			name: "int flag over env",
+			file: "retrytimes: 2",
+			env:  map[string]string{"DIODE_RETRYTIMES": "7"},
+			args: []string{"-retrytimes=9"},
+			get:  func(cfg *Config) interface{} { return cfg.RetryTimes },
+			want: 9,
+		},
+		// bool
+		{
+			name: "bool env over file",
+			file: "debug: false",
+			env:  map[string]string{"DIODE_DEBUG": "true"},
+			get:  func(cfg *Config) interface{} { return cfg.Debug },
+			want: true,
+		},
+		{
+			name: "bool flag with default value over file",
+			db:   func(cfg *Config) { cfg.Debug = true },
+			file: "debug: true",
+			env:  map[string]string{"DIODE_DEBUG": "true"},
+			args: []string{"-debug=false"},
+			get:  func(cfg *Config) interface{} { return cfg.Debug },
+			want: false,
+		},
+		// duration
+		{
+			name: "duration file over default",
+			file: "timeout: 10s",
+			get:  func(cfg *Config) interface{} { return cfg.RemoteRPCTimeout },
+			want: 10 * time.Second,
+		},
+		{
+			name: "duration env over file",
+			file: "timeout: 10s",
+			env:  map[string]string{"DIODE_TIMEOUT": "2m"},
+			get:  func(cfg *Config) interface{} { return cfg.RemoteRPCTimeout },
+			want: 2 * time.Minute,
+		},
+		{
+			name: "duration flag over env",
+			env:  map[string]string{"DIODE_TIMEOUT": "2m"},
+			args: []string{"-timeout", "1s"},
+			get:  func(cfg *Config) interface{} { return cfg.RemoteRPCTimeout },
+			want: time.Second,
+		},
+		// stringValues
+		{
+			name: "list db",
+			db:   func(cfg *Config) { cfg.RemoteRPCAddrs = stringValues{"db:41046"} },
+			get:  func(cfg *Config) interface{} { return cfg.RemoteRPCAddrs },
+			want: stringValues{"db:41046"},
+		},
+		{
+			name: "list file over db",
+			db:   func(cfg *Config) { cfg.RemoteRPCAddrs = stringValues{"db:41046"} },
+			file: "diodeaddrs:\n- file1:41046\n- file2:41046",
+			get:  func(cfg *Config) interface{} { return cfg.RemoteRPCAddrs },
+			want: stringValues{"file1:41046", "file2:41046"},
+		},
+		{
+			name: "list env over file",
+			file: "diodeaddrs:\n- file1:41046\n- file2:41046",
+			env:  map[string]string{"DIODE_DIODEADDRS": "env1:41046 env2:41046"},
+			get:  func(cfg *Config) interface{} { return cfg.RemoteRPCAddrs },
+			want: stringValues{"env1:41046", "env2:41046"},
+		},
+		{
+			name: "list flag over env",
+			file: "diodeaddrs:\n- file1:41046",
+			env:  map[string]string{"DIODE_DIODEADDRS": "env1:41046 env2:41046"},
+			args: []string{"-diodeaddrs", "flag1:41046", "-diodeaddrs", "flag2:41046"},
+			get:  func(cfg *Config) interface{} { return cfg.RemoteRPCAddrs },
+			want: stringValues{"flag1:41046", "flag2:41046"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setTestEnv(t, test.env)
+			cfg, _, err := loadTestConfig(test.db, test.args, test.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := test.get(cfg); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("should be %v but is %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestOverridesReload(t *testing.T) {
+	setTestEnv(t, map[string]string{"DIODE_DIODEADDRS": "env1:41046 env2:41046"})
+	cfg, overrides, err := loadTestConfig(nil, []string{"-retrytimes=9"}, "retrytimes: 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a reload applies the overrides on the new file config, the values are
+	// copied so changes of the old config don't leak
+	cfg.RemoteRPCAddrs[0] = "changed:41046"
+	next := &Config{}
+	if err = yaml.Unmarshal([]byte("retrytimes: 4\ndbpath: next.db"), next); err != nil {
+		t.Fatal(err)
+	}
+	overrides.Apply(next)
+	if next.RetryTimes != 9 || next.DBPath != "next.db" {
+		t.Errorf("wrong reloaded config %d %s", next.RetryTimes, next.DBPath)
+	}
+	if !reflect.DeepEqual(next.RemoteRPCAddrs, stringValues{"env1:41046", "env2:41046"}) {
+		t.Errorf("wrong reloaded list %v", next.RemoteRPCAddrs)
+	}
+}
+
+func TestOverridesErrors(t *testing.T) {
+	tests := map[string]string{
+		"DIODE_RETRYTIMES": "many",
+		"DIODE_DEBUG":      "maybe",
+		"DIODE_TIMEOUT":    "90",
+	}
+	for key, value := range tests {
+		t.Run(key, func(t *testing.T) {
+			setTestEnv(t, map[string]string{key: value})
+			_, _, err := loadTestConfig(nil, nil, "")
+			if err == nil || !strings.Contains(err.Error(), key) {
+				t.Errorf("invalid %s should fail with the variable name: %v", key, err)
+			}
+		})
+	}
+}
+
+func TestOverridesOtherFlags(t *testing.T) {
+	setTestEnv(t, map[string]string{"DIODE_OTHER_FLAG": "from env"})
+	cfg := &Config{}
+	var other string
+	flagSet := testFlagSet(cfg, &other)
+	if err := flagSet.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewOverrides(cfg, flagSet); err != nil {
+		t.Fatal(err)
+	}
+	if other != "from env" {
+		t.Errorf("flags outside of the config should be set right away but is %q", other)
+	}
+
+	other = ""
+	if err := flagSet.Parse([]string{"-other-flag", "from flag"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewOverrides(cfg, flagSet); err != nil {
+		t.Fatal(err)
+	}
+	if other != "from flag" {
+		t.Errorf("explicit flag should win over the environment but is %q", other)
+	}
+}
@@ -5,6 +5,8 @@ package config
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
 	"strconv"
 	"strings"
@@ -24,6 +26,7 @@ const (
 	UDPProtocol
 	TLSProtocol
 	AnyProtocol
+	HTTPProtocol
 )
 
 var (
@@ -77,6 +80,9 @@ type Config struct {
 	SProxyServerPrivPath    string           `yaml:"-" json:"-"`
 	AllowRedirectToSProxy   bool             `yaml:"-" json:"-"`
 	APIServerAddr           string           `yaml:"-" json:"-"`
+	APIServerAuth           bool             `yaml:"-" json:"-"`
+	APIServerTLS            bool             `yaml:"-" json:"-"`
+	APIServerSocket         string           `yaml:"-" json:"-"`
 	EnableAPIServer         bool             `yaml:"-" json:"-"`
 	EnableProxyServer       bool             `yaml:"-" json:"-"`
 	EnableSProxyServer      bool             `yaml:"-" json:"-"`
@@ -84,6 +90,18 @@ type Config struct {
 	SocksServerHost         string           `yaml:"-" json:"-"`
 	SocksServerPort         int              `yaml:"-" json:"-"`
 	SocksFallback           string           `yaml:"-" json:"-"`
+	HTTPProxyPort           int              `yaml:"-" json:"-"`
+	PACPort                 int              `yaml:"-" json:"-"`
+	PACNames                stringValues     `yaml:"-" json:"-"`
+	DNSServerAddr           string           `yaml:"-" json:"-"`
+	DNSPool                 string           `yaml:"-" json:"-"`
+	DNSPorts                string           `yaml:"-" json:"-"`
+	DNSTTL                  time.Duration    `yaml:"-" json:"-"`
+	DNSIdleTimeout          time.Duration    `yaml:"-" json:"-"`
+	DNSUpstream             string           `yaml:"-" json:"-"`
+	DNSWithoutAuth          bool             `yaml:"-" json:"-"`
+	SocksUsers              []SocksUser      `yaml:"socks_users,omitempty" json:"-"`
+	SocksRoutes             []SocksRoute     `yaml:"socks_routes,omitempty" json:"-"`
 	ConfigUnsafe            bool             `yaml:"-" json:"-"`
 	ConfigList              bool             `yaml:"-" json:"-"`
 	ConfigDelete            stringValues     `yaml:"-" json:"-"`
@@ -92,6 +110,13 @@ type Config struct {
 	PublicPublishedPorts    stringValues     `yaml:"published_public_ports,omitempty" json:"-"`
 	ProtectedPublishedPorts stringValues     `yaml:"published_protected_ports,omitempty" json:"-"`
 	PrivatePublishedPorts   stringValues     `yaml:"published_private_ports,omitempty" json:"-"`
+	PublishedTargetHosts    stringValues     `yaml:"published_target_hosts,omitempty" json:"-"`
+	VirtualHosts            []VirtualHost    `yaml:"virtual_hosts,omitempty" json:"-"`
+	SHealthChecks           stringValues     `yaml:"health_checks,omitempty" json:"-"`
+	HealthCheckInterval     time.Duration    `yaml:"health_interval,omitempty" json:"-"`
+	MaintenancePage         string           `yaml:"maintenance_page,omitempty" json:"-"`
+	AllowlistCacheAge       uint64           `yaml:"allowlist_cache_age,omitempty" json:"-"`
+	SHTTPWriters            stringValues     `yaml:"-" json:"-"`
 	Blocklists              map[Address]bool `yaml:"-" json:"-"`
 	Allowlists              map[Address]bool `yaml:"-" json:"-"`
 	LogMode                 int              `yaml:"-" json:"-"`
@@ -105,6 +130,7 @@ type Config struct {
 	BNSLookup               string           `yaml:"-" json:"-"`
 	Experimental            bool             `yaml:"-" json:"-"`
 	LoadFromFile            bool             `yaml:"-" json:"-"`
+	Profile                 string           `yaml:"-" json:"-"`
 }
 
 // LoadConfigFromFile returns bytes data of config
@@ -159,11 +185,90 @@ func (cfg *Config) SaveToFile() (err error) {
 	return
 }
 
+// SaveKeysToFile stores only the given yaml keys of the config to
+// ConfigFilePath, the other keys keep the values of the file
+func (cfg *Config) SaveKeysToFile(keys ...string) (err error) {
+	if !cfg.LoadFromFile {
+		err = errConfigNotLoadedFromFile
+		return
+	}
+	var out []byte
+	out, err = yaml.Marshal(cfg)
+	if err != nil {
+		return
+	}
+	values := yaml.MapSlice{}
+	err = yaml.Unmarshal(out, &values)
+	if err != nil {
+		return
+	}
+	var in []byte
+	in, err = LoadConfigFromFile(cfg.ConfigFilePath)
+	if err != nil {
+		return
+	}
+	file := yaml.MapSlice{}
+	err = yaml.Unmarshal(in, &file)
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		next := yaml.MapSlice{}
+		found := false
+		for _, item := range file {
+			if item.Key != key {
+				next = append(next, item)
+				continue
+			}
+			found = true
+			if value, ok := mapSliceValue(values, key); ok {
+				next = append(next, yaml.MapItem{Key: key, Value: value})
+			}
+		}
+		if value, ok := mapSliceValue(values, key); ok && !found {
+			next = append(next, yaml.MapItem{Key: key, Value: value})
+		}
+		file = next
+	}
+	out, err = yaml.Marshal(file)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(cfg.ConfigFilePath, out, 0600)
+	return
+}
+
+// mapSliceValue returns the value of the key, omitted empty values are not found
+func mapSliceValue(values yaml.MapSlice, key string) (interface{}, bool) {
+	for _, item := range values {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
 // SocksServerAddr returns address that socks proxy listen to
 func (cfg *Config) SocksServerAddr() string {
 	return fmt.Sprintf("%s:%d", cfg.SocksServerHost, cfg.SocksServerPort)
 }
 
+// HTTPProxyAddr returns address that the http forward proxy listen to
+func (cfg *Config) HTTPProxyAddr() string {
+	if cfg.HTTPProxyPort <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", cfg.SocksServerHost, cfg.HTTPProxyPort)
+}
+
+// PACAddr returns address that the proxy auto-config server listen to
+func (cfg *Config) PACAddr() string {
+	if cfg.PACPort <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", cfg.SocksServerHost, cfg.PACPort)
+}
+
 // ProxyServerAddr returns address that http proxy server listen to
 func (cfg *Config) ProxyServerAddr() string {
 	return fmt.Sprintf("%s:%d", cfg.ProxyServerHost, cfg.ProxyServerPort)
@@ -211,6 +316,41 @@ type Bind struct {
 	Protocol  int
 }
 
+// VirtualHost routes the tunnels of the published port with the http host
+// or tls server name Host to the Target service, Target is <port>,
+// <host>:<port> or unix:<path>. Host can start with a *. wildcard.
+type VirtualHost struct {
+	Port   int    `yaml:"port" json:"port"`
+	Host   string `yaml:"host" json:"host"`
+	Target string `yaml:"target" json:"target"`
+}
+
+// SocksUser can authenticate to the socks server with the username and
+// password, Password is a bcrypt hash or the plain password. Devices limits
+// the diode devices (addresses or bns names) the user may connect to, all
+// devices are allowed when it's empty.
+type SocksUser struct {
+	Name     string   `yaml:"name" json:"name"`
+	Password string   `yaml:"password" json:"-"`
+	Devices  []string `yaml:"devices,omitempty" json:"devices,omitempty"`
+}
+
+// SocksRoute decides how the socks server connects matching requests. A
+// request matches when it matches one of the Domains, CIDRs and Ports that
+// are given, a route without any of them matches everything. Action is one
+// of diode, direct, reject or proxy, Proxy is the socks5://, socks5h:// or
+// http:// url of the upstream proxy and Device the diode device of non-diode
+// hosts.
+type SocksRoute struct {
+	Name    string   `yaml:"name,omitempty" json:"name,omitempty"`
+	Domains []string `yaml:"domains,omitempty" json:"domains,omitempty"`
+	CIDRs   []string `yaml:"cidrs,omitempty" json:"cidrs,omitempty"`
+	Ports   []string `yaml:"ports,omitempty" json:"ports,omitempty"`
+	Action  string   `yaml:"action" json:"action"`
+	Proxy   string   `yaml:"proxy,omitempty" json:"-"`
+	Device  string   `yaml:"device,omitempty" json:"device,omitempty"`
+}
+
 // Port struct for listening port
 type Port struct {
 	Src       int
@@ -218,6 +358,81 @@ type Port struct {
 	Mode      int
 	Protocol  int
 	Allowlist map[Address]bool
+	// BNSNames of the allowlist, they are resolved to addresses at runtime
+	BNSNames []string
+	// SrcHost is the host of the published service, localhost when empty
+	SrcHost string
+	// SrcSocket is the path of the unix socket of the published service,
+	// SrcHost and Src are not used when it's set
+	SrcSocket string
+	// ProxyProtocol prepends a PROXY protocol v2 header with the device
+	// address to the connections to the published service
+	ProxyProtocol bool
+	// HealthCheck enables periodic checks of the published service, it's
+	// an http check of HealthPath when that is set and a tcp check otherwise
+	HealthCheck bool
+	HealthPath  string
+	// MaintenancePage is the html file that is served while the http
+	// service is unhealthy
+	MaintenancePage string
+	// Hosts are the ports of the virtual hosts of the port by lower case
+	// host name, the port itself serves unknown hosts
+	Hosts map[string]*Port
+}
+
+// HostPort returns the port of the virtual host that matches the http host
+// or tls server name, the port itself when no virtual host matches
+func (port *Port) HostPort(name string) *Port {
+	if len(port.Hosts) == 0 || len(name) == 0 {
+		return port
+	}
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if hostPort, ok := port.Hosts[name]; ok {
+		return hostPort
+	}
+	for i := strings.Index(name, "."); i >= 0; i = strings.Index(name, ".") {
+		name = name[i+1:]
+		if hostPort, ok := port.Hosts["*."+name]; ok {
+			return hostPort
+		}
+	}
+	return port
+}
+
+// AcceptsProtocol returns true when connections of the given protocol can
+// be bridged to the port, http ports accept tcp and tls connections
+func (port *Port) AcceptsProtocol(protocol int) bool {
+	switch port.Protocol {
+	case AnyProtocol:
+		return true
+	case HTTPProtocol:
+		return protocol == TCPProtocol || protocol == TLSProtocol
+	default:
+		return port.Protocol == protocol
+	}
+}
+
+// SrcAddr returns the network and address to dial the published service
+// for a connection of the given protocol
+func (port *Port) SrcAddr(protocol int) (network string, addr string) {
+	if len(port.SrcSocket) > 0 {
+		if protocol == UDPProtocol {
+			return "unixgram", port.SrcSocket
+		}
+		return "unix", port.SrcSocket
+	}
+	network = "tcp"
+	if protocol == UDPProtocol {
+		network = "udp"
+	}
+	host := port.SrcHost
+	if len(host) == 0 {
+		host = "localhost"
+	}
+	return network, net.JoinHostPort(host, strconv.Itoa(port.Src))
 }
 
 // ModeIdentifier returns a mode code of the human readable version
@@ -262,6 +477,9 @@ func ProtocolIdentifier(protocol string) int {
 	if protocol == "tls" {
 		return TLSProtocol
 	}
+	if protocol == "http" {
+		return HTTPProtocol
+	}
 	return 0
 }
 
@@ -279,6 +497,9 @@ func ProtocolName(protocol int) string {
 	if protocol == TLSProtocol {
 		return "tls"
 	}
+	if protocol == HTTPProtocol {
+		return "http"
+	}
 	return "?"
 }
 
@@ -0,0 +1,54 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestSaveKeysToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diode-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "diode.yml")
+	in := "diodeaddrs:\n- file.diode.io:41046\nblocklists:\n- \"0x0000000000000000000000000000000000000001\"\nallowlists:\n- \"0x0000000000000000000000000000000000000002\"\n"
+	if err = ioutil.WriteFile(path, []byte(in), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		ConfigFilePath: path,
+		LoadFromFile:   true,
+		// the runtime values of flags mustn't be saved
+		RemoteRPCAddrs: stringValues{"flag.diode.io:41046"},
+		DBPath:         "flag.db",
+		SBlocklists:    stringValues{},
+		SAllowlists:    stringValues{"0x0000000000000000000000000000000000000003"},
+	}
+	if err = cfg.SaveKeysToFile("blocklists", "allowlists"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := yaml.MapSlice{}
+	if err = yaml.Unmarshal(out, &saved); err != nil {
+		t.Fatal(err)
+	}
+	expected := yaml.MapSlice{
+		{Key: "diodeaddrs", Value: []interface{}{"file.diode.io:41046"}},
+		{Key: "allowlists", Value: []interface{}{"0x0000000000000000000000000000000000000003"}},
+	}
+	if !reflect.DeepEqual(saved, expected) {
+		t.Errorf("saved config %v, expected %v", saved, expected)
+	}
+}
@@ -0,0 +1,154 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/diodechain/diode_go_client/util"
+)
+
+const (
+	// DefaultProfileName is the profile that uses the legacy database location
+	DefaultProfileName = "default"
+	profileDBFile      = "private.db"
+	profileConfigFile  = "diode.yml"
+	activeProfileFile  = "profile"
+)
+
+var (
+	profilePattern          = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,31}$`)
+	ErrInvalidProfileName   = fmt.Errorf("profile name should be 1-32 letters, digits, '-' or '_'")
+	ErrProfileNotFound      = fmt.Errorf("profile not found")
+	ErrProfileExists        = fmt.Errorf("profile already exists")
+	ErrDeleteActiveProfile  = fmt.Errorf("can't delete the active profile")
+	ErrDeleteDefaultProfile = fmt.Errorf("can't delete the default profile")
+)
+
+// baseDir returns the directory that contains the default database
+func baseDir() string {
+	return path.Dir(util.DefaultDBPath())
+}
+
+// ProfilesDir returns the directory where all non default profiles are stored
+func ProfilesDir() string {
+	return path.Join(baseDir(), "profiles")
+}
+
+// IsValidProfileName returns true if the given name can be used as profile name
+func IsValidProfileName(name string) bool {
+	return profilePattern.MatchString(name)
+}
+
+// ProfileDir returns the directory of the given profile
+func ProfileDir(name string) string {
+	if name == DefaultProfileName {
+		return baseDir()
+	}
+	return path.Join(ProfilesDir(), name)
+}
+
+// ProfileDBPath returns file path to the database of the given profile
+func ProfileDBPath(name string) string {
+	if name == DefaultProfileName {
+		return util.DefaultDBPath()
+	}
+	return path.Join(ProfileDir(name), profileDBFile)
+}
+
+// ProfileConfigPath returns file path to the yaml config of the given profile
+func ProfileConfigPath(name string) string {
+	return path.Join(ProfileDir(name), profileConfigFile)
+}
+
+// ProfileExists returns true if the given profile had been created
+func ProfileExists(name string) bool {
+	if name == DefaultProfileName {
+		return true
+	}
+	fi, err := os.Stat(ProfileDir(name))
+	return err == nil && fi.IsDir()
+}
+
+// ListProfiles returns the names of all profiles, the default profile first
+func ListProfiles() (profiles []string, err error) {
+	profiles = []string{DefaultProfileName}
+	fis, err := ioutil.ReadDir(ProfilesDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	names := []string{}
+	for _, fi := range fis {
+		if fi.IsDir() && IsValidProfileName(fi.Name()) && fi.Name() != DefaultProfileName {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+	profiles = append(profiles, names...)
+	return
+}
+
+// CreateProfile creates the directory of a new profile
+func CreateProfile(name string) error {
+	if !IsValidProfileName(name) {
+		return ErrInvalidProfileName
+	}
+	if ProfileExists(name) {
+		return ErrProfileExists
+	}
+	return os.MkdirAll(ProfileDir(name), 0700)
+}
+
+// DeleteProfile removes the given profile including its key and config
+func DeleteProfile(name string) error {
+	if name == DefaultProfileName {
+		return ErrDeleteDefaultProfile
+	}
+	if !IsValidProfileName(name) {
+		return ErrInvalidProfileName
+	}
+	if !ProfileExists(name) {
+		return ErrProfileNotFound
+	}
+	if ActiveProfile() == name {
+		return ErrDeleteActiveProfile
+	}
+	return os.RemoveAll(ProfileDir(name))
+}
+
+// ActiveProfile returns the name of the profile selected by 'diode profile use'
+func ActiveProfile() string {
+	raw, err := ioutil.ReadFile(path.Join(baseDir(), activeProfileFile))
+	if err != nil {
+		return DefaultProfileName
+	}
+	name := strings.TrimSpace(string(raw))
+	if !IsValidProfileName(name) || !ProfileExists(name) {
+		return DefaultProfileName
+	}
+	return name
+}
+
+// SetActiveProfile stores the given profile as the active one
+func SetActiveProfile(name string) error {
+	if !IsValidProfileName(name) {
+		return ErrInvalidProfileName
+	}
+	if !ProfileExists(name) {
+		return ErrProfileNotFound
+	}
+	if err := os.MkdirAll(baseDir(), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(baseDir(), activeProfileFile), []byte(name), 0600)
+}
@@ -0,0 +1,256 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxAutoBackups is the number of automatic backups that are kept
+	MaxAutoBackups = 5
+	// Formats reported by Verify
+	FormatEmpty    = "empty"
+	FormatLog      = "log"
+	FormatSnapshot = "snapshot"
+	FormatUnknown  = "unknown"
+	autoBackupDir  = "backups"
+	autoBackupExt  = ".bak"
+	damagedExt     = ".damaged"
+)
+
+var (
+	ErrUnknownFormat = fmt.Errorf("unknown database format")
+	ErrCorrupted     = fmt.Errorf("database is corrupted")
+)
+
+// Report is the result of a database verification
+type Report struct {
+	Format  string
+	Records int
+	Values  map[string][]byte
+	// Corrupted is the number of bytes that couldn't be read
+	Corrupted int64
+	// Err is the reason why reading stopped early
+	Err error
+}
+
+// Valid returns true if the whole file could be read
+func (report *Report) Valid() bool {
+	return report.Format != FormatUnknown && report.Err == nil
+}
+
+// Verify reads the database file without modifying it and reports
+// its format, the number of records and damaged data
+func Verify(filepath string) (*Report, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{
+		Values: make(map[string][]byte),
+	}
+	if fi.Size() == 0 {
+		report.Format = FormatEmpty
+		return report, nil
+	}
+	r := bufio.NewReader(f)
+	magic, err := binary.ReadUvarint(r)
+	if err != nil {
+		report.Format = FormatUnknown
+		report.Corrupted = fi.Size()
+		report.Err = ErrUnknownFormat
+		return report, nil
+	}
+	switch magic {
+	case logVersionMagic:
+		report.Format = FormatLog
+		offset := int64(uvarintSize(logVersionMagic))
+		for {
+			op, key, value, n, err := readRecord(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				report.Corrupted = fi.Size() - offset
+				report.Err = fmt.Errorf("%v at offset %d", err, offset)
+				break
+			}
+			offset += n
+			report.Records++
+			if op == recordPut {
+				report.Values[key] = value
+			} else {
+				delete(report.Values, key)
+			}
+		}
+	case databaseVersionMagic:
+		report.Format = FormatSnapshot
+		values, err := readSnapshot(r)
+		if err != nil {
+			report.Corrupted = fi.Size()
+			report.Err = err
+			break
+		}
+		report.Records = len(values)
+		report.Values = values
+	default:
+		report.Format = FormatUnknown
+		report.Corrupted = fi.Size()
+		report.Err = ErrUnknownFormat
+	}
+	return report, nil
+}
+
+// ReadBackup returns the values of a backup file, the backup has to be
+// free of any damage
+func ReadBackup(filepath string) (map[string][]byte, error) {
+	report, err := Verify(filepath)
+	if err != nil {
+		return nil, err
+	}
+	if !report.Valid() {
+		return nil, fmt.Errorf("%v: %v", ErrCorrupted, report.Err)
+	}
+	return report.Values, nil
+}
+
+// WriteBackup writes the given values in the log format to filepath
+func WriteBackup(filepath string, values map[string][]byte) error {
+	storage := NewLogStorage(filepath)
+	err := storage.Compact(values)
+	if err != nil {
+		return err
+	}
+	return storage.Close()
+}
+
+// Backup writes a copy of the database to the given file
+func (db *Database) Backup(filepath string) error {
+	db.rm.Lock()
+	values := copyValues(db.values)
+	db.rm.Unlock()
+	return WriteBackup(filepath, values)
+}
+
+// Restore replaces all values of the database with the given values
+func (db *Database) Restore(values map[string][]byte) error {
+	db.rm.Lock()
+	defer db.rm.Unlock()
+	db.values = copyValues(values)
+	return db.storage.Compact(db.values)
+}
+
+// Corrupted returns the number of damaged bytes that were dropped when
+// the database was opened
+func (db *Database) Corrupted() int64 {
+	if storage, ok := db.storage.(*LogStorage); ok {
+		return storage.Corrupted
+	}
+	return 0
+}
+
+// AutoBackupDir returns the directory of the automatic backups of the given database
+func AutoBackupDir(dbPath string) string {
+	return path.Join(path.Dir(dbPath), autoBackupDir)
+}
+
+// AutoBackups returns the automatic backups of the given database, oldest first
+func AutoBackups(dbPath string) ([]string, error) {
+	pattern := path.Join(AutoBackupDir(dbPath), path.Base(dbPath)+".*"+autoBackupExt)
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// autoBackupPath returns a timestamped file path in the automatic backup
+// directory of the given database
+func autoBackupPath(dbPath string, ext string) (string, error) {
+	dir := AutoBackupDir(dbPath)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	stamp := strings.Replace(time.Now().UTC().Format("20060102-150405.000000000"), ".", "-", 1)
+	return path.Join(dir, fmt.Sprintf("%s.%s%s", path.Base(dbPath), stamp, ext)), nil
+}
+
+// AutoBackup writes a timestamped backup of the database and removes
+// the oldest automatic backups so that at most MaxAutoBackups are kept
+func (db *Database) AutoBackup() (string, error) {
+	db.rm.Lock()
+	values := copyValues(db.values)
+	db.rm.Unlock()
+	return WriteAutoBackup(db.path, values)
+}
+
+// WriteAutoBackup writes the values as automatic backup of the given
+// database, this is used when the database file isn't opened
+func WriteAutoBackup(dbPath string, values map[string][]byte) (string, error) {
+	backupPath, err := autoBackupPath(dbPath, autoBackupExt)
+	if err != nil {
+		return "", err
+	}
+	err = WriteBackup(backupPath, values)
+	if err != nil {
+		return "", err
+	}
+	backups, err := AutoBackups(dbPath)
+	if err != nil {
+		return backupPath, err
+	}
+	for i := 0; i < len(backups)-MaxAutoBackups; i++ {
+		os.Remove(backups[i])
+	}
+	return backupPath, nil
+}
+
+// SaveDamaged copies the database file unchanged into the automatic backup
+// directory, damaged databases are saved before they are repaired or
+// replaced so that the lost records can still be recovered
+func SaveDamaged(dbPath string) (string, error) {
+	damagedPath, err := autoBackupPath(dbPath, damagedExt)
+	if err != nil {
+		return "", err
+	}
+	src, err := os.Open(dbPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(damagedPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(damagedPath)
+		return "", err
+	}
+	return damagedPath, nil
+}
@@ -0,0 +1,113 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package db
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	filepath, cleanup := tempDBPath(t)
+	defer cleanup()
+	db, err := OpenFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, v := range dbTests {
+		db.Put(v.Key, v.Value)
+	}
+	backupPath := filepath + ".backup"
+	err = db.Backup(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put("unsaved", []byte("diode"))
+	db.Del("ibtc")
+
+	values, err := ReadBackup(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Restore(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range dbTests {
+		assertValue(t, db, v.Key, v.Value)
+	}
+	if _, err = db.Get("unsaved"); err != ErrKeyNotFound {
+		t.Fatalf("Restore should remove keys that are not in the backup")
+	}
+}
+
+func TestVerifyReportsCorruption(t *testing.T) {
+	filepath, cleanup := tempDBPath(t)
+	defer cleanup()
+	db, err := OpenFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put("first", []byte("value"))
+	db.Put("second", []byte("value"))
+	db.Close()
+
+	report, err := Verify(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() || report.Format != FormatLog || report.Records != 2 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+
+	raw, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-6] ^= 0xff
+	ioutil.WriteFile(filepath, raw, 0600)
+	report, err = Verify(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid() || report.Corrupted == 0 || report.Records != 1 {
+		t.Fatalf("Corruption should be reported %+v", report)
+	}
+	if _, err = ReadBackup(filepath); err == nil {
+		t.Fatalf("Corrupted backups should not be readable")
+	}
+	// Verify must not repair the file
+	after, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(raw) {
+		t.Fatalf("Verify should not modify the database")
+	}
+}
+
+func TestAutoBackupRotation(t *testing.T) {
+	filepath, cleanup := tempDBPath(t)
+	defer cleanup()
+	db, err := OpenFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Put("hello", []byte("diode"))
+	for i := 0; i < MaxAutoBackups+2; i++ {
+		_, err = db.AutoBackup()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, err := AutoBackups(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != MaxAutoBackups {
+		t.Fatalf("Expected %d automatic backups but got %d", MaxAutoBackups, len(backups))
+	}
+}
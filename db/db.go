@@ -11,33 +11,77 @@ import (
 	"os"
 	"path"
 	"sync"
+	"time"
 
 	"github.com/diodechain/diode_go_client/util"
 )
 
 const (
 	databaseVersionMagic uint64 = 4389235283
+	lockRetryInterval           = 100 * time.Millisecond
 )
 
 var (
-	DB                 *Database
-	DBPath             string
+	DB     *Database
+	DBPath string
+	// LockWait is how long OpenFile waits for another process to release
+	// the database before it opens the database read-only
+	LockWait           time.Duration
 	ErrSizeDidNotMatch = fmt.Errorf("incorrect size of written bytes")
 	ErrKeyNotFound     = fmt.Errorf("key not found")
+	ErrReadOnly        = fmt.Errorf("database is used by another diode process, stop it to change the database")
+	errLockHeld        = fmt.Errorf("lock is held by another process")
 )
 
+// Database is an in memory key value store that persists changes through a Storage
 type Database struct {
-	path   string
-	values map[string][]byte
-	// maybe write data async in the future
-	// dirty bool
-	rm     sync.Mutex
-	buffer []byte
+	path     string
+	values   map[string][]byte
+	storage  Storage
+	lock     *os.File
+	readOnly bool
+	rm       sync.Mutex
 }
 
+// OpenFile opens the database at the given path, databases in the old
+// snapshot format are migrated to the append-only log format.
+// Only one process can change the database, when another process holds
+// it the database is opened read-only and changes fail with ErrReadOnly
 func OpenFile(filepath string) (*Database, error) {
+	lock, err := Lock(filepath)
+	for deadline := time.Now().Add(LockWait); err == ErrReadOnly && time.Now().Before(deadline); {
+		time.Sleep(lockRetryInterval)
+		lock, err = Lock(filepath)
+	}
+	if err == ErrReadOnly {
+		return openReadOnly(filepath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	db, err := openLocked(filepath)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	db.lock = lock
+	return db, nil
+}
+
+// Lock takes the exclusive lock of the database at the given path, the
+// lock is released by closing the returned file. It fails with ErrReadOnly
+// when another process holds the database
+func Lock(filepath string) (*os.File, error) {
 	os.MkdirAll(path.Dir(filepath), 0700)
+	lock, err := lockFile(filepath + ".lock")
+	if err == errLockHeld {
+		return nil, ErrReadOnly
+	}
+	return lock, err
+}
 
+// openLocked opens the database of the process that holds the lock
+func openLocked(filepath string) (*Database, error) {
 	// Migration code from version 0.3.1
 	if filepath == util.DefaultDBPath() {
 		oldDefault := path.Join(".", "db", "private.db")
@@ -52,45 +96,91 @@ func OpenFile(filepath string) (*Database, error) {
 		}
 	}
 
-	f, err := os.OpenFile(filepath, os.O_CREATE|os.O_RDONLY, 0600)
+	magic, err := readMagic(filepath)
 	if err != nil {
 		return nil, err
 	}
-	defer func(f *os.File) {
-		f.Close()
-	}(f)
-	r := bufio.NewReader(f)
-	magic, _ := binary.ReadUvarint(r)
-	values := make(map[string][]byte)
 	if magic == databaseVersionMagic {
-		numTuples, err := binary.ReadUvarint(r)
-		if err != nil {
-			return nil, err
-		}
-		for i := numTuples; i > 0; i-- {
-			size64, _ := binary.ReadUvarint(r)
-			key := make([]byte, size64)
-			size, err := r.Read(key)
-			if size != len(key) || err != nil {
-				return nil, err
-			}
-			size64, _ = binary.ReadUvarint(r)
-			value := make([]byte, size64)
-			size, err = r.Read(value)
-			if size != len(value) || err != nil {
-				return nil, err
-			}
-			values[string(key)] = value
-		}
+		return migrateSnapshot(filepath)
+	}
+	return Open(filepath, NewLogStorage(filepath))
+}
+
+// openReadOnly loads the database without changing the file, the process
+// that holds the database may be appending a record at the same time
+func openReadOnly(filepath string) (*Database, error) {
+	magic, err := readMagic(filepath)
+	if err != nil {
+		return nil, err
+	}
+	var storage Storage = &LogStorage{path: filepath, readOnly: true}
+	if magic == databaseVersionMagic {
+		storage = NewSnapshotStorage(filepath)
+	}
+	values, err := storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &Database{
+		path:     filepath,
+		values:   values,
+		storage:  storage,
+		readOnly: true,
+	}, nil
+}
+
+// Open returns a database that is persisted by the given storage
+func Open(filepath string, storage Storage) (*Database, error) {
+	values, err := storage.Load()
+	if err != nil {
+		return nil, err
 	}
 	db := &Database{
-		path:   filepath,
-		values: values,
-		buffer: make([]byte, 1024),
+		path:    filepath,
+		values:  values,
+		storage: storage,
+	}
+	if storage.ShouldCompact() {
+		err = storage.Compact(values)
+		if err != nil {
+			log.Printf("Couldn't compact database %s: %v\n", filepath, err)
+		}
 	}
 	return db, nil
 }
 
+// migrateSnapshot converts a database of the databaseVersionMagic format
+// into the append-only log format
+func migrateSnapshot(filepath string) (*Database, error) {
+	values, err := NewSnapshotStorage(filepath).Load()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Migrating database %s to the append-only format\n", filepath)
+	storage := NewLogStorage(filepath)
+	err = storage.Compact(values)
+	if err != nil {
+		return nil, err
+	}
+	storage.Close()
+	return Open(filepath, storage)
+}
+
+// readMagic returns the version magic of the given file or 0 for
+// empty and non existing files
+func readMagic(filepath string) (uint64, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+	magic, _ := binary.ReadUvarint(bufio.NewReader(f))
+	return magic, nil
+}
+
 // Get reads data from the file database
 func (db *Database) Get(key string) ([]byte, error) {
 	db.rm.Lock()
@@ -102,20 +192,39 @@ func (db *Database) Get(key string) ([]byte, error) {
 	return ret, nil
 }
 
+// ReadOnly returns true if another process holds the database
+func (db *Database) ReadOnly() bool {
+	return db.readOnly
+}
+
 // Put data to file database
 func (db *Database) Put(key string, value []byte) (err error) {
 	db.rm.Lock()
 	defer db.rm.Unlock()
+	if db.readOnly {
+		return ErrReadOnly
+	}
 	db.values[key] = value
-	return db.store()
+	err = db.storage.Put(key, value)
+	if err != nil {
+		return
+	}
+	return db.compact()
 }
 
 // Del deletes data from the file database
 func (db *Database) Del(key string) (err error) {
 	db.rm.Lock()
 	defer db.rm.Unlock()
+	if db.readOnly {
+		return ErrReadOnly
+	}
 	delete(db.values, key)
-	return db.store()
+	err = db.storage.Del(key)
+	if err != nil {
+		return
+	}
+	return db.compact()
 }
 
 // List returns all keys
@@ -130,57 +239,21 @@ func (db *Database) List() []string {
 	return list
 }
 
-func (db *Database) store() error {
-	f, err := os.OpenFile(db.path+".tmp", os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
-		return err
-	}
-	w := bufio.NewWriter(f)
-	err = db.put(w, databaseVersionMagic)
-	if err != nil {
-		return err
-	}
-	err = db.put(w, uint64(len(db.values)))
-	if err != nil {
-		return err
-	}
-	for key, value := range db.values {
-		db.put(w, uint64(len(key)))
-		r, err := w.Write([]byte(key))
-		if len(key) != r || err != nil {
-			return err
-		}
-		db.put(w, uint64(len(value)))
-		r, err = w.Write(value)
-		if len(value) != r || err != nil {
-			return err
-		}
-	}
-	err = w.Flush()
-	if err != nil {
-		return err
-	}
-	err = f.Close()
-	if err != nil {
-		return err
-	}
-	// This renaming makes the operation atomic
-	// the database will be written 100% correct or not at
-	return os.Rename(db.path+".tmp", db.path)
-}
-
-func (db *Database) put(w *bufio.Writer, num uint64) error {
-	size := binary.PutUvarint(db.buffer, num)
-	r, err := w.Write(db.buffer[:size])
-	if err != nil {
-		return err
+func (db *Database) compact() error {
+	if !db.storage.ShouldCompact() {
+		return nil
 	}
-	if size != r {
-		return ErrSizeDidNotMatch
-	}
-	return nil
+	return db.storage.Compact(db.values)
 }
 
+// Close the database storage and releases the lock
 func (db *Database) Close() error {
-	return nil
+	db.rm.Lock()
+	defer db.rm.Unlock()
+	err := db.storage.Close()
+	if db.lock != nil {
+		db.lock.Close()
+		db.lock = nil
+	}
+	return err
 }
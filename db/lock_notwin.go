@@ -0,0 +1,29 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+// +build !windows
+
+package db
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock of the given file, the lock is released
+// when the file is closed or the process exits
+func lockFile(filepath string) (*os.File, error) {
+	f, err := os.OpenFile(filepath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, errLockHeld
+		}
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,32 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+// +build windows
+
+package db
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	errorSharingViolation syscall.Errno = 32
+)
+
+// lockFile opens the given file without sharing, so that other processes
+// can't open it until the file is closed or the process exits
+func lockFile(filepath string) (*os.File, error) {
+	path, err := syscall.UTF16PtrFromString(filepath)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(path, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if err == errorSharingViolation {
+			return nil, errLockHeld
+		}
+		return nil, err
+	}
+	return os.NewFile(uintptr(handle), filepath), nil
+}
@@ -0,0 +1,359 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+)
+
+const (
+	logVersionMagic uint64 = 4389235284
+	recordPut       byte   = 1
+	recordDel       byte   = 2
+	// compaction only starts once the log is bigger than this
+	minCompactSize = 64 * 1024
+)
+
+var (
+	ErrChecksumMismatch = fmt.Errorf("record checksum mismatch")
+	ErrUnknownRecord    = fmt.Errorf("unknown record type")
+)
+
+// LogStorage is an append-only storage, every change appends a checksummed
+// record to the end of the file and the file is compacted once most of it
+// consists of outdated records.
+// The file starts with uvarint(logVersionMagic) followed by records of:
+// type (1 byte), uvarint(len(key)), key, [uvarint(len(value)), value], crc32 (4 bytes)
+type LogStorage struct {
+	path   string
+	file   *os.File
+	size   int64
+	live   map[string]int64
+	buffer bytes.Buffer
+	varint []byte
+	// readOnly storages don't change the file, see openReadOnly
+	readOnly bool
+	// Corrupted is the number of bytes that were dropped at load time
+	// because of a checksum mismatch or an incomplete record
+	Corrupted int64
+	// Damaged is the copy of the file that was saved before the corrupted
+	// bytes were dropped
+	Damaged string
+}
+
+// NewLogStorage returns a log storage for the given file path
+func NewLogStorage(filepath string) *LogStorage {
+	return &LogStorage{
+		path:   filepath,
+		live:   make(map[string]int64),
+		varint: make([]byte, binary.MaxVarintLen64),
+	}
+}
+
+// Load replays the log and truncates an incomplete or corrupted tail, the
+// damaged file is copied to the automatic backup directory first. The file
+// is opened in append mode so that concurrent writers can't overwrite each
+// others records
+func (s *LogStorage) Load() (map[string][]byte, error) {
+	if s.readOnly {
+		return s.loadReadOnly()
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte)
+	r := bufio.NewReader(f)
+	magic, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		// new database
+		s.file = f
+		return values, s.writeHeader()
+	}
+	if err != nil || magic != logVersionMagic {
+		f.Close()
+		return nil, fmt.Errorf("%s is not a log database", s.path)
+	}
+	offset := int64(uvarintSize(logVersionMagic))
+	for {
+		op, key, value, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr := err
+			fi, statErr := f.Stat()
+			if statErr == nil {
+				s.Corrupted = fi.Size() - offset
+			}
+			s.Damaged, err = SaveDamaged(s.path)
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("couldn't save damaged database %s: %v", s.path, err)
+			}
+			log.Printf("Database %s: dropping %d bytes after offset %d: %v, the damaged file was saved to %s\n", s.path, s.Corrupted, offset, readErr, s.Damaged)
+			err = f.Truncate(offset)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			break
+		}
+		offset += n
+		if op == recordPut {
+			values[key] = value
+			s.live[key] = n
+		} else {
+			delete(values, key)
+			delete(s.live, key)
+		}
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.file = f
+	s.size = offset
+	return values, nil
+}
+
+// loadReadOnly replays the log up to the first incomplete record
+func (s *LogStorage) loadReadOnly() (map[string][]byte, error) {
+	values := make(map[string][]byte)
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return values, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return values, nil
+	}
+	if err != nil || magic != logVersionMagic {
+		return nil, fmt.Errorf("%s is not a log database", s.path)
+	}
+	for {
+		op, key, value, _, err := readRecord(r)
+		if err != nil {
+			return values, nil
+		}
+		if op == recordPut {
+			values[key] = value
+		} else {
+			delete(values, key)
+		}
+	}
+}
+
+// Put appends a put record
+func (s *LogStorage) Put(key string, value []byte) error {
+	n, err := s.append(recordPut, key, value)
+	if err != nil {
+		return err
+	}
+	s.live[key] = n
+	return nil
+}
+
+// Del appends a delete record
+func (s *LogStorage) Del(key string) error {
+	_, err := s.append(recordDel, key, nil)
+	if err != nil {
+		return err
+	}
+	delete(s.live, key)
+	return nil
+}
+
+// ShouldCompact returns true when more than half of the log is outdated
+func (s *LogStorage) ShouldCompact() bool {
+	if s.size < minCompactSize {
+		return false
+	}
+	var live int64
+	for _, n := range s.live {
+		live += n
+	}
+	return s.size > 2*live
+}
+
+// Compact writes a new log that only contains the given values and
+// atomically replaces the current log with it
+func (s *LogStorage) Compact(values map[string][]byte) error {
+	live := make(map[string]int64, len(values))
+	size := int64(uvarintSize(logVersionMagic))
+	// the log is closed during the rename, this is required on windows
+	s.Close()
+	err := writeFileAtomic(s.path, func(w *bufio.Writer) error {
+		err := putUvarint(w, s.varint, logVersionMagic)
+		if err != nil {
+			return err
+		}
+		for key, value := range values {
+			record := s.encodeRecord(recordPut, key, value)
+			_, err = w.Write(record)
+			if err != nil {
+				return err
+			}
+			live[key] = int64(len(record))
+			size += int64(len(record))
+		}
+		return nil
+	})
+	f, openErr := os.OpenFile(s.path, os.O_RDWR|os.O_APPEND, 0600)
+	if openErr != nil {
+		return openErr
+	}
+	s.file = f
+	if err != nil {
+		// keep appending to the old log
+		s.size, openErr = f.Seek(0, io.SeekEnd)
+		if openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	_, err = f.Seek(size, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	s.size = size
+	s.live = live
+	return nil
+}
+
+// Close the log file
+func (s *LogStorage) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *LogStorage) writeHeader() error {
+	n := binary.PutUvarint(s.varint, logVersionMagic)
+	_, err := s.file.Write(s.varint[:n])
+	if err != nil {
+		return err
+	}
+	s.size = int64(n)
+	return s.file.Sync()
+}
+
+func (s *LogStorage) append(op byte, key string, value []byte) (int64, error) {
+	if s.file == nil {
+		return 0, fmt.Errorf("database %s is closed", s.path)
+	}
+	record := s.encodeRecord(op, key, value)
+	n, err := s.file.Write(record)
+	if err != nil {
+		return 0, err
+	}
+	if n != len(record) {
+		return 0, ErrSizeDidNotMatch
+	}
+	s.size += int64(n)
+	return int64(n), s.file.Sync()
+}
+
+func (s *LogStorage) encodeRecord(op byte, key string, value []byte) []byte {
+	s.buffer.Reset()
+	s.buffer.WriteByte(op)
+	putBytes(&s.buffer, s.varint, []byte(key))
+	if op == recordPut {
+		putBytes(&s.buffer, s.varint, value)
+	}
+	binary.Write(&s.buffer, binary.BigEndian, crc32.ChecksumIEEE(s.buffer.Bytes()))
+	record := make([]byte, s.buffer.Len())
+	copy(record, s.buffer.Bytes())
+	return record
+}
+
+// readRecord returns the next record and its size, io.EOF is only returned
+// when the log ends exactly at a record boundary
+func readRecord(r *bufio.Reader) (op byte, key string, value []byte, n int64, err error) {
+	var raw bytes.Buffer
+	op, err = r.ReadByte()
+	if err != nil {
+		return
+	}
+	raw.WriteByte(op)
+	if op != recordPut && op != recordDel {
+		err = ErrUnknownRecord
+		return
+	}
+	var rawKey []byte
+	rawKey, err = readBytesExact(r, &raw)
+	if err != nil {
+		err = noEOF(err)
+		return
+	}
+	key = string(rawKey)
+	if op == recordPut {
+		value, err = readBytesExact(r, &raw)
+		if err != nil {
+			err = noEOF(err)
+			return
+		}
+	}
+	var checksum uint32
+	err = binary.Read(r, binary.BigEndian, &checksum)
+	if err != nil {
+		err = noEOF(err)
+		return
+	}
+	if checksum != crc32.ChecksumIEEE(raw.Bytes()) {
+		err = ErrChecksumMismatch
+		return
+	}
+	n = int64(raw.Len() + 4)
+	return
+}
+
+// readBytesExact reads a length prefixed byte slice and copies the raw
+// encoding into raw for checksumming
+func readBytesExact(r *bufio.Reader, raw *bytes.Buffer) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxEntrySize {
+		return nil, ErrEntryTooLarge
+	}
+	varint := make([]byte, binary.MaxVarintLen64)
+	raw.Write(varint[:binary.PutUvarint(varint, size)])
+	buf := make([]byte, size)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+	raw.Write(buf)
+	return buf, nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func uvarintSize(num uint64) int {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return binary.PutUvarint(buf, num)
+}
@@ -0,0 +1,227 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// maxEntrySize limits the size of a single key or value read from disk,
+	// so that a corrupted length can't exhaust memory
+	maxEntrySize = 64 * 1024 * 1024
+)
+
+var (
+	ErrEntryTooLarge = fmt.Errorf("entry exceeds maximum size")
+)
+
+// Storage persists the key value pairs of a Database
+type Storage interface {
+	// Load returns all values that were stored before
+	Load() (map[string][]byte, error)
+	// Put stores the value of the given key
+	Put(key string, value []byte) error
+	// Del removes the given key
+	Del(key string) error
+	// ShouldCompact returns true when Compact would free a significant amount of space
+	ShouldCompact() bool
+	// Compact rewrites the storage so that it contains exactly the given values
+	Compact(values map[string][]byte) error
+	// Close releases the underlying resources
+	Close() error
+}
+
+// SnapshotStorage is the storage of the databaseVersionMagic format,
+// it rewrites the entire file on every change
+type SnapshotStorage struct {
+	path   string
+	values map[string][]byte
+	buffer []byte
+}
+
+// NewSnapshotStorage returns a snapshot storage for the given file path
+func NewSnapshotStorage(filepath string) *SnapshotStorage {
+	return &SnapshotStorage{
+		path:   filepath,
+		values: make(map[string][]byte),
+		buffer: make([]byte, binary.MaxVarintLen64),
+	}
+}
+
+// Load reads all values from the snapshot file
+func (s *SnapshotStorage) Load() (map[string][]byte, error) {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	magic, _ := binary.ReadUvarint(r)
+	if magic != databaseVersionMagic {
+		return s.values, nil
+	}
+	values, err := readSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	s.values = values
+	return copyValues(values), nil
+}
+
+// readSnapshot reads the tuples that follow the databaseVersionMagic
+func readSnapshot(r *bufio.Reader) (map[string][]byte, error) {
+	values := make(map[string][]byte)
+	numTuples, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := numTuples; i > 0; i-- {
+		key, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		values[string(key)] = value
+	}
+	return values, nil
+}
+
+// Put stores the value and rewrites the snapshot
+func (s *SnapshotStorage) Put(key string, value []byte) error {
+	s.values[key] = value
+	return s.store()
+}
+
+// Del removes the key and rewrites the snapshot
+func (s *SnapshotStorage) Del(key string) error {
+	delete(s.values, key)
+	return s.store()
+}
+
+// ShouldCompact is always false, snapshots are always compact
+func (s *SnapshotStorage) ShouldCompact() bool {
+	return false
+}
+
+// Compact rewrites the snapshot with the given values
+func (s *SnapshotStorage) Compact(values map[string][]byte) error {
+	s.values = copyValues(values)
+	return s.store()
+}
+
+// Close the snapshot storage
+func (s *SnapshotStorage) Close() error {
+	return nil
+}
+
+func (s *SnapshotStorage) store() error {
+	return writeFileAtomic(s.path, func(w *bufio.Writer) error {
+		err := putUvarint(w, s.buffer, databaseVersionMagic)
+		if err != nil {
+			return err
+		}
+		err = putUvarint(w, s.buffer, uint64(len(s.values)))
+		if err != nil {
+			return err
+		}
+		for key, value := range s.values {
+			err = putBytes(w, s.buffer, []byte(key))
+			if err != nil {
+				return err
+			}
+			err = putBytes(w, s.buffer, value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeFileAtomic writes a temporary file, syncs it and renames it to the
+// given path, the file will be written 100% correct or not at all
+func writeFileAtomic(filepath string, write func(w *bufio.Writer) error) error {
+	tmpPath := filepath + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath)
+}
+
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxEntrySize {
+		return nil, ErrEntryTooLarge
+	}
+	buf := make([]byte, size)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func putUvarint(w io.Writer, buffer []byte, num uint64) error {
+	size := binary.PutUvarint(buffer, num)
+	r, err := w.Write(buffer[:size])
+	if err != nil {
+		return err
+	}
+	if size != r {
+		return ErrSizeDidNotMatch
+	}
+	return nil
+}
+
+func putBytes(w io.Writer, buffer []byte, data []byte) error {
+	err := putUvarint(w, buffer, uint64(len(data)))
+	if err != nil {
+		return err
+	}
+	r, err := w.Write(data)
+	if err != nil {
+		return err
+	}
+	if len(data) != r {
+		return ErrSizeDidNotMatch
+	}
+	return nil
+}
+
+func copyValues(values map[string][]byte) map[string][]byte {
+	ret := make(map[string][]byte, len(values))
+	for key, value := range values {
+		ret[key] = value
+	}
+	return ret
+}
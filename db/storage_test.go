@@ -0,0 +1,217 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package db
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func tempDBPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "diode_db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path.Join(dir, "private.db"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func assertValue(t *testing.T, db *Database, key string, value []byte) {
+	gv, err := db.Get(key)
+	if err != nil {
+		t.Fatalf("Cannot get %s from db: %v", key, err)
+	}
+	if !bytes.Equal(value, gv) {
+		t.Fatalf("Wrong value of %s want %s but got %s", key, value, gv)
+	}
+}
+
+func TestLogStorageReopen(t *testing.T) {
+	filepath, cleanup := tempDBPath(t)
+	defer cleanup()
+	db, err := OpenFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range dbTests {
+		db.Put(v.Key, v.Value)
+	}
+	db.Put("hello", []byte("diode"))
+	db.Del("ibtc")
+	db.Close()
+
+	db, err = OpenFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	assertValue(t, db, "hello", []byte("diode"))
+	assertValue(t, db, "diode", dbTests[1].Value)
+	if _, err = db.Get("ibtc"); err != ErrKeyNotFound {
+		t.Fatalf("Deleted key should not be found")
+	}
+}
+
+func TestLogStorageRecoversTail(t *testing.T) {
+	for _, damage := range []string{"truncate", "checksum"} {
+		filepath, cleanup := tempDBPath(t)
+		db, err := OpenFile(filepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Put("first", []byte("value"))
+		db.Put("second", []byte("value"))
+		db.Close()
+
+		raw, err := ioutil.ReadFile(filepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if damage == "truncate" {
+			raw = raw[:len(raw)-3]
+		} else {
+			raw[len(raw)-6] ^= 0xff
+		}
+		ioutil.WriteFile(filepath, raw, 0600)
+
+		storage := NewLogStorage(filepath)
+		db, err = Open(filepath, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if storage.Corrupted == 0 {
+			t.Fatalf("%s: corrupted bytes should be reported", damage)
+		}
+		// the damaged file is saved before it is truncated
+		damaged, err := ioutil.ReadFile(storage.Damaged)
+		if err != nil || !bytes.Equal(damaged, raw) {
+			t.Fatalf("%s: damaged file should be saved: %v", damage, err)
+		}
+		assertValue(t, db, "first", []byte("value"))
+		if _, err = db.Get("second"); err != ErrKeyNotFound {
+			t.Fatalf("%s: damaged record should be dropped", damage)
+		}
+		db.Put("third", []byte("value"))
+		db.Close()
+
+		db, err = OpenFile(filepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertValue(t, db, "third", []byte("value"))
+		db.Close()
+		cleanup()
+	}
+}
+
+func TestSnapshotMigration(t *testing.T) {
+	filepath, cleanup := tempDBPath(t)
+	defer cleanup()
+	snapshot := NewSnapshotStorage(filepath)
+	for _, v := range dbTests {
+		snapshot.Put(v.Key, v.Value)
+	}
+	db, err := OpenFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range dbTests {
+		assertValue(t, db, v.Key, v.Value)
+	}
+	db.Close()
+	magic, err := readMagic(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if magic != logVersionMagic {
+		t.Fatalf("Database should be migrated to the log format")
+	}
+}
+
+func TestLogStorageCompaction(t *testing.T) {
+	filepath, cleanup := tempDBPath(t)
+	defer cleanup()
+	db, err := OpenFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, 1024)
+	for i := 0; i < 200; i++ {
+		value[0] = byte(i)
+		db.Put("lvbh3", value)
+	}
+	db.Close()
+	fi, err := os.Stat(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() > minCompactSize {
+		t.Fatalf("Database should be compacted but has %d bytes", fi.Size())
+	}
+	db, err = OpenFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	assertValue(t, db, "lvbh3", value)
+}
+
+func TestOpenFileReadOnly(t *testing.T) {
+	filepath, cleanup := tempDBPath(t)
+	defer cleanup()
+	db, err := OpenFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Put("first", []byte("value"))
+	// a record that is still being appended by the process holding the database
+	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{recordPut, 6, 's', 'e'})
+	f.Close()
+	size := fileSize(t, filepath)
+
+	other, err := OpenFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !other.ReadOnly() {
+		t.Fatalf("Database should be read-only while it's held by another process")
+	}
+	assertValue(t, other, "first", []byte("value"))
+	if err = other.Put("second", []byte("value")); err != ErrReadOnly {
+		t.Fatalf("Put should fail with ErrReadOnly but got %v", err)
+	}
+	if err = other.Del("first"); err != ErrReadOnly {
+		t.Fatalf("Del should fail with ErrReadOnly but got %v", err)
+	}
+	other.Close()
+	if fileSize(t, filepath) != size {
+		t.Fatalf("Read-only database shouldn't change the file")
+	}
+	db.Close()
+
+	db, err = OpenFile(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.ReadOnly() {
+		t.Fatalf("Database should be writable once it's closed by the other process")
+	}
+	db.Close()
+}
+
+func fileSize(t *testing.T, filepath string) int64 {
+	fi, err := os.Stat(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"strconv"
 	"strings"
 	"time"
 
@@ -51,8 +50,9 @@ func (rpcClient *RPCClient) handleInboundRequest(inboundRequest interface{}) {
 				return
 			}
 			// Checking blocklist and allowlist
-			if len(rpcClient.Config.Blocklists) > 0 {
-				if rpcClient.Config.Blocklists[portOpen.DeviceID] {
+			blocklists, allowlists := rpcClient.pool.AccessLists()
+			if len(blocklists) > 0 {
+				if blocklists[portOpen.DeviceID] {
 					err := fmt.Errorf(
 						"device %x is on the block list",
 						portOpen.DeviceID,
@@ -61,8 +61,8 @@ func (rpcClient *RPCClient) handleInboundRequest(inboundRequest interface{}) {
 					return
 				}
 			} else {
-				if len(rpcClient.Config.Allowlists) > 0 {
-					if !rpcClient.Config.Allowlists[portOpen.DeviceID] {
+				if len(allowlists) > 0 {
+					if !allowlists[portOpen.DeviceID] {
 						err := fmt.Errorf(
 							"device %x is not in the allow list",
 							portOpen.DeviceID,
@@ -80,7 +80,7 @@ func (rpcClient *RPCClient) handleInboundRequest(inboundRequest interface{}) {
 				rpcClient.Info("Port was not published port = %v", portOpen.PortNumber)
 				return
 			}
-			if publishedPort.Protocol != config.AnyProtocol && publishedPort.Protocol != portOpen.Protocol {
+			if !publishedPort.AcceptsProtocol(portOpen.Protocol) {
 				rpcClient.ResponsePortOpen(portOpen, errPortNotPublished)
 				rpcClient.Info("Port was not published as this type (%v != %v) port = %v", publishedPort.Protocol, portOpen.Protocol, portOpen.PortNumber)
 				return
@@ -98,18 +98,26 @@ func (rpcClient *RPCClient) handleInboundRequest(inboundRequest interface{}) {
 			connDevice := &ConnectedDevice{}
 
 			// connect to stream service
-			host := net.JoinHostPort(localhost, strconv.Itoa(portOpen.SrcPortNumber))
+			_, host := publishedPort.SrcAddr(portOpen.Protocol)
 
-			network := "tcp"
-			if portOpen.Protocol == config.UDPProtocol {
-				network = "udp"
-			}
-
-			remoteConn, err := net.DialTimeout(network, host, rpcClient.localTimeout)
-			if err != nil {
-				_ = rpcClient.ResponsePortOpen(portOpen, err)
-				rpcClient.Error("Failed to connect local: %v", err)
-				return
+			var remoteConn net.Conn
+			var err error
+			if health, ok := rpcClient.pool.PortHealth(publishedPort.To); ok && !health.Healthy {
+				if len(publishedPort.MaintenancePage) == 0 || portOpen.Protocol == config.UDPProtocol {
+					rpcClient.ResponsePortOpen(portOpen, errServiceUnavailable)
+					return
+				}
+				remoteConn = rpcClient.newMaintenanceConn(publishedPort)
+			} else if len(publishedPort.Hosts) > 0 {
+				// the service is chosen by the host name of the first message
+				remoteConn = rpcClient.newVirtualHostConn(publishedPort, portOpen)
+			} else {
+				remoteConn, err = rpcClient.connectPort(publishedPort, portOpen)
+				if err != nil {
+					_ = rpcClient.ResponsePortOpen(portOpen, err)
+					rpcClient.Error("Failed to connect local: %v", err)
+					return
+				}
 			}
 
 			deviceKey := rpcClient.GetDeviceKey(portOpen.Ref)
@@ -117,9 +125,11 @@ func (rpcClient *RPCClient) handleInboundRequest(inboundRequest interface{}) {
 			connDevice.Protocol = portOpen.Protocol
 			connDevice.PortNumber = portOpen.PortNumber
 			connDevice.SrcPortNumber = portOpen.SrcPortNumber
+			connDevice.SrcAddr = host
 			connDevice.ClientID = clientID
 			connDevice.DeviceID = portOpen.DeviceID
 			connDevice.Client = rpcClient
+			connDevice.CreatedAt = time.Now()
 			connDevice.Conn = &DeviceConn{
 				Conn:       remoteConn,
 				bufferSize: sslBufferSize,
@@ -149,12 +159,12 @@ func (rpcClient *RPCClient) handleInboundRequest(inboundRequest interface{}) {
 					closeCh:    make(chan struct{}),
 				}
 			}
-			rpcClient.Debug("Bridge local resource :%d external :%d protocol :%s", portOpen.SrcPortNumber, portOpen.PortNumber, config.ProtocolName(portOpen.Protocol))
+			rpcClient.Debug("Bridge local resource %s external :%d protocol :%s", host, portOpen.PortNumber, config.ProtocolName(portOpen.Protocol))
 
 			rpcClient.pool.SetDevice(deviceKey, connDevice)
 			_ = rpcClient.ResponsePortOpen(portOpen, nil)
 
-			rpcConn := NewRPCConn(rpcClient, connDevice.Ref)
+			rpcConn := connDevice.NewRPCConn()
 			tunnel := NewTunnel(connDevice.Conn, rpcConn, defaultIdleTimeout, sslBufferSize)
 			tunnel.netCopyWithoutTimeout(connDevice.Conn, rpcConn, sslBufferSize)
 			connDevice.Close()
@@ -186,6 +196,7 @@ func (rpcClient *RPCClient) handleInboundRequest(inboundRequest interface{}) {
 		}
 	} else if goodbye, ok := inboundRequest.(edge.Goodbye); ok {
 		rpcClient.Warn("server disconnected, reason: %v", goodbye.Reason)
+		rpcClient.publishEvent(EventGoodbye, map[string]interface{}{"reason": goodbye.Reason})
 		if !rpcClient.Closed() {
 			rpcClient.Close()
 		}
@@ -194,6 +205,27 @@ func (rpcClient *RPCClient) handleInboundRequest(inboundRequest interface{}) {
 	}
 }
 
+// connectPort connects to the published service of the port
+func (rpcClient *RPCClient) connectPort(port *config.Port, portOpen *edge.PortOpen) (net.Conn, error) {
+	if port.Protocol == config.HTTPProtocol {
+		// http requests are proxied to the service with identity headers
+		return rpcClient.newHTTPPortConn(port, portOpen.DeviceID), nil
+	}
+	network, host := port.SrcAddr(portOpen.Protocol)
+	conn, err := net.DialTimeout(network, host, rpcClient.localTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if port.ProxyProtocol && portOpen.Protocol != config.UDPProtocol {
+		_, err = conn.Write(proxyProtocolHeader(portOpen.DeviceID))
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to send proxy protocol header: %v", err)
+		}
+	}
+	return conn, nil
+}
+
 // isAllowlisted returns true if device is allowlisted
 func (rpcClient *RPCClient) isAllowlisted(port *config.Port, addr Address) bool {
 	switch port.Mode {
@@ -211,7 +243,7 @@ func (rpcClient *RPCClient) isAllowlisted(port *config.Port, addr Address) bool
 		}
 
 		for _, fleetAddr := range allowFleets {
-			isAccessWhilisted, err := rpcClient.IsDeviceAllowlisted(fleetAddr, addr)
+			isAccessWhilisted, err := rpcClient.isFleetMember(fleetAddr, addr)
 			if err == nil && isAccessWhilisted {
 				return true
 			}
@@ -219,7 +251,7 @@ func (rpcClient *RPCClient) isAllowlisted(port *config.Port, addr Address) bool
 
 		return false
 	case config.PrivatePublishedMode:
-		return port.Allowlist[addr]
+		return port.Allowlist[addr] || rpcClient.pool.IsGranted(port.To, addr)
 	default:
 		return false
 	}
@@ -403,6 +435,7 @@ func (rpcClient *RPCClient) watchLatestBlock() {
 			rpcClient.Debug("Added block(s) %v-%v, last valid %v", lastblock, blockNumMax, lastn)
 			lastblock = blockNumMax
 			rpcClient.storeLastValid()
+			rpcClient.publishEvent(EventNewBlock, map[string]interface{}{"lastValidBlockNumber": lastn})
 			// }()
 		}
 	}
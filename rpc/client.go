@@ -38,8 +38,6 @@ type RPCConfig struct {
 	ClientAddr   Address
 	RegistryAddr Address
 	FleetAddr    Address
-	Blocklists   map[Address]bool
-	Allowlists   map[Address]bool
 }
 
 // RPCClient struct for rpc client
@@ -382,6 +380,7 @@ func (rpcClient *RPCClient) ValidateNetwork() (bool, error) {
 	rpcClient.bq = win
 	rpcClient.rm.Unlock()
 	rpcClient.storeLastValid()
+	rpcClient.publishEvent(EventNetworkValidated, map[string]interface{}{"lastValidBlockNumber": newlvbn})
 	return true, nil
 }
 
@@ -620,6 +619,13 @@ func (rpcClient *RPCClient) submitTicket(ticket *edge.DeviceTicket) error {
 			}
 		} else if lastTicket.Err == edge.ErrTicketTooOld {
 			rpcClient.Info("received too old ticket")
+		} else {
+			// the server accepted the ticket
+			rpcClient.publishEvent(EventTicketSubmitted, map[string]interface{}{
+				"blockNumber":      ticket.BlockNumber,
+				"totalConnections": ticket.TotalConnections,
+				"totalBytes":       ticket.TotalBytes,
+			})
 		}
 		return nil
 	}
@@ -640,6 +646,17 @@ func (rpcClient *RPCClient) PortOpen(deviceID [20]byte, port string, mode string
 
 // ResponsePortOpen response portopen request
 func (rpcClient *RPCClient) ResponsePortOpen(portOpen *edge.PortOpen, err error) error {
+	data := map[string]interface{}{
+		"device":   util.EncodeToString(portOpen.DeviceID[:]),
+		"port":     portOpen.PortNumber,
+		"protocol": config.ProtocolName(portOpen.Protocol),
+	}
+	if err != nil {
+		data["reason"] = err.Error()
+		rpcClient.publishEvent(EventPortOpenRejected, data)
+	} else {
+		rpcClient.publishEvent(EventPortOpenAccepted, data)
+	}
 	if err != nil {
 		_, err = rpcClient.RespondContext(portOpen.RequestID, "error", "portopen", portOpen.Ref, err.Error())
 	} else {
@@ -884,6 +901,7 @@ func (rpcClient *RPCClient) IsDeviceAllowlisted(fleetAddr Address, clientAddr Ad
 // Reconnect to diode node
 func (rpcClient *RPCClient) Reconnect() bool {
 	isOk := false
+	rpcClient.publishEvent(EventNodeReconnecting, nil)
 	for i := 1; i <= config.AppConfig.RetryTimes; i++ {
 		rpcClient.Info("Retry to connect to %s (%d/%d), wait %s", rpcClient.s.addr, i, config.AppConfig.RetryTimes, config.AppConfig.RetryWait.String())
 		if rpcClient.s.Closed() {
@@ -907,6 +925,7 @@ func (rpcClient *RPCClient) Reconnect() bool {
 		}()
 		if err == nil {
 			isOk = true
+			rpcClient.publishEvent(EventNodeConnected, map[string]interface{}{"reconnected": true})
 			break
 		}
 	}
@@ -939,5 +958,6 @@ func (rpcClient *RPCClient) Close() {
 
 		rpcClient.s.Close()
 		close(rpcClient.callQueue)
+		rpcClient.publishEvent(EventNodeDisconnected, nil)
 	})
 }
@@ -7,22 +7,30 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/util"
 )
 
 // ConnectedDevice connected device
 type ConnectedDevice struct {
+	// byte counters are accessed atomically and have to stay 64-bit aligned
+	bytesSent     uint64
+	bytesReceived uint64
 	Ref           string
 	ClientID      string
 	Protocol      int
 	PortNumber    int
 	SrcPortNumber int
-	DeviceID      Address
-	Conn          net.Conn
-	cd            sync.Once
-	Client        *RPCClient
+	// SrcAddr is the address of the published service of inbound tunnels
+	SrcAddr   string
+	DeviceID  Address
+	Conn      net.Conn
+	cd        sync.Once
+	Client    *RPCClient
+	CreatedAt time.Time
 }
 
 // DeviceConn connected net/websocket connection
@@ -46,24 +54,61 @@ func (device *ConnectedDevice) Close() {
 		}
 
 		if device.Protocol > 0 {
-			device.Client.Debug("Close local resource :%d external :%d protocol :%s", device.SrcPortNumber, device.PortNumber, config.ProtocolName(device.Protocol))
+			device.Client.Debug("Close local resource %s external :%d protocol :%s", device.SrcAddr, device.PortNumber, config.ProtocolName(device.Protocol))
 		}
 
 		// send portclose request and channel
 		device.Client.CastPortClose(device.Ref)
 		device.Conn.Close()
+
+		device.Client.publishEvent(EventTunnelClosed, map[string]interface{}{
+			"ref":           util.EncodeToString([]byte(device.Ref)),
+			"device":        device.DeviceID.HexString(),
+			"port":          device.PortNumber,
+			"protocol":      config.ProtocolName(device.Protocol),
+			"duration":      time.Since(device.CreatedAt).String(),
+			"bytesSent":     device.BytesSent(),
+			"bytesReceived": device.BytesReceived(),
+		})
 	})
 }
 
 // Maybe we should return error
 func (device *ConnectedDevice) Write(data []byte) {
-	_, err := device.Conn.Write(data)
+	n, err := device.Conn.Write(data)
+	if n > 0 {
+		atomic.AddUint64(&device.bytesReceived, uint64(n))
+	}
 	if err != nil {
 		device.Client.Debug("Write failed: %v client_id=%v device_id=%v", err, device.ClientID, device.DeviceID)
 		device.Close()
 	}
 }
 
+// PortSend sends data to the remote device
+func (device *ConnectedDevice) PortSend(data []byte) error {
+	err := device.Client.PortSend(device.Ref, data)
+	if err == nil {
+		atomic.AddUint64(&device.bytesSent, uint64(len(data)))
+	}
+	return err
+}
+
+// NewRPCConn returns the net wrapper that sends data to the remote device
+func (device *ConnectedDevice) NewRPCConn() *RPCConn {
+	return &RPCConn{conn: device.Client, ref: device.Ref, device: device}
+}
+
+// BytesSent returns the number of bytes sent to the remote device
+func (device *ConnectedDevice) BytesSent() uint64 {
+	return atomic.LoadUint64(&device.bytesSent)
+}
+
+// BytesReceived returns the number of bytes received from the remote device
+func (device *ConnectedDevice) BytesReceived() uint64 {
+	return atomic.LoadUint64(&device.bytesReceived)
+}
+
 // LocalAddr returns local network address of device
 func (conn *DeviceConn) LocalAddr() net.Addr {
 	return conn.Conn.LocalAddr()
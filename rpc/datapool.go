@@ -4,6 +4,8 @@
 package rpc
 
 import (
+	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,14 +17,24 @@ import (
 )
 
 type DataPool struct {
-	clientOrder    uint64
-	rm             sync.RWMutex
-	clients        map[util.Address]*RPCClient
-	devices        map[string]*ConnectedDevice
-	publishedPorts map[int]*config.Port
-	memoryCache    *cache.Cache
-	done           chan struct{}
-	cd             sync.Once
+	clientOrder       uint64
+	fleetCacheHits    uint64
+	fleetCacheMisses  uint64
+	fleetCacheExpired uint64
+	rm                sync.RWMutex
+	clients           map[util.Address]*RPCClient
+	devices           map[string]*ConnectedDevice
+	publishedPorts    map[int]*config.Port
+	health            map[int]PortHealth
+	localHandlers     map[int]http.Handler
+	grants            []AccessGrant
+	fleetCache        map[fleetMember]FleetCacheItem
+	blocklists        map[Address]bool
+	allowlists        map[Address]bool
+	memoryCache       *cache.Cache
+	events            *EventBus
+	done              chan struct{}
+	cd                sync.Once
 }
 
 func NewPool() *DataPool {
@@ -31,10 +43,19 @@ func NewPool() *DataPool {
 		clients:        make(map[util.Address]*RPCClient),
 		devices:        make(map[string]*ConnectedDevice),
 		publishedPorts: make(map[int]*config.Port),
+		health:         make(map[int]PortHealth),
+		localHandlers:  make(map[int]http.Handler),
+		fleetCache:     make(map[fleetMember]FleetCacheItem),
+		events:         NewEventBus(),
 		done:           make(chan struct{}),
 	}
 }
 
+// Events returns the event bus of the client
+func (p *DataPool) Events() *EventBus {
+	return p.events
+}
+
 func (p *DataPool) GetCacheBNS(key string) (bns Address, ok bool) {
 	p.rm.RLock()
 	defer p.rm.RUnlock()
@@ -75,6 +96,36 @@ func (p *DataPool) DeleteCacheBNS(key string) {
 	p.memoryCache.Delete(key)
 }
 
+// GetCacheBNSItems returns the cached bns names and their addresses
+func (p *DataPool) GetCacheBNSItems() map[string]Address {
+	p.rm.RLock()
+	defer p.rm.RUnlock()
+	names := make(map[string]Address)
+	for key, item := range p.memoryCache.Items() {
+		if bns, ok := item.Object.(Address); ok && strings.HasPrefix(key, "bns:") {
+			names[strings.TrimPrefix(key, "bns:")] = bns
+		}
+	}
+	return names
+}
+
+// GetCacheDeviceItems returns the cached device tickets
+func (p *DataPool) GetCacheDeviceItems() map[Address]*edge.DeviceTicket {
+	p.rm.RLock()
+	defer p.rm.RUnlock()
+	devices := make(map[Address]*edge.DeviceTicket)
+	for key, item := range p.memoryCache.Items() {
+		tck, ok := item.Object.(*edge.DeviceTicket)
+		if !ok || len(key) != len(Address{}) {
+			continue
+		}
+		var deviceID Address
+		copy(deviceID[:], key)
+		devices[deviceID] = tck
+	}
+	return devices
+}
+
 func (p *DataPool) GetCacheDevice(key Address) *edge.DeviceTicket {
 	return p.GetCache(string(key[:]))
 }
@@ -108,6 +159,17 @@ func (p *DataPool) GetDevice(key string) *ConnectedDevice {
 	return p.devices[key]
 }
 
+// GetDevices returns all connected devices
+func (p *DataPool) GetDevices() []*ConnectedDevice {
+	p.rm.RLock()
+	defer p.rm.RUnlock()
+	devices := make([]*ConnectedDevice, 0, len(p.devices))
+	for _, dev := range p.devices {
+		devices = append(devices, dev)
+	}
+	return devices
+}
+
 // FindDevice tries to locate a connection based on local conn
 func (p *DataPool) FindDevice(clientID string) *ConnectedDevice {
 	p.rm.RLock()
@@ -142,6 +204,41 @@ func (p *DataPool) SetPublishedPorts(ports map[int]*config.Port) {
 	p.publishedPorts = ports
 }
 
+// SetLocalHandler serves the http published ports of the local source port
+// in process, nil removes the handler
+func (p *DataPool) SetLocalHandler(port int, handler http.Handler) {
+	p.rm.Lock()
+	defer p.rm.Unlock()
+	if handler == nil {
+		delete(p.localHandlers, port)
+		return
+	}
+	p.localHandlers[port] = handler
+}
+
+// GetLocalHandler returns the in process handler of the local source port
+func (p *DataPool) GetLocalHandler(port int) http.Handler {
+	p.rm.RLock()
+	defer p.rm.RUnlock()
+	return p.localHandlers[port]
+}
+
+// SetAccessLists replaces the device block and allow lists, the given maps
+// must not be modified afterwards
+func (p *DataPool) SetAccessLists(blocklists map[Address]bool, allowlists map[Address]bool) {
+	p.rm.Lock()
+	defer p.rm.Unlock()
+	p.blocklists = blocklists
+	p.allowlists = allowlists
+}
+
+// AccessLists returns the device block and allow lists
+func (p *DataPool) AccessLists() (blocklists map[Address]bool, allowlists map[Address]bool) {
+	p.rm.RLock()
+	defer p.rm.RUnlock()
+	return p.blocklists, p.allowlists
+}
+
 func (p *DataPool) WaitClients() {
 	<-p.done
 }
@@ -171,6 +268,17 @@ func (p *DataPool) SetClient(nodeID util.Address, client *RPCClient) {
 	}
 }
 
+// GetClients returns all connected clients
+func (p *DataPool) GetClients() []*RPCClient {
+	p.rm.RLock()
+	defer p.rm.RUnlock()
+	clients := make([]*RPCClient, 0, len(p.clients))
+	for _, client := range p.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
 func (p *DataPool) GetClientByOrder(order int) (client *RPCClient) {
 	for _, client = range p.clients {
 		if client.Order == order {
@@ -0,0 +1,597 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+)
+
+// Compare reference at: https://tools.ietf.org/html/rfc1035
+// Chapter: 4. Messages
+const (
+	dnsHeaderSize   = 12
+	dnsTypeA        = 1
+	dnsClassIN      = 1
+	dnsFlagResponse = 0x8000
+	dnsFlagAuth     = 0x0400
+	dnsFlagRecurse  = 0x0100
+	dnsMaskOpcode   = 0x7800
+	dnsRcodeOK      = 0
+	dnsRcodeFormat  = 1
+	dnsRcodeServer  = 2
+	dnsRcodeName    = 3
+	dnsRcodeNotImp  = 4
+	dnsRcodeRefused = 5
+	dnsUDPSize      = 512
+	dnsTimeout      = 10 * time.Second
+)
+
+var (
+	errDNSFormat   = fmt.Errorf("malformed dns query")
+	errDNSPoolFull = fmt.Errorf("dns address pool is exhausted")
+)
+
+// DNSConfig is the configuration of the dns server
+type DNSConfig struct {
+	Addr string
+	// Pool are the loopback addresses handed out for devices
+	Pool *net.IPNet
+	// Ports are forwarded from the address of a device to the same port of
+	// the device
+	Ports []int
+	// TTL of the answers
+	TTL time.Duration
+	// IdleTimeout closes mappings without queries and connections
+	IdleTimeout time.Duration
+	// Upstream resolves other names when not empty, otherwise they are
+	// refused
+	Upstream string
+	// WithoutAuth serves devices although the socks server has users, the
+	// mappings don't authenticate so every local process can use them
+	WithoutAuth bool
+}
+
+type dnsMapping struct {
+	key       string
+	deviceID  string
+	mode      string
+	ip        net.IP
+	listeners map[int]net.Listener
+	active    int32
+	lastUsed  int64
+}
+
+func (mapping *dnsMapping) touch() {
+	atomic.StoreInt64(&mapping.lastUsed, time.Now().UnixNano())
+}
+
+func (mapping *dnsMapping) idle() time.Duration {
+	if atomic.LoadInt32(&mapping.active) > 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, atomic.LoadInt64(&mapping.lastUsed)))
+}
+
+func (mapping *dnsMapping) close() {
+	for _, listener := range mapping.listeners {
+		listener.Close()
+	}
+}
+
+// DNSServer answers queries for diode and BNS names with loopback addresses
+// and forwards connections to these addresses to the device, like binds
+// that are created on demand
+type DNSServer struct {
+	Config      DNSConfig
+	logger      *config.Logger
+	socksServer *Server
+	udp         net.PacketConn
+	tcp         net.Listener
+	rm          sync.Mutex
+	mappings    map[string]*dnsMapping
+	ips         map[string]*dnsMapping
+	// unresolved are the bns names that weren't found and when they are
+	// looked up again
+	unresolved map[string]time.Time
+	lookup     func(name string) (Address, error)
+	next       uint32
+	closeCh    chan struct{}
+	cd         sync.Once
+}
+
+// dnsQuestion is the single question of a query
+type dnsQuestion struct {
+	Name  string
+	Type  uint16
+	Class uint16
+	raw   []byte
+}
+
+// parseDNSQuery returns the question of a standard query
+func parseDNSQuery(msg []byte) (q dnsQuestion, err error) {
+	if len(msg) < dnsHeaderSize {
+		err = errDNSFormat
+		return
+	}
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	if flags&dnsFlagResponse != 0 || binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		err = errDNSFormat
+		return
+	}
+	labels := []string{}
+	off := dnsHeaderSize
+	for {
+		if off >= len(msg) {
+			err = errDNSFormat
+			return
+		}
+		size := int(msg[off])
+		off++
+		if size == 0 {
+			break
+		}
+		// queries don't compress the question
+		if size&0xC0 != 0 || off+size > len(msg) {
+			err = errDNSFormat
+			return
+		}
+		labels = append(labels, string(msg[off:off+size]))
+		off += size
+	}
+	if off+4 > len(msg) {
+		err = errDNSFormat
+		return
+	}
+	q.Name = strings.ToLower(strings.Join(labels, "."))
+	q.Type = binary.BigEndian.Uint16(msg[off : off+2])
+	q.Class = binary.BigEndian.Uint16(msg[off+2 : off+4])
+	q.raw = msg[dnsHeaderSize : off+4]
+	return
+}
+
+// dnsResponse returns the answer to the query, the question is only
+// repeated when it could be parsed
+func dnsResponse(query []byte, q *dnsQuestion, rcode int, ips []net.IP, ttl uint32) []byte {
+	res := make([]byte, dnsHeaderSize, dnsUDPSize)
+	copy(res[0:2], query[0:2])
+	flags := binary.BigEndian.Uint16(query[2:4])
+	flags = dnsFlagResponse | dnsFlagAuth | flags&(dnsMaskOpcode|dnsFlagRecurse) | uint16(rcode)
+	binary.BigEndian.PutUint16(res[2:4], flags)
+	if q == nil {
+		return res
+	}
+	binary.BigEndian.PutUint16(res[4:6], 1)
+	binary.BigEndian.PutUint16(res[6:8], uint16(len(ips)))
+	res = append(res, q.raw...)
+	for _, ip := range ips {
+		// the name is a pointer to the question
+		res = append(res, 0xC0, dnsHeaderSize, 0, dnsTypeA, 0, dnsClassIN)
+		res = append(res, byte(ttl>>24), byte(ttl>>16), byte(ttl>>8), byte(ttl))
+		res = append(res, 0, net.IPv4len)
+		res = append(res, ip.To4()...)
+	}
+	return res
+}
+
+// NewDNSServer returns a dns server that connects devices through the socks
+// server
+func NewDNSServer(socksServer *Server) *DNSServer {
+	return &DNSServer{
+		socksServer: socksServer,
+		logger:      config.AppConfig.Logger,
+		mappings:    make(map[string]*dnsMapping),
+		ips:         make(map[string]*dnsMapping),
+		unresolved:  make(map[string]time.Time),
+		lookup:      socksServer.resolveDevice,
+		closeCh:     make(chan struct{}),
+	}
+}
+
+// SetConfig sets the configuration of the dns server
+func (dnsServer *DNSServer) SetConfig(cfg DNSConfig) error {
+	if cfg.Pool == nil || cfg.Pool.IP.To4() == nil || !cfg.Pool.IP.IsLoopback() {
+		return fmt.Errorf("dns pool should be an ipv4 loopback network")
+	}
+	if ones, bits := cfg.Pool.Mask.Size(); bits-ones < 2 {
+		return fmt.Errorf("dns pool %s is too small", cfg.Pool.String())
+	}
+	if len(cfg.Ports) == 0 {
+		return fmt.Errorf("dns server needs ports to forward")
+	}
+	if cfg.IdleTimeout < cfg.TTL {
+		return fmt.Errorf("dns idle timeout %s should be longer than the ttl %s", cfg.IdleTimeout, cfg.TTL)
+	}
+	dnsServer.Config = cfg
+	return nil
+}
+
+// Start the dns server on udp and tcp
+func (dnsServer *DNSServer) Start() (err error) {
+	if dnsServer.Closed() {
+		return nil
+	}
+	dnsServer.logger.Info("Start dns server %s", dnsServer.Config.Addr)
+	dnsServer.udp, err = net.ListenPacket("udp", dnsServer.Config.Addr)
+	if err != nil {
+		return
+	}
+	dnsServer.tcp, err = net.Listen("tcp", dnsServer.Config.Addr)
+	if err != nil {
+		dnsServer.udp.Close()
+		return
+	}
+	go dnsServer.serveUDP()
+	go dnsServer.serveTCP()
+	go dnsServer.expire()
+	return nil
+}
+
+func (dnsServer *DNSServer) serveUDP() {
+	buf := make([]byte, dnsUDPSize)
+	for {
+		n, addr, err := dnsServer.udp.ReadFrom(buf)
+		if err != nil {
+			if isOpError(err) {
+				return
+			}
+			dnsServer.logger.Error("DNS server error: %v", err)
+			continue
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go func() {
+			if res := dnsServer.handle(query, false); res != nil {
+				dnsServer.udp.WriteTo(res, addr)
+			}
+		}()
+	}
+}
+
+func (dnsServer *DNSServer) serveTCP() {
+	for {
+		conn, err := dnsServer.tcp.Accept()
+		if err != nil {
+			// Accept will return op close error/syscall.EINVAL
+			if !isOpError(err) {
+				dnsServer.logger.Error(err.Error())
+			}
+			return
+		}
+		go dnsServer.handleTCP(conn)
+	}
+}
+
+// handleTCP answers the length prefixed queries of the connection
+func (dnsServer *DNSServer) handleTCP(conn net.Conn) {
+	defer conn.Close()
+	size := make([]byte, 2)
+	for {
+		conn.SetDeadline(time.Now().Add(dnsTimeout))
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(size))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		res := dnsServer.handle(query, true)
+		if res == nil {
+			return
+		}
+		binary.BigEndian.PutUint16(size, uint16(len(res)))
+		if _, err := conn.Write(append(size, res...)); err != nil {
+			return
+		}
+	}
+}
+
+// handle returns the response to the query, A queries of diode names are
+// answered with the address of the device mapping
+func (dnsServer *DNSServer) handle(query []byte, tcp bool) []byte {
+	if len(query) < dnsHeaderSize {
+		return nil
+	}
+	q, err := parseDNSQuery(query)
+	if err != nil {
+		return dnsResponse(query, nil, dnsRcodeFormat, nil, 0)
+	}
+	if binary.BigEndian.Uint16(query[2:4])&dnsMaskOpcode != 0 {
+		return dnsResponse(query, &q, dnsRcodeNotImp, nil, 0)
+	}
+	mode, deviceID, ok := dnsServer.target(q.Name)
+	if !ok {
+		return dnsServer.forward(query, &q, tcp)
+	}
+	if len(deviceID) == 0 {
+		return dnsResponse(query, &q, dnsRcodeName, nil, 0)
+	}
+	if dnsServer.needsAuth() {
+		return dnsResponse(query, &q, dnsRcodeRefused, nil, 0)
+	}
+	mapping, err := dnsServer.mapDevice(mode, deviceID)
+	if err != nil {
+		dnsServer.logger.Debug("DNS couldn't map %s: %v", q.Name, err)
+		if err == errDNSPoolFull {
+			return dnsResponse(query, &q, dnsRcodeServer, nil, 0)
+		}
+		return dnsResponse(query, &q, dnsRcodeName, nil, 0)
+	}
+	// other record types of devices have no answers
+	if q.Type != dnsTypeA || q.Class != dnsClassIN {
+		return dnsResponse(query, &q, dnsRcodeOK, nil, 0)
+	}
+	ttl := uint32(dnsServer.Config.TTL.Seconds())
+	return dnsResponse(query, &q, dnsRcodeOK, []net.IP{mapping.ip}, ttl)
+}
+
+// needsAuth returns true when the socks server has users, the mappings
+// can't authenticate them
+func (dnsServer *DNSServer) needsAuth() bool {
+	return len(dnsServer.socksServer.Config().Users) > 0 && !dnsServer.Config.WithoutAuth
+}
+
+// target returns the mode and device of diode names and of names that are
+// registered in BNS, deviceID is empty for invalid diode names
+func (dnsServer *DNSServer) target(name string) (mode string, deviceID string, ok bool) {
+	if isDiodeHost(name) {
+		isWS, mode, deviceID, _, err := parseHost(name)
+		if err != nil || isWS {
+			return "", "", true
+		}
+		return mode, deviceID, true
+	}
+	// single labels are looked up in BNS
+	if strings.Contains(name, ".") || !subDomainpattern.MatchString(name) {
+		return "", "", false
+	}
+	if _, err := dnsServer.resolveDevice(name); err != nil {
+		return "", "", false
+	}
+	return defaultMode, name, true
+}
+
+// resolveDevice resolves the device name, bns names that weren't found are
+// not looked up again for the ttl of the answers
+func (dnsServer *DNSServer) resolveDevice(name string) (Address, error) {
+	dnsServer.rm.Lock()
+	retryAt, ok := dnsServer.unresolved[name]
+	dnsServer.rm.Unlock()
+	if ok && time.Now().Before(retryAt) {
+		return Address{}, fmt.Errorf("bns name %s wasn't found", name)
+	}
+	deviceID, err := dnsServer.lookup(name)
+	if err != nil && !errors.Is(err, errNoConnectedNode) {
+		dnsServer.rm.Lock()
+		dnsServer.unresolved[name] = time.Now().Add(dnsServer.Config.TTL)
+		dnsServer.rm.Unlock()
+	}
+	return deviceID, err
+}
+
+// forward sends the query to the upstream resolver
+func (dnsServer *DNSServer) forward(query []byte, q *dnsQuestion, tcp bool) []byte {
+	if len(dnsServer.Config.Upstream) == 0 {
+		return dnsResponse(query, q, dnsRcodeRefused, nil, 0)
+	}
+	network := "udp"
+	if tcp {
+		network = "tcp"
+	}
+	conn, err := net.DialTimeout(network, dnsServer.Config.Upstream, dnsTimeout)
+	if err != nil {
+		dnsServer.logger.Error("DNS upstream error: %v", err)
+		return dnsResponse(query, q, dnsRcodeServer, nil, 0)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+	if tcp {
+		size := []byte{byte(len(query) >> 8), byte(len(query))}
+		if _, err = conn.Write(append(size, query...)); err == nil {
+			if _, err = io.ReadFull(conn, size); err == nil {
+				res := make([]byte, binary.BigEndian.Uint16(size))
+				if _, err = io.ReadFull(conn, res); err == nil {
+					return res
+				}
+			}
+		}
+	} else if _, err = conn.Write(query); err == nil {
+		// answers can be larger than 512 bytes for EDNS queries
+		res := make([]byte, udpBufferSize)
+		var n int
+		if n, err = conn.Read(res); err == nil {
+			return res[:n]
+		}
+	}
+	dnsServer.logger.Error("DNS upstream error: %v", err)
+	return dnsResponse(query, q, dnsRcodeServer, nil, 0)
+}
+
+// allocateIP returns the next free address of the pool, the caller has to
+// hold the lock
+func (dnsServer *DNSServer) allocateIP() (net.IP, error) {
+	pool := dnsServer.Config.Pool
+	ones, bits := pool.Mask.Size()
+	// the network and broadcast addresses are skipped
+	size := uint32(1)<<uint(bits-ones) - 2
+	base := binary.BigEndian.Uint32(pool.IP.To4())
+	for i := uint32(0); i < size; i++ {
+		offset := (dnsServer.next+i)%size + 1
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, base+offset)
+		if _, ok := dnsServer.ips[ip.String()]; !ok {
+			dnsServer.next = offset % size
+			return ip, nil
+		}
+	}
+	return nil, errDNSPoolFull
+}
+
+// mapDevice returns the mapping of the device, new mappings listen on the
+// configured ports of a free pool address
+func (dnsServer *DNSServer) mapDevice(mode string, deviceID string) (*dnsMapping, error) {
+	key := fmt.Sprintf("%s-%s", mode, strings.ToLower(deviceID))
+	dnsServer.rm.Lock()
+	mapping, ok := dnsServer.mappings[key]
+	dnsServer.rm.Unlock()
+	if ok {
+		mapping.touch()
+		return mapping, nil
+	}
+	// resolve the name before handing out an address
+	if _, err := dnsServer.resolveDevice(deviceID); err != nil {
+		return nil, err
+	}
+
+	dnsServer.rm.Lock()
+	defer dnsServer.rm.Unlock()
+	if mapping, ok = dnsServer.mappings[key]; ok {
+		mapping.touch()
+		return mapping, nil
+	}
+	ip, err := dnsServer.allocateIP()
+	if err != nil {
+		return nil, err
+	}
+	mapping = &dnsMapping{
+		key:       key,
+		deviceID:  deviceID,
+		mode:      mode,
+		ip:        ip,
+		listeners: make(map[int]net.Listener),
+	}
+	mapping.touch()
+	for _, port := range dnsServer.Config.Ports {
+		address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			dnsServer.logger.Warn("DNS couldn't listen on %s for %s: %v", address, deviceID, err)
+			continue
+		}
+		mapping.listeners[port] = listener
+		go dnsServer.serveMapping(mapping, listener, port)
+	}
+	if len(mapping.listeners) == 0 {
+		return nil, fmt.Errorf("couldn't listen on %s", ip.String())
+	}
+	dnsServer.logger.Info("DNS mapped %s to %s", deviceID, ip.String())
+	dnsServer.mappings[key] = mapping
+	dnsServer.ips[ip.String()] = mapping
+	return mapping, nil
+}
+
+// serveMapping forwards the connections of the mapping to the device port
+func (dnsServer *DNSServer) serveMapping(mapping *dnsMapping, listener net.Listener, port int) {
+	protocol := config.TCPProtocol
+	if config.AppConfig.EnableEdgeE2E {
+		protocol = config.TLSProtocol
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// Accept will return op close error/syscall.EINVAL
+			if !isOpError(err) {
+				dnsServer.logger.Error(err.Error())
+			}
+			return
+		}
+		// users can be added by a config reload
+		if dnsServer.needsAuth() {
+			dnsServer.logger.Warn("DNS refused connection to %s, socks users are configured", mapping.deviceID)
+			conn.Close()
+			continue
+		}
+		mapping.touch()
+		atomic.AddInt32(&mapping.active, 1)
+		go func() {
+			defer mapping.touch()
+			defer atomic.AddInt32(&mapping.active, -1)
+			err := dnsServer.socksServer.connectDeviceAndLoop(mapping.deviceID, port, protocol, mapping.mode, defaultIdleTimeout, func(*ConnectedDevice) (*DeviceConn, error) {
+				return &DeviceConn{
+					Conn:       conn,
+					bufferSize: sslBufferSize,
+					closeCh:    make(chan struct{}),
+				}, nil
+			})
+			if err != nil {
+				dnsServer.logger.Error("Failed to connectDevice(%v): %v", mapping.deviceID, err.Error())
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// expire closes the mappings that had no queries and connections for the
+// idle timeout
+func (dnsServer *DNSServer) expire() {
+	interval := dnsServer.Config.IdleTimeout / 4
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-dnsServer.closeCh:
+			return
+		case <-ticker.C:
+			dnsServer.expireIdle(dnsServer.Config.IdleTimeout)
+		}
+	}
+}
+
+func (dnsServer *DNSServer) expireIdle(idleTimeout time.Duration) {
+	dnsServer.rm.Lock()
+	defer dnsServer.rm.Unlock()
+	now := time.Now()
+	for name, retryAt := range dnsServer.unresolved {
+		if now.After(retryAt) {
+			delete(dnsServer.unresolved, name)
+		}
+	}
+	for key, mapping := range dnsServer.mappings {
+		if mapping.idle() < idleTimeout {
+			continue
+		}
+		dnsServer.logger.Info("DNS removed idle mapping %s of %s", mapping.ip.String(), mapping.deviceID)
+		mapping.close()
+		delete(dnsServer.mappings, key)
+		delete(dnsServer.ips, mapping.ip.String())
+	}
+}
+
+// Closed returns whether the dns server had closed
+func (dnsServer *DNSServer) Closed() bool {
+	return isClosed(dnsServer.closeCh)
+}
+
+// Close the dns server and all mappings
+func (dnsServer *DNSServer) Close() {
+	dnsServer.cd.Do(func() {
+		close(dnsServer.closeCh)
+		if dnsServer.udp != nil {
+			dnsServer.udp.Close()
+		}
+		if dnsServer.tcp != nil {
+			dnsServer.tcp.Close()
+		}
+		dnsServer.rm.Lock()
+		for key, mapping := range dnsServer.mappings {
+			mapping.close()
+			delete(dnsServer.mappings, key)
+		}
+		dnsServer.ips = make(map[string]*dnsMapping)
+		dnsServer.rm.Unlock()
+	})
+}
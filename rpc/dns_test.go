@@ -0,0 +1,226 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+)
+
+func dnsQuery(name string, qtype uint16) []byte {
+	query := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(name, ".") {
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	return append(query, 0, byte(qtype>>8), byte(qtype), 0, dnsClassIN)
+}
+
+func testDNSQuery(t *testing.T, addr string, name string, qtype uint16) (rcode int, ips []net.IP) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	query := dnsQuery(name, qtype)
+	conn.Write(query)
+	res := make([]byte, 512)
+	n, err := conn.Read(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = res[:n]
+	if res[0] != 0x12 || res[1] != 0x34 || res[2]&0x80 == 0 {
+		t.Fatalf("wrong response header %v", res[:4])
+	}
+	rcode = int(res[3] & 0x0F)
+	answers := int(binary.BigEndian.Uint16(res[6:8]))
+	off := len(query)
+	for i := 0; i < answers; i++ {
+		rdlen := int(binary.BigEndian.Uint16(res[off+10 : off+12]))
+		ips = append(ips, net.IP(res[off+12:off+12+rdlen]))
+		off += 12 + rdlen
+	}
+	return
+}
+
+func newTestDNSServer(t *testing.T, pool string, upstream string) *DNSServer {
+	config.AppConfig = testConfig()
+	_, ipnet, _ := net.ParseCIDR(pool)
+	dnsServer := NewDNSServer(NewSocksServer(nil))
+	err := dnsServer.SetConfig(DNSConfig{
+		Addr:        "127.0.0.1:0",
+		Pool:        ipnet,
+		Ports:       []int{8080},
+		TTL:         time.Minute,
+		IdleTimeout: 10 * time.Minute,
+		Upstream:    upstream,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dnsServer
+}
+
+func startTestDNSServer(t *testing.T, pool string, upstream string) *DNSServer {
+	dnsServer := newTestDNSServer(t, pool, upstream)
+	if err := dnsServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return dnsServer
+}
+
+func TestDNSServer(t *testing.T) {
+	dnsServer := startTestDNSServer(t, "127.77.0.0/30", "")
+	defer dnsServer.Close()
+	addr := dnsServer.udp.LocalAddr().String()
+
+	name := "0x937c492a77ae90de971986d003ffbc5f8bb2232c.diode"
+	rcode, ips := testDNSQuery(t, addr, name, dnsTypeA)
+	if rcode != dnsRcodeOK || len(ips) != 1 || ips[0].String() != "127.77.0.1" {
+		t.Fatalf("wrong answer %d %v", rcode, ips)
+	}
+	// the mapping forwards the configured port
+	dnsServer.rm.Lock()
+	mapping := dnsServer.ips["127.77.0.1"]
+	dnsServer.rm.Unlock()
+	if mapping == nil || mapping.listeners[8080] == nil {
+		t.Fatalf("mapping should listen on 8080")
+	}
+	// the same device keeps its address
+	rcode, ips = testDNSQuery(t, addr, "R-"+strings.ToUpper(name[:42])+".diode.link", dnsTypeA)
+	if rcode != dnsRcodeOK || len(ips) != 1 || ips[0].String() != "127.77.0.2" {
+		t.Errorf("other mode should get a new address %d %v", rcode, ips)
+	}
+	rcode, ips = testDNSQuery(t, addr, name, dnsTypeA)
+	if rcode != dnsRcodeOK || len(ips) != 1 || ips[0].String() != "127.77.0.1" {
+		t.Errorf("mapping should be kept %d %v", rcode, ips)
+	}
+	rcode, ips = testDNSQuery(t, addr, name, 28)
+	if rcode != dnsRcodeOK || len(ips) != 0 {
+		t.Errorf("AAAA should have no answers %d %v", rcode, ips)
+	}
+	// the pool of a /30 has two addresses
+	rcode, _ = testDNSQuery(t, addr, "0x1111111111111111111111111111111111111111.diode", dnsTypeA)
+	if rcode != dnsRcodeServer {
+		t.Errorf("exhausted pool should fail but got %d", rcode)
+	}
+	rcode, _ = testDNSQuery(t, addr, "0x1234.diode", dnsTypeA)
+	if rcode != dnsRcodeName {
+		t.Errorf("invalid address should not exist but got %d", rcode)
+	}
+	rcode, _ = testDNSQuery(t, addr, "example.com", dnsTypeA)
+	if rcode != dnsRcodeRefused {
+		t.Errorf("other names should be refused but got %d", rcode)
+	}
+	// the mappings can't authenticate socks users
+	dnsServer.socksServer.SetConfig(&Config{Users: map[string]*config.SocksUser{"alice": {Name: "alice"}}})
+	rcode, _ = testDNSQuery(t, addr, name, dnsTypeA)
+	if rcode != dnsRcodeRefused {
+		t.Errorf("devices should be refused with socks users but got %d", rcode)
+	}
+	withoutAuth := NewDNSServer(dnsServer.socksServer)
+	withoutAuth.Config.WithoutAuth = true
+	if withoutAuth.needsAuth() {
+		t.Errorf("devices should be served without auth")
+	}
+	dnsServer.socksServer.SetConfig(&Config{})
+
+	dnsServer.expireIdle(0)
+	listener, err := net.Listen("tcp", "127.77.0.1:8080")
+	if err != nil {
+		t.Errorf("idle mapping should be closed: %v", err)
+	} else {
+		listener.Close()
+	}
+	rcode, ips = testDNSQuery(t, addr, "0x1111111111111111111111111111111111111111.diode", dnsTypeA)
+	if rcode != dnsRcodeOK || len(ips) != 1 {
+		t.Errorf("expired addresses should be reused %d %v", rcode, ips)
+	}
+}
+
+func TestDNSUpstream(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q, _ := parseDNSQuery(buf[:n])
+			upstream.WriteTo(dnsResponse(buf[:n], &q, dnsRcodeOK, []net.IP{net.IPv4(1, 2, 3, 4)}, 60), addr)
+		}
+	}()
+	dnsServer := startTestDNSServer(t, "127.77.1.0/24", upstream.LocalAddr().String())
+	defer dnsServer.Close()
+
+	rcode, ips := testDNSQuery(t, dnsServer.udp.LocalAddr().String(), "example.com", dnsTypeA)
+	if rcode != dnsRcodeOK || len(ips) != 1 || ips[0].String() != "1.2.3.4" {
+		t.Errorf("upstream answer should be relayed %d %v", rcode, ips)
+	}
+}
+
+func TestDNSUnresolvedCache(t *testing.T) {
+	dnsServer := newTestDNSServer(t, "127.77.2.0/24", "")
+	// the lookups run on the goroutine of the server
+	var rm sync.Mutex
+	lookups := 0
+	lookupErr := fmt.Errorf("not found")
+	dnsServer.lookup = func(name string) (Address, error) {
+		rm.Lock()
+		defer rm.Unlock()
+		lookups++
+		return Address{}, lookupErr
+	}
+	countLookups := func() int {
+		rm.Lock()
+		defer rm.Unlock()
+		return lookups
+	}
+	if err := dnsServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer dnsServer.Close()
+	addr := dnsServer.udp.LocalAddr().String()
+
+	for i := 0; i < 2; i++ {
+		rcode, _ := testDNSQuery(t, addr, "unknown", dnsTypeA)
+		if rcode != dnsRcodeRefused {
+			t.Errorf("unknown bns name should be refused but got %d", rcode)
+		}
+	}
+	if countLookups() != 1 {
+		t.Errorf("unknown bns name should be looked up once within the ttl but was looked up %d times", countLookups())
+	}
+	dnsServer.rm.Lock()
+	dnsServer.unresolved["unknown"] = time.Now().Add(-time.Second)
+	dnsServer.rm.Unlock()
+	testDNSQuery(t, addr, "unknown", dnsTypeA)
+	if countLookups() != 2 {
+		t.Errorf("unknown bns name should be looked up again after the ttl")
+	}
+
+	// lookups without node are not cached
+	rm.Lock()
+	lookupErr = errNoConnectedNode
+	rm.Unlock()
+	testDNSQuery(t, addr, "offline", dnsTypeA)
+	testDNSQuery(t, addr, "offline", dnsTypeA)
+	if countLookups() != 4 {
+		t.Errorf("lookups without node should be retried but got %d lookups", countLookups())
+	}
+}
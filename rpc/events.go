@@ -0,0 +1,148 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published on the event bus
+const (
+	EventNodeConnected    = "node_connected"
+	EventNodeDisconnected = "node_disconnected"
+	EventNodeReconnecting = "node_reconnecting"
+	EventNetworkValidated = "network_validated"
+	EventNewBlock         = "new_block"
+	EventTicketSubmitted  = "ticket_submitted"
+	EventPortOpenAccepted = "portopen_accepted"
+	EventPortOpenRejected = "portopen_rejected"
+	EventTunnelClosed     = "tunnel_closed"
+	EventGoodbye          = "goodbye"
+	EventPortHealth       = "port_health"
+	EventGrantExpired     = "grant_expired"
+
+	// eventBufferSize is the number of events a subscriber can fall behind
+	// before events are dropped
+	eventBufferSize = 256
+)
+
+// EventTypes are all event types
+var EventTypes = []string{
+	EventNodeConnected,
+	EventNodeDisconnected,
+	EventNodeReconnecting,
+	EventNetworkValidated,
+	EventNewBlock,
+	EventTicketSubmitted,
+	EventPortOpenAccepted,
+	EventPortOpenRejected,
+	EventTunnelClosed,
+	EventGoodbye,
+	EventPortHealth,
+	EventGrantExpired,
+}
+
+// Event is something that happened in the client
+type Event struct {
+	Type string                 `json:"type"`
+	Time time.Time              `json:"time"`
+	Node string                 `json:"node,omitempty"`
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventBus delivers events to subscribers, slow subscribers miss events
+// instead of blocking the publisher
+type EventBus struct {
+	rm          sync.RWMutex
+	subscribers map[*Subscription]bool
+}
+
+// Subscription receives the events of the subscribed types
+type Subscription struct {
+	bus    *EventBus
+	types  map[string]bool
+	events chan Event
+	cd     sync.Once
+}
+
+// NewEventBus returns a new event bus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+// IsEventType returns true if the given type is a known event type
+func IsEventType(typ string) bool {
+	for _, t := range EventTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscribe returns a subscription to the given event types, all events are
+// delivered if no type is given
+func (bus *EventBus) Subscribe(types ...string) *Subscription {
+	sub := &Subscription{
+		bus:    bus,
+		types:  make(map[string]bool),
+		events: make(chan Event, eventBufferSize),
+	}
+	for _, typ := range types {
+		sub.types[typ] = true
+	}
+	bus.rm.Lock()
+	defer bus.rm.Unlock()
+	bus.subscribers[sub] = true
+	return sub
+}
+
+// Publish sends the event to all subscribers
+func (bus *EventBus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	bus.rm.RLock()
+	defer bus.rm.RUnlock()
+	for sub := range bus.subscribers {
+		if len(sub.types) > 0 && !sub.types[event.Type] {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
+
+// Events returns the channel of the subscribed events, the channel is
+// closed when the subscription is closed
+func (sub *Subscription) Events() <-chan Event {
+	return sub.events
+}
+
+// Close removes the subscription from the event bus
+func (sub *Subscription) Close() {
+	sub.cd.Do(func() {
+		sub.bus.rm.Lock()
+		delete(sub.bus.subscribers, sub)
+		sub.bus.rm.Unlock()
+		close(sub.events)
+	})
+}
+
+// publishEvent publishes an event of the connected node
+func (rpcClient *RPCClient) publishEvent(typ string, data map[string]interface{}) {
+	if rpcClient.pool == nil {
+		return
+	}
+	rpcClient.pool.Events().Publish(Event{
+		Type: typ,
+		Node: rpcClient.Host(),
+		Data: data,
+	})
+}
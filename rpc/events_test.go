@@ -0,0 +1,59 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"testing"
+)
+
+func TestEventBusFilter(t *testing.T) {
+	bus := NewEventBus()
+	all := bus.Subscribe()
+	defer all.Close()
+	blocks := bus.Subscribe(EventNewBlock)
+	defer blocks.Close()
+
+	bus.Publish(Event{Type: EventNodeConnected})
+	bus.Publish(Event{Type: EventNewBlock})
+
+	for _, typ := range []string{EventNodeConnected, EventNewBlock} {
+		event := <-all.Events()
+		if event.Type != typ {
+			t.Fatalf("expected %s event but got %s", typ, event.Type)
+		}
+		if event.Time.IsZero() {
+			t.Fatalf("event time should be set")
+		}
+	}
+	event := <-blocks.Events()
+	if event.Type != EventNewBlock {
+		t.Fatalf("expected %s event but got %s", EventNewBlock, event.Type)
+	}
+	select {
+	case event := <-blocks.Events():
+		t.Fatalf("unexpected %s event", event.Type)
+	default:
+	}
+}
+
+func TestEventBusSlowSubscriber(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe()
+	for i := 0; i < eventBufferSize*2; i++ {
+		bus.Publish(Event{Type: EventNewBlock})
+	}
+	if len(sub.Events()) != eventBufferSize {
+		t.Fatalf("expected %d buffered events but got %d", eventBufferSize, len(sub.Events()))
+	}
+	sub.Close()
+	// publishing after close must not panic
+	bus.Publish(Event{Type: EventNewBlock})
+	count := 0
+	for range sub.Events() {
+		count++
+	}
+	if count != eventBufferSize {
+		t.Fatalf("expected %d events but got %d", eventBufferSize, count)
+	}
+}
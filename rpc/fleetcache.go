@@ -0,0 +1,138 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"sync/atomic"
+
+	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/contract"
+	"github.com/diodechain/diode_go_client/util"
+)
+
+// fleetMember is the key of a cached fleet allowlist lookup
+type fleetMember struct {
+	Fleet  Address
+	Device Address
+}
+
+// FleetCacheItem is the result of a fleet allowlist lookup that was verified
+// against the state of the validated block
+type FleetCacheItem struct {
+	Fleet       Address
+	Device      Address
+	Allowed     bool
+	BlockNumber uint64
+}
+
+// FleetCacheStats are the counters of the fleet allowlist cache
+type FleetCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Expired uint64
+	Entries int
+}
+
+// HitRate returns the share of lookups that were answered by the cache
+func (stats FleetCacheStats) HitRate() float64 {
+	total := stats.Hits + stats.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(stats.Hits) / float64(total)
+}
+
+// GetCacheFleetMember returns the cached allowlist lookup of the device in
+// the fleet. Lookups that are more than maxAge blocks older than the last
+// valid block are removed, so the allowlist is read again.
+func (p *DataPool) GetCacheFleetMember(fleet Address, device Address, lvbn uint64, maxAge uint64) (allowed bool, ok bool) {
+	key := fleetMember{Fleet: fleet, Device: device}
+	p.rm.RLock()
+	item, hit := p.fleetCache[key]
+	p.rm.RUnlock()
+	if hit && lvbn > item.BlockNumber && lvbn-item.BlockNumber > maxAge {
+		p.rm.Lock()
+		if current, ok := p.fleetCache[key]; ok && current.BlockNumber == item.BlockNumber {
+			delete(p.fleetCache, key)
+		}
+		p.rm.Unlock()
+		atomic.AddUint64(&p.fleetCacheExpired, 1)
+		hit = false
+	}
+	if !hit {
+		atomic.AddUint64(&p.fleetCacheMisses, 1)
+		return false, false
+	}
+	atomic.AddUint64(&p.fleetCacheHits, 1)
+	return item.Allowed, true
+}
+
+// SetCacheFleetMember caches the allowlist lookup of the device in the fleet
+// at the given block number
+func (p *DataPool) SetCacheFleetMember(fleet Address, device Address, blockNumber uint64, allowed bool) {
+	key := fleetMember{Fleet: fleet, Device: device}
+	p.rm.Lock()
+	defer p.rm.Unlock()
+	// keep the newer lookup of concurrent calls
+	if item, ok := p.fleetCache[key]; ok && item.BlockNumber > blockNumber {
+		return
+	}
+	p.fleetCache[key] = FleetCacheItem{
+		Fleet:       fleet,
+		Device:      device,
+		Allowed:     allowed,
+		BlockNumber: blockNumber,
+	}
+}
+
+// GetCacheFleetItems returns the cached fleet allowlist lookups
+func (p *DataPool) GetCacheFleetItems() []FleetCacheItem {
+	p.rm.RLock()
+	defer p.rm.RUnlock()
+	items := make([]FleetCacheItem, 0, len(p.fleetCache))
+	for _, item := range p.fleetCache {
+		items = append(items, item)
+	}
+	return items
+}
+
+// FleetCacheStats returns the hit, miss and expiry counters of the fleet
+// allowlist cache
+func (p *DataPool) FleetCacheStats() FleetCacheStats {
+	p.rm.RLock()
+	entries := len(p.fleetCache)
+	p.rm.RUnlock()
+	return FleetCacheStats{
+		Hits:    atomic.LoadUint64(&p.fleetCacheHits),
+		Misses:  atomic.LoadUint64(&p.fleetCacheMisses),
+		Expired: atomic.LoadUint64(&p.fleetCacheExpired),
+		Entries: entries,
+	}
+}
+
+// isFleetMember returns whether the device is on the allowlist of the fleet,
+// lookups are cached until the validated block is more than the configured
+// number of blocks ahead
+func (rpcClient *RPCClient) isFleetMember(fleet Address, device Address) (bool, error) {
+	if fleet == DefaultFleetAddr {
+		return true, nil
+	}
+	lvbn, _ := rpcClient.LastValid()
+	maxAge := config.AppConfig.AllowlistCacheAge
+	if maxAge > 0 {
+		if allowed, ok := rpcClient.pool.GetCacheFleetMember(fleet, device, lvbn, maxAge); ok {
+			return allowed, nil
+		}
+	}
+	key := contract.DeviceAllowlistKey(device)
+	raw, err := rpcClient.GetAccountValueRaw(lvbn, fleet, key)
+	if err != nil {
+		return false, err
+	}
+	allowed := util.BytesToInt(raw) == 1
+	if maxAge > 0 {
+		rpcClient.pool.SetCacheFleetMember(fleet, device, lvbn, allowed)
+	}
+	return allowed, nil
+}
@@ -0,0 +1,36 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"testing"
+)
+
+func TestFleetCache(t *testing.T) {
+	fleet := Address{1}
+	device := Address{2}
+	pool := NewPool()
+	if _, ok := pool.GetCacheFleetMember(fleet, device, 100, 10); ok {
+		t.Fatalf("empty cache shouldn't hit")
+	}
+	pool.SetCacheFleetMember(fleet, device, 100, true)
+	// an older lookup mustn't replace the newer one
+	pool.SetCacheFleetMember(fleet, device, 90, false)
+	if allowed, ok := pool.GetCacheFleetMember(fleet, device, 110, 10); !ok || !allowed {
+		t.Errorf("cached lookup should hit within the max age")
+	}
+	if _, ok := pool.GetCacheFleetMember(fleet, Address{3}, 110, 10); ok {
+		t.Errorf("other devices shouldn't hit")
+	}
+	if _, ok := pool.GetCacheFleetMember(fleet, device, 111, 10); ok {
+		t.Errorf("cached lookup should expire after the max age")
+	}
+	stats := pool.FleetCacheStats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Expired != 1 || stats.Entries != 0 {
+		t.Errorf("wrong cache stats %+v", stats)
+	}
+	if stats.HitRate() != 0.25 {
+		t.Errorf("wrong hit rate %v", stats.HitRate())
+	}
+}
@@ -0,0 +1,46 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"time"
+)
+
+// AccessGrant allows a device to connect to a private port from Start
+// until End
+type AccessGrant struct {
+	ID     string
+	Port   int
+	Device Address
+	Start  time.Time
+	End    time.Time
+}
+
+// Active returns true if the grant is valid at the given time
+func (grant *AccessGrant) Active(now time.Time) bool {
+	return !now.Before(grant.Start) && now.Before(grant.End)
+}
+
+// SetAccessGrants replaces the access grants, the given slice must not be
+// modified afterwards
+func (p *DataPool) SetAccessGrants(grants []AccessGrant) {
+	p.rm.Lock()
+	defer p.rm.Unlock()
+	p.grants = grants
+}
+
+// IsGranted returns true if an active grant allows the device to connect
+// to the port
+func (p *DataPool) IsGranted(port int, device Address) bool {
+	p.rm.RLock()
+	defer p.rm.RUnlock()
+	now := time.Now()
+	for i := range p.grants {
+		grant := &p.grants[i]
+		if grant.Port == port && grant.Device == device && grant.Active(now) {
+			return true
+		}
+	}
+	return false
+}
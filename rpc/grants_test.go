@@ -0,0 +1,33 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsGranted(t *testing.T) {
+	device := Address{1}
+	other := Address{2}
+	now := time.Now()
+	pool := NewPool()
+	pool.SetAccessGrants([]AccessGrant{
+		{ID: "active", Port: 22, Device: device, Start: now.Add(-time.Minute), End: now.Add(time.Hour)},
+		{ID: "pending", Port: 80, Device: device, Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)},
+		{ID: "expired", Port: 22, Device: other, Start: now.Add(-time.Hour), End: now.Add(-time.Minute)},
+	})
+	if !pool.IsGranted(22, device) {
+		t.Errorf("active grant should allow the device")
+	}
+	if pool.IsGranted(23, device) {
+		t.Errorf("grant shouldn't allow other ports")
+	}
+	if pool.IsGranted(80, device) {
+		t.Errorf("pending grant shouldn't allow the device")
+	}
+	if pool.IsGranted(22, other) {
+		t.Errorf("expired grant shouldn't allow the device")
+	}
+}
@@ -0,0 +1,164 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+)
+
+var (
+	errServiceUnavailable  = fmt.Errorf("service unavailable")
+	defaultMaintenancePage = []byte("<html><body><h1>Service unavailable</h1><p>The service is down for maintenance, please try again later.</p></body></html>")
+)
+
+// PortHealth is the result of the last health check of a published port
+type PortHealth struct {
+	Port      int
+	Healthy   bool
+	CheckedAt time.Time
+	Err       error
+}
+
+// CheckPortHealth connects to the service of the port, the http check
+// expects a 2xx or 3xx response of the health path
+func CheckPortHealth(port *config.Port, timeout time.Duration) error {
+	network, addr := port.SrcAddr(config.TCPProtocol)
+	if len(port.HealthPath) == 0 {
+		conn, err := net.DialTimeout(network, addr, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	host := addr
+	if network == "unix" {
+		host = localhost
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, addr)
+			},
+			DisableKeepAlives: true,
+		},
+		// a redirect is a healthy response
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(fmt.Sprintf("http://%s%s", host, port.HealthPath))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return fmt.Errorf("health check returned %s", res.Status)
+	}
+	return nil
+}
+
+// CheckHealth checks the published ports that have health checks and
+// returns the ports whose health changed
+func (p *DataPool) CheckHealth(timeout time.Duration) []PortHealth {
+	p.rm.RLock()
+	ports := make([]*config.Port, 0, len(p.publishedPorts))
+	for _, port := range p.publishedPorts {
+		if port.HealthCheck {
+			ports = append(ports, port)
+		}
+	}
+	p.rm.RUnlock()
+
+	results := make([]PortHealth, len(ports))
+	var wg sync.WaitGroup
+	for i, port := range ports {
+		wg.Add(1)
+		go func(i int, port *config.Port) {
+			defer wg.Done()
+			err := CheckPortHealth(port, timeout)
+			results[i] = PortHealth{
+				Port:      port.To,
+				Healthy:   err == nil,
+				CheckedAt: time.Now(),
+				Err:       err,
+			}
+		}(i, port)
+	}
+	wg.Wait()
+
+	changes := []PortHealth{}
+	p.rm.Lock()
+	health := make(map[int]PortHealth, len(results))
+	for _, result := range results {
+		old, ok := p.health[result.Port]
+		// ports are healthy until the first failed check
+		if (!ok && !result.Healthy) || (ok && old.Healthy != result.Healthy) {
+			changes = append(changes, result)
+		}
+		health[result.Port] = result
+	}
+	p.health = health
+	p.rm.Unlock()
+
+	for _, change := range changes {
+		data := map[string]interface{}{
+			"port":    change.Port,
+			"healthy": change.Healthy,
+		}
+		if change.Err != nil {
+			data["error"] = change.Err.Error()
+		}
+		p.events.Publish(Event{Type: EventPortHealth, Data: data})
+	}
+	return changes
+}
+
+// PortHealth returns the last health check of the published port
+func (p *DataPool) PortHealth(port int) (health PortHealth, ok bool) {
+	p.rm.RLock()
+	defer p.rm.RUnlock()
+	health, ok = p.health[port]
+	return
+}
+
+// GetPortHealth returns the last health checks of the published ports
+func (p *DataPool) GetPortHealth() []PortHealth {
+	p.rm.RLock()
+	defer p.rm.RUnlock()
+	ret := make([]PortHealth, 0, len(p.health))
+	for _, health := range p.health {
+		ret = append(ret, health)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Port < ret[j].Port
+	})
+	return ret
+}
+
+// newMaintenanceConn returns a connection that answers http requests with
+// the maintenance page of the port
+func (rpcClient *RPCClient) newMaintenanceConn(port *config.Port) net.Conn {
+	return serveHTTPConn(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		page, err := ioutil.ReadFile(port.MaintenancePage)
+		if err != nil {
+			rpcClient.Error("Failed to read maintenance page: %v", err)
+			page = defaultMaintenancePage
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(page)
+	}))
+}
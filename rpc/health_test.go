@@ -0,0 +1,78 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+)
+
+func testServicePort(t *testing.T, addr string, to int, path string) *config.Port {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, _ := strconv.Atoi(portStr)
+	return &config.Port{Src: src, SrcHost: host, To: to, HealthCheck: true, HealthPath: path}
+}
+
+func TestCheckPortHealth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	addr := backend.Listener.Addr().String()
+	if err := CheckPortHealth(testServicePort(t, addr, 80, ""), time.Second); err != nil {
+		t.Errorf("tcp check should succeed: %v", err)
+	}
+	if err := CheckPortHealth(testServicePort(t, addr, 80, "/ok"), time.Second); err != nil {
+		t.Errorf("http check should succeed: %v", err)
+	}
+	if err := CheckPortHealth(testServicePort(t, addr, 80, "/fail"), time.Second); err == nil {
+		t.Errorf("http check should fail on status 500")
+	}
+	backend.Close()
+	if err := CheckPortHealth(testServicePort(t, addr, 80, ""), time.Second); err == nil {
+		t.Errorf("tcp check should fail on closed service")
+	}
+}
+
+func TestCheckHealthChanges(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	pool := NewPool()
+	pool.SetPublishedPorts(map[int]*config.Port{
+		80: testServicePort(t, backend.Listener.Addr().String(), 80, "/"),
+	})
+	sub := pool.Events().Subscribe(EventPortHealth)
+	defer sub.Close()
+
+	if changes := pool.CheckHealth(time.Second); len(changes) != 0 {
+		t.Errorf("healthy ports shouldn't be reported as changed")
+	}
+	backend.Close()
+	changes := pool.CheckHealth(time.Second)
+	if len(changes) != 1 || changes[0].Healthy {
+		t.Fatalf("port should become unhealthy: %+v", changes)
+	}
+	if health, ok := pool.PortHealth(80); !ok || health.Healthy {
+		t.Errorf("port health should be unhealthy")
+	}
+	select {
+	case event := <-sub.Events():
+		if event.Data["healthy"] != false {
+			t.Errorf("wrong event data %+v", event.Data)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("port health event should be published")
+	}
+}
@@ -0,0 +1,220 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/diodechain/diode_go_client/config"
+)
+
+var (
+	httpProxyEstablished = []byte("HTTP/1.1 200 Connection established\r\n\r\n")
+)
+
+// writeHTTPProxyError writes a plain text error response to the proxy client
+func writeHTTPProxyError(conn net.Conn, code int, msg string, header ...string) {
+	if len(msg) == 0 {
+		msg = http.StatusText(code)
+	}
+	res := fmt.Sprintf("HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	for _, h := range header {
+		res += h + "\r\n"
+	}
+	res += fmt.Sprintf("Content-Type: text/plain; charset=utf-8\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(msg)+1, msg)
+	conn.Write([]byte(res + "\n"))
+}
+
+// authenticateHTTPProxy returns the user of the basic Proxy-Authorization
+// header, the header is only required when socks users are configured
+func (socksServer *Server) authenticateHTTPProxy(req *http.Request) (user *config.SocksUser, ok bool) {
+	users := socksServer.Config().Users
+	if len(users) == 0 {
+		return nil, true
+	}
+	auth := req.Header.Get("Proxy-Authorization")
+	if !strings.HasPrefix(auth, "Basic ") {
+		return nil, false
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
+	if err != nil {
+		return nil, false
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	user, ok = users[parts[0]]
+	if !ok || !CheckSocksPassword(user, parts[1]) {
+		return nil, false
+	}
+	return user, true
+}
+
+// httpProxyTarget returns the host:port of the proxy request and for
+// absolute-URI requests the request rewritten to origin form
+func httpProxyTarget(req *http.Request) (host string, prefix []byte, err error) {
+	if req.Method == http.MethodConnect {
+		host = req.Host
+		if _, _, err = net.SplitHostPort(host); err != nil {
+			err = fmt.Errorf("CONNECT target should be host:port: %v", host)
+		}
+		return
+	}
+	if !req.URL.IsAbs() || req.URL.Scheme != "http" {
+		err = fmt.Errorf("not a proxy request: %v", req.URL.String())
+		return
+	}
+	host = req.URL.Host
+	if _, _, splitErr := net.SplitHostPort(host); splitErr != nil {
+		host = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	req.Header.Del("Proxy-Authorization")
+	req.Header.Del("Proxy-Connection")
+	// following requests might be for other hosts, so the client has to
+	// open a new connection for them
+	req.Close = true
+	buf := bytes.NewBuffer([]byte{})
+	if err = req.Write(buf); err != nil {
+		return
+	}
+	prefix = buf.Bytes()
+	return
+}
+
+// handleHTTPProxyConnection serves a http forward proxy request. CONNECT
+// requests are tunneled, absolute-URI requests are forwarded in origin form.
+// Diode hosts are connected like socks requests, other hosts follow the
+// configured fallback.
+func (socksServer *Server) handleHTTPProxyConnection(conn net.Conn) {
+	defer conn.Close()
+	defer socksServer.wg.Done()
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		socksServer.logger.Debug("Failed to read http proxy request: %v", err)
+		return
+	}
+	user, ok := socksServer.authenticateHTTPProxy(req)
+	if !ok {
+		writeHTTPProxyError(conn, http.StatusProxyAuthRequired, "", `Proxy-Authenticate: Basic realm="diode"`)
+		return
+	}
+	host, prefix, err := httpProxyTarget(req)
+	if err != nil {
+		writeHTTPProxyError(conn, http.StatusBadRequest, err.Error())
+		return
+	}
+	host = socksServer.diodeHost(host)
+	isConnect := req.Method == http.MethodConnect
+	// the client may send data before the CONNECT reply, e.g. the tls hello
+	if reader.Buffered() > 0 {
+		rest := make([]byte, reader.Buffered())
+		reader.Read(rest)
+		prefix = append(prefix, rest...)
+	}
+	clientConn := NewHTTPConn(prefix, conn)
+
+	route, err := socksServer.route(host)
+	if err != nil {
+		writeHTTPProxyError(conn, http.StatusBadRequest, err.Error())
+		return
+	}
+	if route.Action == RouteReject {
+		socksServer.logger.Error("Target %v rejected by route %s", host, route.Name)
+		writeHTTPProxyError(conn, http.StatusForbidden, "Target rejected by route "+route.Name)
+		return
+	}
+	if route.Action != RouteDiode {
+		remoteConn, err := route.Dial("tcp", host)
+		if err != nil {
+			socksServer.logger.Error("Failed to connect host %v (route %s): %v", host, route.Name, err)
+			writeHTTPProxyError(conn, http.StatusBadGateway, err.Error())
+			return
+		}
+		defer remoteConn.Close()
+		if isConnect {
+			conn.Write(httpProxyEstablished)
+		}
+		tunnel := NewTunnel(clientConn, remoteConn, defaultIdleTimeout, sslBufferSize)
+		tunnel.Copy()
+		return
+	}
+	host = route.Target(host)
+	if !isDiodeHost(host) {
+		socksServer.logger.Error("Target not a diode host %v", host)
+		writeHTTPProxyError(conn, http.StatusForbidden, "Target not a diode host")
+		return
+	}
+
+	isWS, mode, deviceID, port, err := parseHost(host)
+	if err != nil {
+		writeHTTPProxyError(conn, http.StatusBadRequest, err.Error())
+		return
+	}
+	if isWS {
+		writeHTTPProxyError(conn, http.StatusForbidden, "Websocket hosts are served by the gateway")
+		return
+	}
+	if err = socksServer.checkUserAccess(user, deviceID); err != nil {
+		socksServer.logger.Error("Failed to checkUserAccess %v", err)
+		writeHTTPProxyError(conn, http.StatusForbidden, "Access device forbidden")
+		return
+	}
+	protocol := config.TCPProtocol
+	if config.AppConfig.EnableEdgeE2E {
+		protocol = config.TLSProtocol
+	}
+	err = socksServer.connectDeviceAndLoop(deviceID, port, protocol, mode, defaultIdleTimeout, func(*ConnectedDevice) (*DeviceConn, error) {
+		if isConnect {
+			conn.Write(httpProxyEstablished)
+		}
+		return &DeviceConn{
+			Conn:       clientConn,
+			bufferSize: sslBufferSize,
+			closeCh:    make(chan struct{}),
+		}, nil
+	})
+	if err != nil {
+		socksServer.logger.Error("Failed to connectDevice(%v): %v", deviceID, err.Error())
+		if httpErr, ok := err.(HttpError); ok {
+			writeHTTPProxyError(conn, httpErr.code, deviceErrorMessage(httpErr))
+		} else {
+			writeHTTPProxyError(conn, http.StatusBadGateway, err.Error())
+		}
+	}
+}
+
+// startHTTPProxy starts the http forward proxy listener
+func (socksServer *Server) startHTTPProxy() error {
+	addr := socksServer.Config().HTTPProxyAddr
+	socksServer.logger.Info("Start http proxy server %s", addr)
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	socksServer.httpListener = tcp
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				// Accept will return op close error/syscall.EINVAL
+				if !isOpError(err) {
+					socksServer.logger.Error(err.Error())
+				}
+				break
+			}
+			socksServer.logger.Debug("New http proxy client: %s", conn.RemoteAddr().String())
+			socksServer.wg.Add(1)
+			go socksServer.handleHTTPProxyConnection(conn)
+		}
+	}()
+	return nil
+}
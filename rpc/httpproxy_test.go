@@ -0,0 +1,123 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+)
+
+func startTestHTTPProxy(t *testing.T, cfg *Config) *Server {
+	config.AppConfig = testConfig()
+	socksServer := NewSocksServer(nil)
+	cfg.Addr = "127.0.0.1:0"
+	cfg.HTTPProxyAddr = "127.0.0.1:0"
+	socksServer.SetConfig(cfg)
+	if err := socksServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return socksServer
+}
+
+func TestHTTPProxyFallback(t *testing.T) {
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", req.Method, req.RequestURI, req.Header.Get("Proxy-Connection"))
+	}))
+	defer web.Close()
+	socksServer := startTestHTTPProxy(t, &Config{Fallback: "localhost"})
+	defer socksServer.Close()
+	proxyURL, _ := url.Parse("http://" + socksServer.httpListener.Addr().String())
+
+	// absolute-URI requests are forwarded in origin form
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	res, err := client.Get(web.URL + "/hello?a=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "GET /hello?a=b " {
+		t.Errorf("wrong forwarded request %s", body)
+	}
+
+	// CONNECT requests are tunneled
+	conn, err := net.Dial("tcp", proxyURL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	host := web.Listener.Addr().String()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\nGET /tunnel HTTP/1.1\r\nHost: %s\r\n\r\n", host, host, host)
+	reader := bufio.NewReader(conn)
+	res, err = http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT should be established but got %d", res.StatusCode)
+	}
+	res, err = http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	if string(body) != "GET /tunnel " {
+		t.Errorf("wrong tunneled request %s", body)
+	}
+}
+
+func testHTTPProxyStatus(t *testing.T, socksServer *Server, request string) int {
+	conn, err := net.Dial("tcp", socksServer.httpListener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte(request))
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode
+}
+
+func TestHTTPProxyErrors(t *testing.T) {
+	users := map[string]*config.SocksUser{
+		"alice": {Name: "alice", Password: "secret"},
+	}
+	socksServer := startTestHTTPProxy(t, &Config{Fallback: "remote", Users: users})
+	defer socksServer.Close()
+
+	status := testHTTPProxyStatus(t, socksServer, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	if status != http.StatusProxyAuthRequired {
+		t.Errorf("missing credentials should be rejected but got %d", status)
+	}
+	// alice:wrong
+	status = testHTTPProxyStatus(t, socksServer, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nProxy-Authorization: Basic YWxpY2U6d3Jvbmc=\r\n\r\n")
+	if status != http.StatusProxyAuthRequired {
+		t.Errorf("wrong password should be rejected but got %d", status)
+	}
+	// alice:secret
+	status = testHTTPProxyStatus(t, socksServer, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nProxy-Authorization: Basic YWxpY2U6c2VjcmV0\r\n\r\n")
+	if status != http.StatusForbidden {
+		t.Errorf("web2 host without fallback should be forbidden but got %d", status)
+	}
+	status = testHTTPProxyStatus(t, socksServer, "GET /index.html HTTP/1.1\r\nHost: example.com\r\nProxy-Authorization: Basic YWxpY2U6c2VjcmV0\r\n\r\n")
+	if status != http.StatusBadRequest {
+		t.Errorf("origin form request should be rejected but got %d", status)
+	}
+}
@@ -0,0 +1,123 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var pacHostPattern = regexp.MustCompile(`^[A-Za-z0-9.-]+$`)
+
+// PACFile returns the proxy auto-config file that routes diode hosts and
+// the bns names through the socks address
+func PACFile(socksAddr string, names []string) string {
+	validNames := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if subDomainpattern.MatchString(name) {
+			validNames = append(validNames, name)
+		}
+	}
+	buf := bytes.NewBuffer([]byte{})
+	buf.WriteString("// Diode Network Client\n")
+	buf.WriteString("// Generated by diode socksd, routes diode hosts through the socks server\n")
+	buf.WriteString("function FindProxyForURL(url, host)\n{\n")
+	buf.WriteString("  host = host.toLowerCase();\n")
+	buf.WriteString("  if (dnsDomainIs(host, \".diode\") ||\n")
+	buf.WriteString("      dnsDomainIs(host, \".diode.ws\") ||\n")
+	buf.WriteString("      dnsDomainIs(host, \".diode.link\")")
+	for _, name := range validNames {
+		fmt.Fprintf(buf, " ||\n      host == %q", name)
+	}
+	buf.WriteString(") {\n")
+	fmt.Fprintf(buf, "    return \"SOCKS5 %s; SOCKS %s\";\n", socksAddr, socksAddr)
+	buf.WriteString("  }\n  return \"DIRECT\";\n}\n")
+	return buf.String()
+}
+
+// diodeHost returns the .diode host of configured bns names, other hosts are
+// returned as they are
+func (socksServer *Server) diodeHost(host string) string {
+	return DiodeHost(host, socksServer.Config().BNSNames)
+}
+
+// DiodeHost returns the .diode host when the host is one of the bns names,
+// other hosts are returned as they are
+func DiodeHost(host string, bnsNames []string) string {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+	for _, bnsName := range bnsNames {
+		if strings.EqualFold(name, bnsName) {
+			return net.JoinHostPort(strings.ToLower(name)+".diode", port)
+		}
+	}
+	return host
+}
+
+// pacSocksAddr returns the socks address that the browser can connect to,
+// the host of the request is used when the socks server listens on all
+// interfaces
+func pacSocksAddr(addr *net.TCPAddr, req *http.Request) string {
+	host := addr.IP.String()
+	if addr.IP.IsUnspecified() {
+		host = req.Host
+		if h, _, err := net.SplitHostPort(req.Host); err == nil {
+			host = h
+		}
+		// the host is written into the script
+		if net.ParseIP(host) == nil && !pacHostPattern.MatchString(host) {
+			host = localhost
+		}
+	}
+	return net.JoinHostPort(host, strconv.Itoa(addr.Port))
+}
+
+// servePAC serves the proxy auto-config file of the socks server that
+// listens on socksAddr
+func (socksServer *Server) servePAC(w http.ResponseWriter, req *http.Request, socksAddr *net.TCPAddr) {
+	if req.URL.Path != "/" && req.URL.Path != "/proxy.pac" {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != "GET" && req.Method != "HEAD" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write([]byte(PACFile(pacSocksAddr(socksAddr, req), socksServer.Config().BNSNames)))
+}
+
+// startPAC starts the http server of the proxy auto-config file
+func (socksServer *Server) startPAC() error {
+	addr := socksServer.Config().PACAddr
+	socksServer.logger.Info("Start pac server http://%s/proxy.pac", addr)
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	// Close resets the listener, the handler keeps the address
+	socksAddr := socksServer.listener.Addr().(*net.TCPAddr)
+	pacServer := &http.Server{
+		Addr: tcp.Addr().String(),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			socksServer.servePAC(w, req, socksAddr)
+		}),
+	}
+	socksServer.pacServer = pacServer
+	go func() {
+		if err := pacServer.Serve(tcp); err != nil && err != http.ErrServerClosed {
+			socksServer.logger.Error("Couldn't start pac server: %v", err)
+		}
+	}()
+	return nil
+}
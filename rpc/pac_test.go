@@ -0,0 +1,90 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+)
+
+func TestPACFile(t *testing.T) {
+	pac := PACFile("127.0.0.1:1080", []string{"MyName", "bad\");alert(\"", "short", "othername"})
+	if !strings.Contains(pac, `return "SOCKS5 127.0.0.1:1080; SOCKS 127.0.0.1:1080";`) {
+		t.Errorf("pac should use the socks address:\n%s", pac)
+	}
+	for _, want := range []string{`".diode"`, `".diode.ws"`, `".diode.link"`, `host == "myname"`, `host == "othername"`, `return "DIRECT";`} {
+		if !strings.Contains(pac, want) {
+			t.Errorf("pac should contain %s:\n%s", want, pac)
+		}
+	}
+	if strings.Contains(pac, "alert") || strings.Contains(pac, `"short"`) {
+		t.Errorf("pac should skip invalid names:\n%s", pac)
+	}
+}
+
+func TestDiodeHost(t *testing.T) {
+	socksServer := &Server{}
+	socksServer.SetConfig(&Config{BNSNames: []string{"myname"}})
+	if host := socksServer.diodeHost("MyName:80"); host != "myname.diode:80" {
+		t.Errorf("bns name should be a diode host but got %s", host)
+	}
+	if host := socksServer.diodeHost("example.com:80"); host != "example.com:80" {
+		t.Errorf("other host should be kept but got %s", host)
+	}
+}
+
+func TestServePAC(t *testing.T) {
+	config.AppConfig = testConfig()
+	socksServer := NewSocksServer(nil)
+	socksServer.SetConfig(&Config{Addr: "127.0.0.1:0", PACAddr: "127.0.0.1:0", BNSNames: []string{"myname"}})
+	if err := socksServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer socksServer.Close()
+	client := &http.Client{Timeout: 5 * time.Second}
+	pacAddr := socksServer.pacServer.Addr
+	res, err := client.Get("http://" + pacAddr + "/proxy.pac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.Header.Get("Content-Type") != "application/x-ns-proxy-autoconfig" {
+		t.Errorf("wrong content type %s", res.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "SOCKS5 "+socksServer.listener.Addr().String()+";") || !strings.Contains(string(body), `"myname"`) {
+		t.Errorf("wrong pac file:\n%s", body)
+	}
+	res, err = client.Get("http://" + pacAddr + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("other paths should not be found but got %d", res.StatusCode)
+	}
+}
+
+func TestPACSocksAddr(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4zero, Port: 1080}
+	req := httptest.NewRequest("GET", "http://192.168.1.2:8080/proxy.pac", nil)
+	if socksAddr := pacSocksAddr(addr, req); socksAddr != "192.168.1.2:1080" {
+		t.Errorf("host of the request should be used but got %s", socksAddr)
+	}
+	req.Host = `x");alert(1);("`
+	if socksAddr := pacSocksAddr(addr, req); socksAddr != "localhost:1080" {
+		t.Errorf("invalid host should be replaced but got %s", socksAddr)
+	}
+	addr.IP = net.IPv4(127, 0, 0, 1)
+	if socksAddr := pacSocksAddr(addr, req); socksAddr != "127.0.0.1:1080" {
+		t.Errorf("listen address should be used but got %s", socksAddr)
+	}
+}
@@ -156,30 +156,35 @@ func (proxyServer *ProxyServer) pipeProxy(w http.ResponseWriter, r *http.Request
 
 	if err != nil {
 		if httpErr, ok := err.(HttpError); ok {
-			var errMsg string
-			switch httpErr.code {
-			case 400:
-				errMsg = fmt.Sprintf("Bad request: %s", httpErr.Error())
-			case 404:
-				// why not err == ErrEmptyBNSresult
-				if err.Error() == ErrEmptyBNSresult.Error() {
-					errMsg = "BNS name not found. Please check spelling."
-				} else if _, ok := httpErr.err.(DeviceError); ok {
-					errMsg = "Device is currently offline."
-				} else {
-					errMsg = "BNS entry does not exist. Please check spelling."
-				}
-			case 403:
-				errMsg = "Access device forbidden"
-			case 500:
-				errMsg = fmt.Sprintf("Internal server error: %s", httpErr.Error())
-			}
-			httpError(w, httpErr.code, errMsg)
+			httpError(w, httpErr.code, deviceErrorMessage(httpErr))
 			return
 		}
 	}
 }
 
+// deviceErrorMessage returns the user facing message of a failed device
+// connection
+func deviceErrorMessage(httpErr HttpError) (errMsg string) {
+	switch httpErr.code {
+	case 400:
+		errMsg = fmt.Sprintf("Bad request: %s", httpErr.Error())
+	case 404:
+		// why not err == ErrEmptyBNSresult
+		if httpErr.Error() == ErrEmptyBNSresult.Error() {
+			errMsg = "BNS name not found. Please check spelling."
+		} else if _, ok := httpErr.err.(DeviceError); ok {
+			errMsg = "Device is currently offline."
+		} else {
+			errMsg = "BNS entry does not exist. Please check spelling."
+		}
+	case 403:
+		errMsg = "Access device forbidden"
+	case 500:
+		errMsg = fmt.Sprintf("Internal server error: %s", httpErr.Error())
+	}
+	return
+}
+
 func NewProxyServer(socksServer *Server) *ProxyServer {
 	proxyServer := &ProxyServer{
 		socksServer: socksServer,
@@ -208,7 +213,7 @@ func (proxyServer *ProxyServer) Start() error {
 		return nil
 	}
 	proxyServer.logger.Info("Start httpd server %s", proxyServer.Config.ProxyServerAddr)
-	prox, _ := url.Parse(fmt.Sprintf("socks5://%s", proxyServer.socksServer.Config.Addr))
+	prox, _ := url.Parse(fmt.Sprintf("socks5://%s", proxyServer.socksServer.Config().Addr))
 	proxyTransport.Proxy = http.ProxyURL(prox)
 	httpdHandler := http.HandlerFunc(proxyServer.pipeProxy)
 	if proxyServer.Config.AllowRedirect {
@@ -0,0 +1,47 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// ProxyProtocolDeviceTLV is the PROXY protocol v2 TLV type of the hex
+	// encoded device address, it's in the range reserved for custom types
+	ProxyProtocolDeviceTLV = 0xE0
+
+	// version 2 and PROXY command
+	proxyProtocolVersionCommand = 0x21
+	// TCP over IPv4, receivers ignore the TLVs of unspecified addresses so
+	// the device gets zero addresses
+	proxyProtocolTCP4 = 0x11
+	// source and destination address and port of TCP over IPv4
+	proxyProtocolTCP4Len = 12
+)
+
+var (
+	proxyProtocolSignature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+)
+
+// proxyProtocolHeader returns a PROXY protocol v2 header that carries the
+// device address in a TLV
+func proxyProtocolHeader(deviceID Address) []byte {
+	device := []byte(deviceID.HexString())
+	length := proxyProtocolTCP4Len + 3 + len(device)
+	header := make([]byte, 0, len(proxyProtocolSignature)+4+length)
+	header = append(header, proxyProtocolSignature...)
+	header = append(header, proxyProtocolVersionCommand, proxyProtocolTCP4)
+	header = appendUint16(header, uint16(length))
+	header = append(header, make([]byte, proxyProtocolTCP4Len)...)
+	header = append(header, ProxyProtocolDeviceTLV)
+	header = appendUint16(header, uint16(len(device)))
+	return append(header, device...)
+}
+
+func appendUint16(buf []byte, n uint16) []byte {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], n)
+	return append(buf, b[:]...)
+}
@@ -0,0 +1,103 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// proxyProtocolV2 is a header decoded like receivers do, the TLVs of
+// unspecified addresses are ignored
+type proxyProtocolV2 struct {
+	command byte
+	family  byte
+	addrs   []byte
+	tlvs    map[byte][]byte
+}
+
+// readProxyProtocolV2 decodes the header strictly following section 2.2 of
+// the PROXY protocol specification
+func readProxyProtocolV2(reader *bufio.Reader) (header proxyProtocolV2, err error) {
+	buf := make([]byte, 16)
+	if _, err = io.ReadFull(reader, buf); err != nil {
+		return
+	}
+	if !bytes.Equal(buf[:12], proxyProtocolSignature) {
+		return header, fmt.Errorf("wrong signature %x", buf[:12])
+	}
+	if buf[12]>>4 != 2 {
+		return header, fmt.Errorf("wrong version %d", buf[12]>>4)
+	}
+	header.command = buf[12] & 0x0F
+	if header.command > 1 {
+		return header, fmt.Errorf("unknown command %d", header.command)
+	}
+	header.family = buf[13]
+	var addrLen int
+	switch header.family {
+	case 0x00:
+	case 0x11, 0x12:
+		addrLen = 12
+	case 0x21, 0x22:
+		addrLen = 36
+	case 0x31, 0x32:
+		addrLen = 216
+	default:
+		return header, fmt.Errorf("unknown family %x", header.family)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(buf[14:16]))
+	if _, err = io.ReadFull(reader, payload); err != nil {
+		return
+	}
+	if len(payload) < addrLen {
+		return header, fmt.Errorf("addresses are too short %d < %d", len(payload), addrLen)
+	}
+	header.addrs = payload[:addrLen]
+	header.tlvs = make(map[byte][]byte)
+	// LOCAL and AF_UNSPEC headers only carry the length
+	if header.command == 0 || header.family == 0x00 {
+		return header, nil
+	}
+	tlvs := payload[addrLen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return header, fmt.Errorf("truncated tlv header")
+		}
+		length := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+length {
+			return header, fmt.Errorf("truncated tlv %x", tlvs[0])
+		}
+		header.tlvs[tlvs[0]] = tlvs[3 : 3+length]
+		tlvs = tlvs[3+length:]
+	}
+	return header, nil
+}
+
+func TestProxyProtocolHeader(t *testing.T) {
+	deviceID := Address{0x12, 0x34}
+	data := append(proxyProtocolHeader(deviceID), "SSH-2.0"...)
+	reader := bufio.NewReader(bytes.NewReader(data))
+	header, err := readProxyProtocolV2(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.command != 1 || header.family != 0x11 {
+		t.Errorf("header should be a PROXY command of tcp over ipv4 but is %x %x", header.command, header.family)
+	}
+	if !bytes.Equal(header.addrs, make([]byte, 12)) {
+		t.Errorf("addresses should be zero %x", header.addrs)
+	}
+	if device := string(header.tlvs[ProxyProtocolDeviceTLV]); device != deviceID.HexString() {
+		t.Errorf("wrong device address %s", device)
+	}
+	if rest, _ := ioutil.ReadAll(reader); string(rest) != "SSH-2.0" {
+		t.Errorf("connection data should follow the header but got %q", rest)
+	}
+}
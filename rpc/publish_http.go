@@ -0,0 +1,209 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+)
+
+const (
+	// DiodeHeaderPrefix is the prefix of the identity headers that are set
+	// for http published ports, client supplied copies are removed
+	DiodeHeaderPrefix = "X-Diode-"
+	// DiodeDeviceHeader is the verified address of the calling device
+	DiodeDeviceHeader = DiodeHeaderPrefix + "Device"
+	// DiodeFleetHeader is the fleet of the calling device
+	DiodeFleetHeader = DiodeHeaderPrefix + "Fleet"
+)
+
+var (
+	errListenerClosed = fmt.Errorf("listener was closed")
+)
+
+// identityContextKey is the context key of the device of requests that were
+// served in process
+type identityContextKey struct{}
+
+// connListener is a net.Listener that accepts a single connection and is
+// closed when that connection is done
+type connListener struct {
+	conn    net.Conn
+	connCh  chan net.Conn
+	closeCh chan struct{}
+	cd      sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	connCh := make(chan net.Conn, 1)
+	connCh <- conn
+	return &connListener{
+		conn:    conn,
+		connCh:  connCh,
+		closeCh: make(chan struct{}),
+	}
+}
+
+// Accept returns the connection once and then waits for the listener to close
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, errListenerClosed
+	}
+}
+
+// Close the listener
+func (l *connListener) Close() error {
+	l.cd.Do(func() {
+		close(l.closeCh)
+	})
+	return nil
+}
+
+// Addr returns the local address of the connection
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// deviceFleet returns the fleet of the validated device ticket
+func (rpcClient *RPCClient) deviceFleet(deviceID Address) (fleet Address, err error) {
+	tck := rpcClient.pool.GetCacheDevice(deviceID)
+	if tck == nil {
+		tck, err = rpcClient.GetObject(deviceID)
+		if err != nil {
+			return
+		}
+		if tck == nil {
+			err = fmt.Errorf("device object not found")
+			return
+		}
+		if tck.Err != nil {
+			err = tck.Err
+			return
+		}
+		if !tck.ValidateDeviceSig(deviceID) {
+			err = fmt.Errorf("wrong device signature in device object")
+			return
+		}
+		if !tck.ValidateServerSig() {
+			err = fmt.Errorf("wrong server signature in device object")
+			return
+		}
+		rpcClient.pool.SetCacheDevice(deviceID, tck)
+	}
+	fleet = tck.FleetAddr
+	return
+}
+
+// setIdentityHeaders replaces the client supplied identity headers with the
+// given ones, underscores are compared as dashes because cgi and some
+// frameworks read X-Diode_Device as X-Diode-Device
+func setIdentityHeaders(header http.Header, identity http.Header) {
+	prefix := strings.ToLower(DiodeHeaderPrefix)
+	for key := range header {
+		if strings.HasPrefix(strings.ToLower(strings.ReplaceAll(key, "_", "-")), prefix) {
+			delete(header, key)
+		}
+	}
+	for key, values := range identity {
+		header[key] = values
+	}
+}
+
+// newIdentityHandler returns a handler that serves the requests of the device
+// in process, the device is kept in the request context because only
+// requests of the bridge reach the handler this way
+func newIdentityHandler(handler http.Handler, deviceID Address, identity http.Header) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		setIdentityHeaders(req.Header, identity)
+		ctx := context.WithValue(req.Context(), identityContextKey{}, deviceID)
+		handler.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// RequestDevice returns the device of requests that were served in process
+// through the bridge, the identity headers of other requests can be forged
+func RequestDevice(req *http.Request) (deviceID Address, ok bool) {
+	deviceID, ok = req.Context().Value(identityContextKey{}).(Address)
+	return
+}
+
+// newHTTPPortProxy returns a reverse proxy to the published service of the
+// port that replaces the client supplied identity headers with the given ones
+func newHTTPPortProxy(port *config.Port, identity http.Header, timeout time.Duration) *httputil.ReverseProxy {
+	network, addr := port.SrcAddr(config.TCPProtocol)
+	host := addr
+	if network == "unix" {
+		host = localhost
+	}
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = "http"
+			req.URL.Host = host
+			setIdentityHeaders(req.Header, identity)
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				dialer := net.Dialer{Timeout: timeout}
+				return dialer.DialContext(ctx, network, addr)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+// serveHTTPConn returns a connection whose http requests are served by the
+// handler
+func serveHTTPConn(handler http.Handler) net.Conn {
+	local, remote := net.Pipe()
+	listener := newConnListener(remote)
+	server := &http.Server{
+		Handler: handler,
+		ConnState: func(conn net.Conn, state http.ConnState) {
+			if state == http.StateClosed || state == http.StateHijacked {
+				listener.Close()
+			}
+		},
+	}
+	go func() {
+		server.Serve(listener)
+		remote.Close()
+	}()
+	return local
+}
+
+// newHTTPPortConn returns a connection that terminates http requests of the
+// device and forwards them to the published service with the identity
+// headers of the device, local handlers of the port are served in process
+func (rpcClient *RPCClient) newHTTPPortConn(port *config.Port, deviceID Address) net.Conn {
+	identity := http.Header{}
+	identity.Set(DiodeDeviceHeader, deviceID.HexString())
+	if fleet, err := rpcClient.deviceFleet(deviceID); err == nil {
+		identity.Set(DiodeFleetHeader, fleet.HexString())
+	} else {
+		rpcClient.Debug("Couldn't find fleet of device %s: %v", deviceID.HexString(), err)
+	}
+	if len(port.SrcHost) == 0 && len(port.SrcSocket) == 0 {
+		if handler := rpcClient.pool.GetLocalHandler(port.Src); handler != nil {
+			return serveHTTPConn(newIdentityHandler(handler, deviceID, identity))
+		}
+	}
+	proxy := newHTTPPortProxy(port, identity, rpcClient.localTimeout)
+	_, addr := port.SrcAddr(config.TCPProtocol)
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		rpcClient.Error("Failed to proxy http request to %s: %v", addr, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	return serveHTTPConn(proxy)
+}
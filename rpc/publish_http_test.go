@@ -0,0 +1,118 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+)
+
+func TestHTTPPortProxyHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Device", req.Header.Get(DiodeDeviceHeader))
+		w.Header().Set("Fleet", req.Header.Get(DiodeFleetHeader))
+		w.Header().Set("Other", req.Header.Get(DiodeHeaderPrefix+"Other"))
+		w.Header().Set("Underscore", strings.Join(req.Header["X-Diode_device"], ""))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer backend.Close()
+	host, portStr, err := net.SplitHostPort(backend.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, _ := strconv.Atoi(portStr)
+	port := &config.Port{Src: src, SrcHost: host, To: 80, Protocol: config.HTTPProtocol}
+	identity := http.Header{}
+	identity.Set(DiodeDeviceHeader, "0x01")
+
+	conn := serveHTTPConn(newHTTPPortProxy(port, identity, time.Second))
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req, _ := http.NewRequest("GET", "http://device.diode.link/", nil)
+	req.Header.Set(DiodeDeviceHeader, "0x02")
+	req.Header.Set(DiodeFleetHeader, "0x03")
+	req.Header.Set(DiodeHeaderPrefix+"Other", "spoofed")
+	req.Header["X-Diode_device"] = []string{"0x04"}
+	go req.Write(conn)
+	res, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("wrong status code %d", res.StatusCode)
+	}
+	if res.Header.Get("Device") != "0x01" {
+		t.Errorf("device header should be replaced but is %s", res.Header.Get("Device"))
+	}
+	if res.Header.Get("Fleet") != "" || res.Header.Get("Other") != "" || res.Header.Get("Underscore") != "" {
+		t.Errorf("client supplied headers should be removed")
+	}
+}
+
+func TestSetIdentityHeaders(t *testing.T) {
+	header := http.Header{
+		"X-Diode-Device":  {"0x02"},
+		"X-Diode_device":  {"0x02"},
+		"X_diode_fleet":   {"0x03"},
+		"x-diode-other":   {"spoofed"},
+		"X-Diodes":        {"kept"},
+		"X-Forwarded-For": {"kept"},
+	}
+	identity := http.Header{}
+	identity.Set(DiodeDeviceHeader, "0x01")
+	setIdentityHeaders(header, identity)
+	want := http.Header{
+		"X-Diode-Device":  {"0x01"},
+		"X-Diodes":        {"kept"},
+		"X-Forwarded-For": {"kept"},
+	}
+	if !reflect.DeepEqual(header, want) {
+		t.Errorf("wrong headers %v", header)
+	}
+}
+
+func TestIdentityHandler(t *testing.T) {
+	device := Address{1}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if deviceID, ok := RequestDevice(req); ok {
+			w.Header().Set("Trusted", deviceID.HexString())
+		}
+		w.Header().Set("Device", req.Header.Get(DiodeDeviceHeader))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	identity := http.Header{}
+	identity.Set(DiodeDeviceHeader, device.HexString())
+
+	conn := serveHTTPConn(newIdentityHandler(handler, device, identity))
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req, _ := http.NewRequest("GET", "http://device.diode.link/", nil)
+	req.Header.Set(DiodeDeviceHeader, "0x02")
+	go req.Write(conn)
+	res, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Header.Get("Trusted") != device.HexString() || res.Header.Get("Device") != device.HexString() {
+		t.Errorf("device should be trusted but got %s %s", res.Header.Get("Trusted"), res.Header.Get("Device"))
+	}
+
+	// requests that didn't come through the bridge only have headers
+	w := httptest.NewRecorder()
+	direct := httptest.NewRequest("GET", "http://127.0.0.1:8080/", nil)
+	direct.Header.Set(DiodeDeviceHeader, device.HexString())
+	handler.ServeHTTP(w, direct)
+	if w.Header().Get("Trusted") != "" {
+		t.Errorf("forged identity header should not be trusted")
+	}
+}
@@ -0,0 +1,279 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+)
+
+const (
+	// RouteDiode connects diode hosts or the device of the route
+	RouteDiode = "diode"
+	// RouteDirect connects the host from this machine
+	RouteDirect = "direct"
+	// RouteReject refuses the request
+	RouteReject = "reject"
+	// RouteProxy connects the host through the upstream proxy of the route
+	RouteProxy = "proxy"
+)
+
+var (
+	routeDomainPattern = regexp.MustCompile(`^\.?[a-z0-9_-]+(\.[a-z0-9_-]+)*$`)
+	errRouteRejected   = errors.New("rejected by socks route")
+	errRouteUDPProxy   = errors.New("upstream proxies don't support udp")
+	// routeLookupIP resolves the hosts of cidr routes
+	routeLookupIP    = net.LookupIP
+	routeDialTimeout = 15 * time.Second
+
+	defaultDiodeRoute  = &Route{Name: "default", Action: RouteDiode}
+	defaultDirectRoute = &Route{Name: "fallback", Action: RouteDirect}
+	defaultRejectRoute = &Route{Name: "fallback", Action: RouteReject}
+)
+
+// Route is a validated socks route of the config file
+type Route struct {
+	Name    string
+	Action  string
+	Proxy   *url.URL
+	Device  string
+	domains []string
+	nets    []*net.IPNet
+	ports   [][2]int
+}
+
+// Router returns the first socks route that matches a request
+type Router struct {
+	routes []*Route
+}
+
+// NewRouter validates the socks routes, routes without name are named by
+// their position
+func NewRouter(defs []config.SocksRoute) (*Router, error) {
+	router := &Router{}
+	for i, def := range defs {
+		name := def.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		route, err := newRoute(name, def)
+		if err != nil {
+			return nil, fmt.Errorf("socks route %s: %v", name, err)
+		}
+		router.routes = append(router.routes, route)
+	}
+	return router, nil
+}
+
+func newRoute(name string, def config.SocksRoute) (route *Route, err error) {
+	route = &Route{
+		Name:   name,
+		Action: strings.ToLower(def.Action),
+		Device: strings.ToLower(def.Device),
+	}
+	for _, domain := range def.Domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "*" {
+			domain = strings.TrimPrefix(domain, "*")
+			if !routeDomainPattern.MatchString(domain) {
+				return nil, fmt.Errorf("invalid domain: %v", domain)
+			}
+		}
+		route.domains = append(route.domains, domain)
+	}
+	for _, cidr := range def.CIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			route.nets = append(route.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr: %v", cidr)
+		}
+		route.nets = append(route.nets, ipnet)
+	}
+	for _, ports := range def.Ports {
+		bounds := strings.SplitN(strings.TrimSpace(ports), "-", 2)
+		if len(bounds) == 1 {
+			bounds = append(bounds, bounds[0])
+		}
+		low, err1 := strconv.Atoi(bounds[0])
+		high, err2 := strconv.Atoi(bounds[1])
+		if err1 != nil || err2 != nil || low <= 0 || high > 65535 || low > high {
+			return nil, fmt.Errorf("invalid ports: %v", ports)
+		}
+		route.ports = append(route.ports, [2]int{low, high})
+	}
+	if route.Action != RouteProxy && len(def.Proxy) > 0 {
+		return nil, fmt.Errorf("proxy is only used by the proxy action")
+	}
+	if route.Action != RouteDiode && len(route.Device) > 0 {
+		return nil, fmt.Errorf("device is only used by the diode action")
+	}
+	switch route.Action {
+	case RouteDirect, RouteReject:
+	case RouteDiode:
+		if len(route.Device) > 0 && !subDomainpattern.MatchString(route.Device) {
+			return nil, fmt.Errorf("device should be an address or a bns name: %v", route.Device)
+		}
+	case RouteProxy:
+		route.Proxy, err = url.Parse(def.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+		if route.Proxy.Scheme != "socks5" && route.Proxy.Scheme != "socks5h" && route.Proxy.Scheme != "http" {
+			return nil, fmt.Errorf("proxy should be socks5://host:port, socks5h://host:port or http://host:port: %v", def.Proxy)
+		}
+		if _, _, err = net.SplitHostPort(route.Proxy.Host); err != nil {
+			return nil, fmt.Errorf("proxy should be socks5://host:port, socks5h://host:port or http://host:port: %v", def.Proxy)
+		}
+	default:
+		return nil, fmt.Errorf("action should be diode, direct, reject or proxy: %v", def.Action)
+	}
+	return route, nil
+}
+
+// Route returns the first route that matches the host:port, requests
+// without matching route are connected through diode for diode hosts and
+// by the fallback otherwise
+func (router *Router) Route(host string, fallback string) (*Route, error) {
+	name, portStr, err := net.SplitHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %v", portStr)
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	diode := isDiodeHost(host)
+	var ips []net.IP
+	if ip := net.ParseIP(name); ip != nil {
+		ips = []net.IP{ip}
+	}
+	resolved := len(ips) > 0 || diode
+	if router != nil {
+		for _, route := range router.routes {
+			if len(route.nets) > 0 && !resolved {
+				// the lookup is only needed for cidr routes
+				ips, _ = routeLookupIP(name)
+				resolved = true
+			}
+			if route.match(name, ips, port) {
+				return route, nil
+			}
+		}
+	}
+	if diode {
+		return defaultDiodeRoute, nil
+	}
+	if fallback == localhost {
+		return defaultDirectRoute, nil
+	}
+	return defaultRejectRoute, nil
+}
+
+// match returns true when the request matches the domains, cidrs and ports
+// of the route
+func (route *Route) match(name string, ips []net.IP, port int) bool {
+	if len(route.domains) > 0 && !route.matchDomain(name) {
+		return false
+	}
+	if len(route.nets) > 0 && !route.matchIP(ips) {
+		return false
+	}
+	if len(route.ports) > 0 && !route.matchPort(port) {
+		return false
+	}
+	return true
+}
+
+func (route *Route) matchDomain(name string) bool {
+	for _, domain := range route.domains {
+		switch {
+		case domain == "*":
+			return true
+		case strings.HasPrefix(domain, "."):
+			if strings.HasSuffix(name, domain) {
+				return true
+			}
+		case name == domain || strings.HasSuffix(name, "."+domain):
+			return true
+		}
+	}
+	return false
+}
+
+func (route *Route) matchIP(ips []net.IP) bool {
+	for _, ipnet := range route.nets {
+		for _, ip := range ips {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (route *Route) matchPort(port int) bool {
+	for _, ports := range route.ports {
+		if port >= ports[0] && port <= ports[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Target returns the host that is connected for the request, diode routes
+// with device connect the device on the requested port
+func (route *Route) Target(host string) string {
+	if route.Action != RouteDiode || len(route.Device) == 0 {
+		return host
+	}
+	_, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+	return net.JoinHostPort(route.Device+".diode", port)
+}
+
+// Dial connects the host directly or through the upstream proxy
+func (route *Route) Dial(network string, host string) (net.Conn, error) {
+	switch route.Action {
+	case RouteDirect:
+		return net.DialTimeout(network, host, routeDialTimeout)
+	case RouteProxy:
+		if network != "tcp" {
+			return nil, errRouteUDPProxy
+		}
+		return dialUpstream(route.Proxy, host)
+	}
+	return nil, errRouteRejected
+}
+
+// String returns the action of the route, credentials of the upstream proxy
+// are left out
+func (route *Route) String() string {
+	switch {
+	case route.Proxy != nil:
+		return fmt.Sprintf("%s %s://%s", route.Action, route.Proxy.Scheme, route.Proxy.Host)
+	case len(route.Device) > 0:
+		return fmt.Sprintf("%s %s", route.Action, route.Device)
+	}
+	return route.Action
+}
@@ -10,13 +10,14 @@ import (
 
 // NewRPCConn returns wrapper of gorilla websocket connection
 func NewRPCConn(rpcClient *RPCClient, ref string) *RPCConn {
-	return &RPCConn{rpcClient, ref}
+	return &RPCConn{conn: rpcClient, ref: ref}
 }
 
 // RPCConn is a net wrapper for diode rpc client
 type RPCConn struct {
-	conn *RPCClient
-	ref  string
+	conn   *RPCClient
+	ref    string
+	device *ConnectedDevice
 }
 
 // Close the connection
@@ -33,7 +34,11 @@ func (c *RPCConn) Read(buf []byte) (n int, err error) {
 
 // Write binary data to the connectionn
 func (c *RPCConn) Write(data []byte) (n int, err error) {
-	err = c.conn.PortSend(c.ref, data)
+	if c.device != nil {
+		err = c.device.PortSend(data)
+	} else {
+		err = c.conn.PortSend(c.ref, data)
+	}
 	if err == nil {
 		// how to validate writeed length
 		n = len(data)
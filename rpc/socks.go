@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -46,6 +47,7 @@ const (
 	socksRepHostUnreachable    = 0x04
 	socksRepRefused            = 0x05
 	socksRepTTLExpired         = 0x06
+	socks4RepRejected          = 0x5B
 )
 
 // Config is Socks Server configuration
@@ -55,8 +57,17 @@ type Config struct {
 	Fallback        string
 	EnableProxy     bool
 	FleetAddr       Address
-	Blocklists      map[Address]bool
-	Allowlists      map[Address]bool
+	// Users require username/password authentication when not empty
+	Users map[string]*config.SocksUser
+	// HTTPProxyAddr starts a http forward proxy when not empty
+	HTTPProxyAddr string
+	// PACAddr serves the proxy auto-config file when not empty
+	PACAddr string
+	// BNSNames are bns names that are connected without the .diode suffix
+	BNSNames []string
+	// Routes decide how requests are connected, Fallback is used when no
+	// route matches
+	Routes *Router
 }
 
 // Bind keeps track if existing binds
@@ -64,20 +75,32 @@ type Bind struct {
 	def config.Bind
 	tcp net.Listener
 	udp net.PacketConn
+	err error
+}
+
+// BindStatus is the state of a port bind listener
+type BindStatus struct {
+	Bind      config.Bind
+	Listening bool
+	Err       error
 }
 
 // Server is the only instances of the Socks Server
 type Server struct {
 	datapool *DataPool
-	Config   *Config
+	// cfg is replaced by config reloads, cm guards it
+	cfg      *Config
+	cm       sync.RWMutex
 	logger   *config.Logger
 	listener net.Listener
-	udpconn  net.PacketConn
-	wg       *sync.WaitGroup
-	rm       sync.Mutex
-	closeCh  chan struct{}
-	binds    []Bind
-	cd       sync.Once
+	// httpListener is the listener of the http forward proxy
+	httpListener net.Listener
+	pacServer    *http.Server
+	wg           *sync.WaitGroup
+	rm           sync.Mutex
+	closeCh      chan struct{}
+	binds        []Bind
+	cd           sync.Once
 }
 
 type DeviceError struct {
@@ -88,7 +111,7 @@ func (deviceError DeviceError) Error() string {
 	return fmt.Sprintf("This device is offline - %v", deviceError.err)
 }
 
-func handShake(conn net.Conn) (version int, url string, err error) {
+func handShake(conn net.Conn, users map[string]*config.SocksUser) (version int, cmd byte, url string, user *config.SocksUser, err error) {
 	const (
 		idVer = 0
 	)
@@ -101,9 +124,15 @@ func handShake(conn net.Conn) (version int, url string, err error) {
 	switch buf[idVer] {
 	case socksVer5:
 		version = 5
-		url, err = handShake5(conn, buf)
+		cmd, url, user, err = handShake5(conn, buf, users)
 	case socksVer4:
 		version = 4
+		if len(users) > 0 {
+			writeSocksError(conn, version, socks4RepRejected)
+			err = errSocks4Auth
+			return
+		}
+		cmd = socksCmdConnect
 		url, err = handShake4(conn, buf)
 	default:
 		err = errVer
@@ -171,7 +200,9 @@ func readString(conn net.Conn, buf []byte) (string, error) {
 	}
 }
 
-func handShake5(conn net.Conn, buf []byte) (url string, err error) {
+// handShake5 returns the command and the target address of the request, the
+// target of UDP ASSOCIATE is the address the client will send datagrams from
+func handShake5(conn net.Conn, buf []byte, users map[string]*config.SocksUser) (cmd byte, url string, user *config.SocksUser, err error) {
 	const (
 		idNmethod = 1
 	)
@@ -193,11 +224,16 @@ func handShake5(conn net.Conn, buf []byte) (url string, err error) {
 		X'80' to X'FE' RESERVED FOR PRIVATE METHODS
 		X'FF' NO ACCEPTABLE METHODS
 	*/
-	// send confirmation: version 5, no authentication required
-	_, err = conn.Write([]byte{socksVer5, 0})
+	method, err := selectAuthMethod(conn, buf[2:msgLen], users)
 	if err != nil {
 		return
 	}
+	if method == socksAuthPassword {
+		user, err = authenticate5(conn, buf, users)
+		if err != nil {
+			return
+		}
+	}
 
 	const (
 		idVer   = 0
@@ -233,7 +269,8 @@ func handShake5(conn net.Conn, buf []byte) (url string, err error) {
 		UDP ASSOCIATE X'03'
 	*/
 
-	if buf[idCmd] > 0x03 || buf[idCmd] == 0x00 {
+	cmd = buf[idCmd]
+	if cmd != socksCmdConnect && cmd != socksCmdUDP { //  BIND isn't supported
 		err = errCmd
 		return
 	}
@@ -242,40 +279,30 @@ func handShake5(conn net.Conn, buf []byte) (url string, err error) {
 	reqLen := -1
 	switch buf[idType] {
 	case typeIPv4:
-		err = fmt.Errorf("socks5 IPv4 not supported (only domain names)")
-		return
+		reqLen = lenIPv4
 	case typeIPv6:
-		err = fmt.Errorf("socks5 IPv6 not supported (only domain names)")
-		return
+		reqLen = lenIPv6
 	case typeDm: // domain name
 		reqLen = int(buf[idDmLen]) + lenDmBase
 	default:
 		err = errAddrType
 		return
 	}
-
-	if buf[idCmd] == socksCmdUDP { // UDP associate requests
-		tcpAddr := conn.LocalAddr().(*net.TCPAddr)
-		writeSocksReturn(conn, socksVer5, conn.LocalAddr(), tcpAddr.Port)
-		return
-	}
-
-	if buf[idCmd] != socksCmdConnect { //  only support CONNECT mode
-		err = errCmd
+	if _, err = io.ReadFull(conn, buf[idDmLen+1:reqLen]); err != nil {
 		return
 	}
 
-	if reqLen == 2 {
-		// common case, do nothing
-	} else { // rare case
-		if _, err = io.ReadFull(conn, buf[5:reqLen]); err != nil {
-			return
-		}
+	var host string
+	switch buf[idType] {
+	case typeIPv4:
+		host = net.IP(buf[idIP0 : idIP0+net.IPv4len]).String()
+	case typeIPv6:
+		host = net.IP(buf[idIP0 : idIP0+net.IPv6len]).String()
+	default:
+		host = string(buf[idDm0 : idDm0+buf[idDmLen]])
 	}
-
-	host := string(buf[idDm0 : idDm0+buf[idDmLen]])
-	port := binary.BigEndian.Uint16(buf[idDm0+buf[idDmLen] : idDm0+buf[idDmLen]+2])
-	url = fmt.Sprintf("%s:%d", host, port)
+	port := binary.BigEndian.Uint16(buf[reqLen-2 : reqLen])
+	url = net.JoinHostPort(host, strconv.Itoa(int(port)))
 	return
 }
 
@@ -328,34 +355,25 @@ func (socksServer *Server) checkAccess(deviceName string) (*edge.DeviceTicket, e
 	if client == nil {
 		return nil, HttpError{404, err}
 	}
-	if !util.IsHex([]byte(deviceName)) {
-		bnsKey := fmt.Sprintf("bns:%s", deviceName)
-		var ok bool
-		deviceID, ok = socksServer.datapool.GetCacheBNS(bnsKey)
-		if !ok {
-			deviceID, err = client.ResolveBNS(deviceName)
-			if err != nil {
-				return nil, HttpError{404, err}
-			}
-			socksServer.datapool.SetCacheBNS(bnsKey, deviceID)
-		}
-	} else {
-		deviceID, err = util.DecodeAddress(deviceName)
-		if err != nil {
+	deviceID, err = socksServer.resolveDevice(deviceName)
+	if err != nil {
+		if util.IsHex([]byte(deviceName)) {
 			err = fmt.Errorf("DeviceAddress '%s' is not an address: %v", deviceName, err)
 			return nil, HttpError{400, err}
 		}
+		return nil, HttpError{404, err}
 	}
 
 	// Checking blocklist and allowlist
-	if len(socksServer.Config.Blocklists) > 0 {
-		if socksServer.Config.Blocklists[deviceID] {
+	blocklists, allowlists := socksServer.datapool.AccessLists()
+	if len(blocklists) > 0 {
+		if blocklists[deviceID] {
 			err := fmt.Errorf("device %x is in the block list", deviceName)
 			return nil, HttpError{403, err}
 		}
 	} else {
-		if len(socksServer.Config.Allowlists) > 0 {
-			if !socksServer.Config.Allowlists[deviceID] {
+		if len(allowlists) > 0 {
+			if !allowlists[deviceID] {
 				err := fmt.Errorf("device %x is not in the allow list", deviceName)
 				return nil, HttpError{403, err}
 			}
@@ -434,9 +452,12 @@ func (socksServer *Server) doConnectDevice(deviceName string, port int, protocol
 		return nil, HttpError{500, fmt.Errorf("PortOpen() failed(2): %v", portOpen.Err)}
 	}
 	return &ConnectedDevice{
-		Ref:      portOpen.Ref,
-		DeviceID: deviceID,
-		Client:   client,
+		Ref:        portOpen.Ref,
+		Protocol:   protocol,
+		PortNumber: port,
+		DeviceID:   deviceID,
+		Client:     client,
+		CreatedAt:  time.Now(),
 	}, nil
 }
 
@@ -480,7 +501,7 @@ func (socksServer *Server) connectDeviceAndLoop(deviceName string, port int, pro
 	socksServer.datapool.SetDevice(deviceKey, connDevice)
 
 	// rpc client might be different with socks server
-	rpcConn := NewRPCConn(connDevice.Client, connDevice.Ref)
+	rpcConn := connDevice.NewRPCConn()
 	tunnel := NewTunnel(connDevice.Conn, rpcConn, idleTimeout, sslBufferSize)
 	tunnel.netCopyWithoutTimeout(connDevice.Conn, rpcConn, sslBufferSize)
 	connDevice.Close()
@@ -494,7 +515,13 @@ func writeSocksError(conn net.Conn, ver int, err byte) {
 }
 
 func writeSocksReturn(conn net.Conn, ver int, addr net.Addr, port int) {
-	tcpAddr := addr.(*net.TCPAddr)
+	var addrIP net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		addrIP = a.IP
+	case *net.UDPAddr:
+		addrIP = a.IP
+	}
 	if ver == 4 {
 		conn.Write([]byte{4, 0x5A, byte(port>>8) & 0xff, byte(port & 0xff), 0, 0, 0, 1})
 		return
@@ -507,11 +534,11 @@ func writeSocksReturn(conn net.Conn, ver int, addr net.Addr, port int) {
 
 	// IP
 	var ip net.IP
-	if tcpAddr.IP.Equal(tcpAddr.IP.To4()) {
-		ip = tcpAddr.IP.To4()
+	if addrIP.Equal(addrIP.To4()) {
+		ip = addrIP.To4()
 		rep[3] = 0x01
 	} else {
-		ip = tcpAddr.IP.To16()
+		ip = addrIP.To16()
 		rep[3] = 0x04
 	}
 
@@ -525,17 +552,24 @@ func writeSocksReturn(conn net.Conn, ver int, addr net.Addr, port int) {
 	conn.Write(rep[0 : pindex+2])
 }
 
-func (socksServer *Server) pipeFallback(conn net.Conn, ver int, host string) {
-	remoteConn, err := net.Dial("tcp", host)
+// route returns the socks route of the host
+func (socksServer *Server) route(host string) (*Route, error) {
+	cfg := socksServer.Config()
+	return cfg.Routes.Route(host, cfg.Fallback)
+}
+
+func (socksServer *Server) pipeFallback(conn net.Conn, ver int, host string, route *Route) {
+	remoteConn, err := route.Dial("tcp", host)
 	if err != nil {
-		socksServer.logger.Error("Failed to connect host: %v", host)
+		socksServer.logger.Error("Failed to connect host %v (route %s): %v", host, route.Name, err)
 		writeSocksError(conn, ver, socksRepNetworkUnreachable)
 		return
 	}
 	defer remoteConn.Close()
 	defer conn.Close()
 
-	port := remoteConn.RemoteAddr().(*net.TCPAddr).Port
+	_, portStr, _ := net.SplitHostPort(host)
+	port, _ := strconv.Atoi(portStr)
 
 	socksServer.logger.Debug("host connect success @ %s", host)
 	writeSocksReturn(conn, ver, remoteConn.LocalAddr(), port)
@@ -572,7 +606,7 @@ func (socksServer *Server) pipeSocksThenClose(conn net.Conn, ver int, device *ed
 }
 
 func (socksServer *Server) pipeSocksWSThenClose(conn net.Conn, ver int, device *edge.DeviceTicket, port int, mode string) {
-	remoteConn, err := net.DialTimeout("tcp", socksServer.Config.ProxyServerAddr, time.Duration(time.Second*15))
+	remoteConn, err := net.DialTimeout("tcp", socksServer.Config().ProxyServerAddr, time.Duration(time.Second*15))
 	if err != nil {
 		socksServer.logger.Error("Failed to connect remote: %s", err.Error())
 		writeSocksError(conn, ver, socksRepNetworkUnreachable)
@@ -590,22 +624,35 @@ func (socksServer *Server) pipeSocksWSThenClose(conn net.Conn, ver int, device *
 func (socksServer *Server) handleSocksConnection(conn net.Conn) {
 	defer conn.Close()
 	defer socksServer.wg.Done()
-	ver, host, err := handShake(conn)
+	ver, cmd, host, user, err := handShake(conn, socksServer.Config().Users)
 	if err != nil {
 		socksServer.logger.Error("Dialed to handshake %v", err)
 		return
 	}
-	if host == "" {
-		// UDP associate request returns an empty host
+	if cmd == socksCmdUDP {
+		socksServer.associateUDP(conn, host, user)
 		return
 	}
+	host = socksServer.diodeHost(host)
+	route, err := socksServer.route(host)
+	if err != nil {
+		socksServer.logger.Error("Failed to route host %v", err)
+		writeSocksError(conn, ver, socksRepHostUnreachable)
+		return
+	}
+	switch route.Action {
+	case RouteDirect, RouteProxy:
+		socksServer.pipeFallback(conn, ver, host, route)
+		return
+	case RouteReject:
+		socksServer.logger.Error("Target %v rejected by route %s", host, route.Name)
+		writeSocksError(conn, ver, socksRepRefused)
+		return
+	}
+	host = route.Target(host)
 	if !isDiodeHost(host) {
-		if socksServer.Config.Fallback == "localhost" {
-			socksServer.pipeFallback(conn, ver, host)
-		} else {
-			socksServer.logger.Error("Target not a diode host %v", host)
-			writeSocksError(conn, ver, socksRepRefused)
-		}
+		socksServer.logger.Error("Target not a diode host %v", host)
+		writeSocksError(conn, ver, socksRepRefused)
 		return
 	}
 
@@ -614,6 +661,11 @@ func (socksServer *Server) handleSocksConnection(conn net.Conn) {
 		socksServer.logger.Error("Failed to parse host %v", err)
 		return
 	}
+	if err = socksServer.checkUserAccess(user, deviceID); err != nil {
+		socksServer.logger.Error("Failed to checkUserAccess %v", err)
+		writeSocksError(conn, ver, socksRepNotAllowed)
+		return
+	}
 	device, httpErr := socksServer.checkAccess(deviceID)
 	if device == nil {
 		socksServer.logger.Error("Failed to checkAccess %v", httpErr.Error())
@@ -622,7 +674,7 @@ func (socksServer *Server) handleSocksConnection(conn net.Conn) {
 	}
 	if !isWS {
 		socksServer.pipeSocksThenClose(conn, ver, device, port, mode)
-	} else if socksServer.Config.EnableProxy {
+	} else if socksServer.Config().EnableProxy {
 		socksServer.pipeSocksWSThenClose(conn, ver, device, port, mode)
 	} else {
 		socksServer.logger.Error("Proxy not enabled, can't forward websocket connection")
@@ -636,8 +688,9 @@ func (socksServer *Server) Start() error {
 		return nil
 	}
 
-	socksServer.logger.Info("Start socks server %s", socksServer.Config.Addr)
-	tcp, err := net.Listen("tcp", socksServer.Config.Addr)
+	cfg := socksServer.Config()
+	socksServer.logger.Info("Start socks server %s", cfg.Addr)
+	tcp, err := net.Listen("tcp", cfg.Addr)
 	if err != nil {
 		return err
 	}
@@ -659,132 +712,15 @@ func (socksServer *Server) Start() error {
 		}
 	}()
 
-	udp, err := net.ListenPacket("udp", socksServer.Config.Addr)
-	if err != nil {
-		return err
-	}
-	socksServer.udpconn = udp
-
-	go func() {
-		buf := make([]byte, 2048)
-		for {
-			socksServer.handleUDP(buf)
-		}
-	}()
-
-	return nil
-}
-
-func (socksServer *Server) handleUDP(packet []byte) {
-	n, addr, err := socksServer.udpconn.ReadFrom(packet)
-	if err != nil {
-		socksServer.logger.Error("handleUDP error: %v", err)
-		return
-	}
-
-	packet = packet[:n]
-
-	// Compare reference at: https://tools.ietf.org/html/rfc1928
-	// Chapter: 7. Procedure for UDP-based clients
-	const (
-		idRsv   = 0
-		idFrag  = 2
-		idType  = 3 // address type index
-		idDmLen = 4 // domain address length index
-		idDm0   = 5 // domain address start index
-
-		typeIPv4 = 1 // type is ipv4 address
-		typeDm   = 3 // type is domain address
-		typeIPv6 = 4 // type is ipv6 address
-	)
-
-	if len(packet) <= idDmLen {
-		socksServer.logger.Error("handleUDP error: too short")
-		return
-	}
-
-	if packet[idFrag] != 0 {
-		socksServer.logger.Error("handleUDP error: UDP frag %v not yet implemented", packet[idFrag])
-		return
-	}
-
-	// Read target address
-	dmLen := -1
-	switch packet[idType] {
-	case typeIPv4:
-		socksServer.logger.Error("handleUDP error: IPv4 not supported (only domain names)")
-		return
-	case typeIPv6:
-		socksServer.logger.Error("handleUDP error: IPv6 not supported (only domain names)")
-		return
-	case typeDm: // domain name
-		dmLen = int(packet[idDmLen])
-	default:
-		socksServer.logger.Error("handleUDP error: Non supported protocol %v", packet[idType])
-		return
-	}
-
-	if len(packet) <= idDm0+dmLen+3 {
-		socksServer.logger.Error("handleUDP error: too short #2")
-		return
-	}
-
-	host := string(packet[idDm0 : idDm0+dmLen])
-	portBytes := packet[idDm0+dmLen : idDm0+dmLen+2]
-	port := int(binary.BigEndian.Uint16(portBytes))
-	data := packet[idDm0+dmLen+2:]
-
-	// Finished parsing packet
-	isWS, mode, deviceID, _, err := parseHost(host)
-	if err != nil {
-		socksServer.logger.Error("handleUDP error: Failed to parse %s %v", host, err)
-		return
-	}
-
-	if isWS {
-		socksServer.logger.Error("handleUDP error: WS is not supported")
-		return
-	}
-
-	socksServer.forwardUDP(addr, deviceID, port, mode, data)
-}
-
-func (socksServer *Server) forwardUDP(addr net.Addr, deviceName string, port int, mode string, data []byte) {
-	connDevice := socksServer.datapool.FindDevice(addr.String())
-	if connDevice != nil {
-		err := connDevice.Client.PortSend(connDevice.Ref, data)
-		if err != nil {
-			connDevice.Client.Error("forwardUDP error: PortSend(): %v", err)
+	if len(cfg.HTTPProxyAddr) > 0 {
+		if err = socksServer.startHTTPProxy(); err != nil {
+			return err
 		}
-		return
 	}
-
-	// Preparing a response channel
-	conn, err := net.DialUDP("udp", nil, addr.(*net.UDPAddr))
-	if err != nil {
-		socksServer.logger.Error("forwardUDP error: DialUDP %v", err)
-		return
-	}
-
-	err = socksServer.connectDeviceAndLoop(deviceName, port, config.UDPProtocol, mode, defaultIdleTimeout, func(connDevice2 *ConnectedDevice) (*DeviceConn, error) {
-		err := connDevice2.Client.PortSend(connDevice2.Ref, data)
-		if err != nil {
-			connDevice2.Client.Error("forwardUDP error: PortSend(): %v", err)
-		}
-		return &DeviceConn{
-			Conn:       conn,
-			bufferSize: sslBufferSize,
-			closeCh:    make(chan struct{}),
-		}, err
-	})
-
-	if err != nil {
-		if httpErr, ok := err.(HttpError); ok {
-			socksServer.logger.Error("forwardUDP error: connectDevice(): %v", httpErr.Error())
-		}
-		conn.Close()
-		return
+	if len(cfg.PACAddr) > 0 {
+		return socksServer.startPAC()
 	}
+	return nil
 }
 
 func (socksServer *Server) SetBinds(bindDefs []config.Bind) {
@@ -798,11 +734,11 @@ func (socksServer *Server) SetBinds(bindDefs []config.Bind) {
 				break
 			}
 		}
-		newBinds = append(newBinds, *newBind)
-		err := socksServer.startBind(newBind)
-		if err != nil {
-			socksServer.logger.Error(err.Error())
+		newBind.err = socksServer.startBind(newBind)
+		if newBind.err != nil {
+			socksServer.logger.Error(newBind.err.Error())
 		}
+		newBinds = append(newBinds, *newBind)
 	}
 
 	for _, bind := range socksServer.binds {
@@ -817,7 +753,24 @@ func (socksServer *Server) SetBinds(bindDefs []config.Bind) {
 			socksServer.stopBind(bind)
 		}
 	}
+	socksServer.rm.Lock()
 	socksServer.binds = newBinds
+	socksServer.rm.Unlock()
+}
+
+// Binds returns the status of the port bind listeners
+func (socksServer *Server) Binds() []BindStatus {
+	socksServer.rm.Lock()
+	defer socksServer.rm.Unlock()
+	binds := make([]BindStatus, len(socksServer.binds))
+	for i, bind := range socksServer.binds {
+		binds[i] = BindStatus{
+			Bind:      bind.def,
+			Listening: bind.tcp != nil || bind.udp != nil,
+			Err:       bind.err,
+		}
+	}
+	return binds
 }
 
 func (socksServer *Server) stopBind(bind Bind) {
@@ -845,15 +798,22 @@ func (socksServer *Server) startBind(bind *Bind) error {
 			return fmt.Errorf("StartBind() failed for: %+v because %v", bind.def, err)
 		}
 
-		packet := make([]byte, 2048)
+		packet := make([]byte, udpBufferSize)
+		udp := bind.udp
 		go func() {
+			sessions := newUDPSessions()
+			defer sessions.closeAll()
 			for {
-				n, addr, err := bind.udp.ReadFrom(packet)
+				n, addr, err := udp.ReadFrom(packet)
 				if err != nil {
+					// ReadFrom will return op close error after stopBind
+					if isOpError(err) {
+						break
+					}
 					socksServer.logger.Error("StartBind(udp): %v", err)
 					continue
 				}
-				socksServer.forwardUDP(addr, bind.def.To, bind.def.ToPort, "rw", packet[:n])
+				socksServer.forwardUDP(sessions, udp, addr, bind.def.To, bind.def.ToPort, packet[:n])
 			}
 		}()
 
@@ -903,7 +863,7 @@ func (socksServer *Server) handleBind(conn net.Conn, bind config.Bind) {
 // NewSocksServer generate socksserver struct
 func NewSocksServer(pool *DataPool) *Server {
 	return &Server{
-		Config:   &Config{},
+		cfg:      &Config{},
 		logger:   config.AppConfig.Logger,
 		wg:       &sync.WaitGroup{},
 		datapool: pool,
@@ -912,10 +872,19 @@ func NewSocksServer(pool *DataPool) *Server {
 	}
 }
 
+// SetConfig replaces the configuration, connections that were accepted
+// before keep the configuration they read
 func (socksServer *Server) SetConfig(config *Config) {
-	socksServer.rm.Lock()
-	defer socksServer.rm.Unlock()
-	socksServer.Config = config
+	socksServer.cm.Lock()
+	defer socksServer.cm.Unlock()
+	socksServer.cfg = config
+}
+
+// Config returns the current configuration, it must not be modified
+func (socksServer *Server) Config() *Config {
+	socksServer.cm.RLock()
+	defer socksServer.cm.RUnlock()
+	return socksServer.cfg
 }
 
 // GetServer gets or creates a new SSL connection to the given server
@@ -984,14 +953,18 @@ func (socksServer *Server) Closed() bool {
 func (socksServer *Server) Close() {
 	socksServer.cd.Do(func() {
 		close(socksServer.closeCh)
+		if socksServer.pacServer != nil {
+			socksServer.pacServer.Close()
+			socksServer.pacServer = nil
+		}
 		if socksServer.listener != nil {
 			socksServer.listener.Close()
 			socksServer.listener = nil
 		}
-		// if socksServer.udpconn != nil {
-		// 	socksServer.udpconn.Close()
-		// 	socksServer.udpconn = nil
-		// }
+		if socksServer.httpListener != nil {
+			socksServer.httpListener.Close()
+			socksServer.httpListener = nil
+		}
 		for _, bind := range socksServer.binds {
 			if bind.tcp != nil {
 				bind.tcp.Close()
@@ -0,0 +1,133 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/util"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	socksAuthNone     = 0x00
+	socksAuthPassword = 0x02
+	socksAuthNoAccept = 0xFF
+	socksAuthVer      = 0x01
+	socksAuthSuccess  = 0x00
+	socksAuthFailure  = 0x01
+	bcryptPrefix      = "$2"
+)
+
+var (
+	errSocksAuthMethod = fmt.Errorf("socks client doesn't support username/password authentication")
+	errSocksAuthFailed = fmt.Errorf("socks authentication failed")
+	errNoConnectedNode = fmt.Errorf("no connected node")
+	errSocks4Auth      = fmt.Errorf("socks4 doesn't support authentication")
+)
+
+// CheckSocksPassword returns true if the password matches the bcrypt hash
+// or the plain password of the user
+func CheckSocksPassword(user *config.SocksUser, password string) bool {
+	if strings.HasPrefix(user.Password, bcryptPrefix) {
+		return bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) == 1
+}
+
+// selectAuthMethod replies to the offered methods of the socks5 greeting,
+// username/password authentication is required when users are configured
+func selectAuthMethod(conn net.Conn, methods []byte, users map[string]*config.SocksUser) (method byte, err error) {
+	if len(users) == 0 {
+		_, err = conn.Write([]byte{socksVer5, socksAuthNone})
+		return socksAuthNone, err
+	}
+	for _, m := range methods {
+		if m == socksAuthPassword {
+			_, err = conn.Write([]byte{socksVer5, socksAuthPassword})
+			return socksAuthPassword, err
+		}
+	}
+	conn.Write([]byte{socksVer5, socksAuthNoAccept})
+	return socksAuthNoAccept, errSocksAuthMethod
+}
+
+// authenticate5 reads the username/password request of RFC 1929 and
+// returns the authenticated user
+func authenticate5(conn net.Conn, buf []byte, users map[string]*config.SocksUser) (*config.SocksUser, error) {
+	// ver + ulen
+	if _, err := io.ReadFull(conn, buf[0:2]); err != nil {
+		return nil, err
+	}
+	if buf[0] != socksAuthVer {
+		return nil, errVer
+	}
+	// username + plen
+	ulen := int(buf[1])
+	if _, err := io.ReadFull(conn, buf[0:ulen+1]); err != nil {
+		return nil, err
+	}
+	name := string(buf[0:ulen])
+	plen := int(buf[ulen])
+	if _, err := io.ReadFull(conn, buf[0:plen]); err != nil {
+		return nil, err
+	}
+	password := string(buf[0:plen])
+	user, ok := users[name]
+	if !ok || !CheckSocksPassword(user, password) {
+		conn.Write([]byte{socksAuthVer, socksAuthFailure})
+		return nil, errSocksAuthFailed
+	}
+	_, err := conn.Write([]byte{socksAuthVer, socksAuthSuccess})
+	return user, err
+}
+
+// resolveDevice returns the address of the device address or bns name
+func (socksServer *Server) resolveDevice(deviceName string) (deviceID Address, err error) {
+	if util.IsHex([]byte(deviceName)) {
+		return util.DecodeAddress(deviceName)
+	}
+	bnsKey := fmt.Sprintf("bns:%s", deviceName)
+	deviceID, ok := socksServer.datapool.GetCacheBNS(bnsKey)
+	if ok {
+		return
+	}
+	client := socksServer.datapool.GetNearestClient()
+	if client == nil {
+		err = fmt.Errorf("%w to resolve %s", errNoConnectedNode, deviceName)
+		return
+	}
+	deviceID, err = client.ResolveBNS(deviceName)
+	if err != nil {
+		return
+	}
+	socksServer.datapool.SetCacheBNS(bnsKey, deviceID)
+	return
+}
+
+// checkUserAccess returns an error unless the user may connect to the device
+func (socksServer *Server) checkUserAccess(user *config.SocksUser, deviceName string) error {
+	if user == nil || len(user.Devices) == 0 {
+		return nil
+	}
+	deviceID, err := socksServer.resolveDevice(deviceName)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range user.Devices {
+		if strings.EqualFold(allowed, deviceName) {
+			return nil
+		}
+		addr, err := socksServer.resolveDevice(allowed)
+		if err == nil && addr == deviceID {
+			return nil
+		}
+	}
+	return fmt.Errorf("device %s is not allowed for socks user %s", deviceName, user.Name)
+}
@@ -0,0 +1,123 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testSocksAuth(t *testing.T, users map[string]*config.SocksUser, request []byte, replyLen int) ([]byte, *config.SocksUser, error) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	local.SetDeadline(time.Now().Add(5 * time.Second))
+	remote.SetDeadline(time.Now().Add(5 * time.Second))
+	replyCh := make(chan []byte, 1)
+	// net.Pipe is synchronous, the request is written while the replies
+	// are read
+	go local.Write(request)
+	go func() {
+		reply := make([]byte, replyLen)
+		n, _ := io.ReadFull(local, reply)
+		replyCh <- reply[:n]
+		local.Close()
+	}()
+	_, _, _, user, err := handShake(remote, users)
+	remote.Close()
+	return <-replyCh, user, err
+}
+
+func socksAuthRequest(name string, password string) []byte {
+	req := []byte{socksVer5, 2, socksAuthNone, socksAuthPassword, socksAuthVer, byte(len(name))}
+	req = append(req, name...)
+	req = append(req, byte(len(password)))
+	return append(req, password...)
+}
+
+func TestSocksAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := map[string]*config.SocksUser{
+		"alice": {Name: "alice", Password: string(hash)},
+		"bob":   {Name: "bob", Password: "plain"},
+	}
+
+	reply, user, err := testSocksAuth(t, users, socksAuthRequest("alice", "secret"), 4)
+	if user == nil || user.Name != "alice" {
+		t.Errorf("alice should be authenticated: %v", err)
+	}
+	if !bytes.Equal(reply, []byte{socksVer5, socksAuthPassword, socksAuthVer, socksAuthSuccess}) {
+		t.Errorf("wrong reply %v", reply)
+	}
+
+	reply, user, err = testSocksAuth(t, users, socksAuthRequest("bob", "plain"), 4)
+	if user == nil || user.Name != "bob" {
+		t.Errorf("bob should be authenticated: %v", err)
+	}
+
+	reply, user, err = testSocksAuth(t, users, socksAuthRequest("alice", "wrong"), 4)
+	if err != errSocksAuthFailed || user != nil {
+		t.Errorf("wrong password should fail but got %v", err)
+	}
+	if !bytes.Equal(reply, []byte{socksVer5, socksAuthPassword, socksAuthVer, socksAuthFailure}) {
+		t.Errorf("wrong reply %v", reply)
+	}
+
+	reply, _, err = testSocksAuth(t, users, []byte{socksVer5, 1, socksAuthNone}, 2)
+	if err != errSocksAuthMethod {
+		t.Errorf("no auth should be rejected but got %v", err)
+	}
+	if !bytes.Equal(reply, []byte{socksVer5, socksAuthNoAccept}) {
+		t.Errorf("wrong reply %v", reply)
+	}
+
+	_, _, err = testSocksAuth(t, users, []byte{socksVer4, socksCmdConnect}, 2)
+	if err != errSocks4Auth {
+		t.Errorf("socks4 should be rejected but got %v", err)
+	}
+}
+
+func TestSocksServerReloadUsers(t *testing.T) {
+	config.AppConfig = testConfig()
+	users := map[string]*config.SocksUser{
+		"alice": {Name: "alice", Password: "plain"},
+	}
+	socksServer := NewSocksServer(nil)
+	socksServer.SetConfig(&Config{Addr: "127.0.0.1:0", Users: users})
+	if err := socksServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer socksServer.Close()
+
+	// reloads replace the users while connections are handled
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			socksServer.SetConfig(&Config{Addr: "127.0.0.1:0", Users: users})
+		}
+	}()
+	conn, err := net.Dial("tcp", socksServer.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{socksVer5, 1, socksAuthNone})
+	reply := make([]byte, 2)
+	io.ReadFull(conn, reply)
+	<-done
+	if !bytes.Equal(reply, []byte{socksVer5, socksAuthNoAccept}) {
+		t.Errorf("no auth should be rejected but got %v", reply)
+	}
+}
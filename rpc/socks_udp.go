@@ -0,0 +1,549 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+)
+
+// Compare reference at: https://tools.ietf.org/html/rfc1928
+// Chapter: 7. Procedure for UDP-based clients
+const (
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+	// udpFragEnd marks the last fragment of a datagram
+	udpFragEnd = 0x80
+	// udpFragTimeout is the reassembly timer, RFC 1928 asks for no less than
+	// 5 seconds
+	udpFragTimeout = 5 * time.Second
+	udpBufferSize  = 65535
+	// udpMaxPending limits the datagrams queued while the device port opens
+	udpMaxPending = 64
+)
+
+var (
+	// udpIdleTimeout closes udp sessions without datagrams in either direction
+	udpIdleTimeout = 2 * time.Minute
+	errUDPHeader   = fmt.Errorf("socks udp header too short")
+)
+
+// parseUDPHeader returns the fragment number, the destination and the data
+// of a socks udp request
+func parseUDPHeader(packet []byte) (frag byte, host string, port int, data []byte, err error) {
+	const (
+		idFrag = 2
+		idType = 3
+		idAddr = 4
+	)
+	if len(packet) <= idAddr {
+		err = errUDPHeader
+		return
+	}
+	frag = packet[idFrag]
+	addrEnd := -1
+	switch packet[idType] {
+	case socksAddrIPv4:
+		addrEnd = idAddr + net.IPv4len
+	case socksAddrIPv6:
+		addrEnd = idAddr + net.IPv6len
+	case socksAddrDomain:
+		addrEnd = idAddr + 1 + int(packet[idAddr])
+	default:
+		err = errAddrType
+		return
+	}
+	if len(packet) < addrEnd+2 {
+		err = errUDPHeader
+		return
+	}
+	if packet[idType] == socksAddrDomain {
+		host = string(packet[idAddr+1 : addrEnd])
+	} else {
+		host = net.IP(packet[idAddr:addrEnd]).String()
+	}
+	port = int(binary.BigEndian.Uint16(packet[addrEnd : addrEnd+2]))
+	data = packet[addrEnd+2:]
+	return
+}
+
+// udpHeader returns the socks udp header of replies from the host
+func udpHeader(host string, port int) []byte {
+	header := []byte{0, 0, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		header = append(header, socksAddrDomain, byte(len(host)))
+		header = append(header, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		header = append(header, socksAddrIPv4)
+		header = append(header, ip4...)
+	} else {
+		header = append(header, socksAddrIPv6)
+		header = append(header, ip.To16()...)
+	}
+	return append(header, byte(port>>8), byte(port))
+}
+
+// udpReassembler is the reassembly queue of fragmented socks udp requests
+type udpReassembler struct {
+	position  byte
+	host      string
+	port      int
+	fragments [][]byte
+	size      int
+	expiresAt time.Time
+}
+
+func (r *udpReassembler) reset() {
+	r.position = 0
+	r.fragments = nil
+	r.size = 0
+}
+
+// add queues the fragment and returns the datagram once the last fragment
+// arrived. A fragment that doesn't follow the queued ones reinitializes the
+// queue, so lost or reordered fragments drop the datagram.
+func (r *udpReassembler) add(frag byte, host string, port int, data []byte, now time.Time) (string, int, []byte, bool) {
+	if frag == 0 {
+		// standalone datagram
+		r.reset()
+		return host, port, data, true
+	}
+	position := frag &^ udpFragEnd
+	if len(r.fragments) > 0 && (position <= r.position || now.After(r.expiresAt) || host != r.host || port != r.port) {
+		r.reset()
+	}
+	if position != r.position+1 || r.size+len(data) > udpBufferSize {
+		r.reset()
+		return "", 0, nil, false
+	}
+	if position == 1 {
+		r.host = host
+		r.port = port
+		r.expiresAt = now.Add(udpFragTimeout)
+	}
+	r.position = position
+	r.fragments = append(r.fragments, append([]byte{}, data...))
+	r.size += len(data)
+	if frag&udpFragEnd == 0 {
+		return "", 0, nil, false
+	}
+	datagram := make([]byte, 0, r.size)
+	for _, fragment := range r.fragments {
+		datagram = append(datagram, fragment...)
+	}
+	r.reset()
+	return host, port, datagram, true
+}
+
+// udpSession relays the datagrams between a udp client and a diode device
+// port or a fallback host. It implements net.Conn so it can be used as the
+// local side of a device tunnel, writes are sent to the client. The session
+// is closed after udpIdleTimeout without datagrams in either direction.
+type udpSession struct {
+	pc         net.PacketConn
+	addr       net.Addr
+	header     []byte
+	lastActive int64
+	rm         sync.Mutex
+	device     *ConnectedDevice
+	remote     net.Conn
+	pending    [][]byte
+	cd         sync.Once
+	closeCh    chan struct{}
+}
+
+// newUDPSession returns a session that replies to the client address, the
+// header is prepended to the replies of socks udp associations
+func newUDPSession(pc net.PacketConn, addr net.Addr, header []byte) *udpSession {
+	session := &udpSession{
+		pc:      pc,
+		addr:    addr,
+		header:  header,
+		closeCh: make(chan struct{}),
+	}
+	session.touch()
+	go session.expire()
+	return session
+}
+
+func (session *udpSession) touch() {
+	atomic.StoreInt64(&session.lastActive, time.Now().UnixNano())
+}
+
+func (session *udpSession) expire() {
+	timer := time.NewTimer(udpIdleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-session.closeCh:
+			return
+		case <-timer.C:
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&session.lastActive)))
+			if idle >= udpIdleTimeout {
+				session.Close()
+				return
+			}
+			timer.Reset(udpIdleTimeout - idle)
+		}
+	}
+}
+
+// setDevice sends the queued datagrams to the opened device port
+func (session *udpSession) setDevice(device *ConnectedDevice) {
+	session.rm.Lock()
+	session.device = device
+	pending := session.pending
+	session.pending = nil
+	session.rm.Unlock()
+	for _, data := range pending {
+		if err := device.PortSend(data); err != nil {
+			device.Client.Error("Failed to send udp datagram: %v", err)
+			return
+		}
+	}
+}
+
+// send relays a datagram of the client, datagrams are queued until the
+// device port is open
+func (session *udpSession) send(data []byte) error {
+	session.touch()
+	session.rm.Lock()
+	device := session.device
+	remote := session.remote
+	if device == nil && remote == nil {
+		if len(session.pending) < udpMaxPending {
+			session.pending = append(session.pending, append([]byte{}, data...))
+		}
+		session.rm.Unlock()
+		return nil
+	}
+	session.rm.Unlock()
+	if remote != nil {
+		_, err := remote.Write(data)
+		return err
+	}
+	return device.PortSend(data)
+}
+
+// pipeFallback relays the replies of the fallback host to the client
+func (session *udpSession) pipeFallback() {
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, err := session.remote.Read(buf)
+		if err != nil {
+			session.Close()
+			return
+		}
+		if _, err = session.Write(buf[:n]); err != nil {
+			session.Close()
+			return
+		}
+	}
+}
+
+// Read blocks until the session is closed, datagrams of the client are
+// relayed by send
+func (session *udpSession) Read(buf []byte) (int, error) {
+	<-session.closeCh
+	return 0, io.EOF
+}
+
+// Write sends a datagram to the client
+func (session *udpSession) Write(data []byte) (int, error) {
+	if session.Closed() {
+		return 0, io.EOF
+	}
+	session.touch()
+	packet := data
+	if session.header != nil {
+		packet = make([]byte, 0, len(session.header)+len(data))
+		packet = append(packet, session.header...)
+		packet = append(packet, data...)
+	}
+	if _, err := session.pc.WriteTo(packet, session.addr); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Closed returns whether the session had been closed
+func (session *udpSession) Closed() bool {
+	return isClosed(session.closeCh)
+}
+
+// Close the session, the device tunnel is closed once Read returns
+func (session *udpSession) Close() error {
+	session.cd.Do(func() {
+		close(session.closeCh)
+		session.rm.Lock()
+		if session.remote != nil {
+			session.remote.Close()
+		}
+		session.pending = nil
+		session.rm.Unlock()
+	})
+	return nil
+}
+
+// LocalAddr returns the address of the udp listener
+func (session *udpSession) LocalAddr() net.Addr {
+	return session.pc.LocalAddr()
+}
+
+// RemoteAddr returns the address of the udp client
+func (session *udpSession) RemoteAddr() net.Addr {
+	return session.addr
+}
+
+// SetDeadline is a noop, sessions are closed when idle
+func (session *udpSession) SetDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadDeadline is a noop, sessions are closed when idle
+func (session *udpSession) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline is a noop, sessions are closed when idle
+func (session *udpSession) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// udpSessions are the open sessions of a udp listener
+type udpSessions struct {
+	rm       sync.Mutex
+	sessions map[string]*udpSession
+}
+
+func newUDPSessions() *udpSessions {
+	return &udpSessions{sessions: make(map[string]*udpSession)}
+}
+
+// get returns the open session of the key
+func (s *udpSessions) get(key string) *udpSession {
+	s.rm.Lock()
+	defer s.rm.Unlock()
+	session := s.sessions[key]
+	if session != nil && session.Closed() {
+		delete(s.sessions, key)
+		return nil
+	}
+	return session
+}
+
+// add the session, expired sessions are removed on the way
+func (s *udpSessions) add(key string, session *udpSession) {
+	s.rm.Lock()
+	defer s.rm.Unlock()
+	for k, v := range s.sessions {
+		if v.Closed() {
+			delete(s.sessions, k)
+		}
+	}
+	s.sessions[key] = session
+}
+
+func (s *udpSessions) closeAll() {
+	s.rm.Lock()
+	defer s.rm.Unlock()
+	for key, session := range s.sessions {
+		session.Close()
+		delete(s.sessions, key)
+	}
+}
+
+// openDeviceUDP opens the udp port of the device for the session
+func (socksServer *Server) openDeviceUDP(session *udpSession, deviceName string, port int, mode string) {
+	go func() {
+		err := socksServer.connectDeviceAndLoop(deviceName, port, config.UDPProtocol, mode, defaultIdleTimeout, func(connDevice *ConnectedDevice) (*DeviceConn, error) {
+			session.setDevice(connDevice)
+			return &DeviceConn{
+				Conn:       session,
+				bufferSize: sslBufferSize,
+				closeCh:    make(chan struct{}),
+			}, nil
+		})
+		if err != nil {
+			socksServer.logger.Error("Failed to connectDevice(%v): %v", deviceName, err.Error())
+		}
+		session.Close()
+	}()
+}
+
+// forwardUDP relays a datagram of a udp port bind to the device
+func (socksServer *Server) forwardUDP(sessions *udpSessions, pc net.PacketConn, addr net.Addr, deviceName string, port int, data []byte) {
+	key := addr.String()
+	session := sessions.get(key)
+	if session == nil {
+		session = newUDPSession(pc, addr, nil)
+		sessions.add(key, session)
+		socksServer.openDeviceUDP(session, deviceName, port, defaultMode)
+	}
+	if err := session.send(data); err != nil {
+		socksServer.logger.Error("forwardUDP error: %v", err)
+	}
+}
+
+// udpAssociation is the udp relay of a socks5 UDP ASSOCIATE request, it
+// lives as long as the tcp control connection. Only datagrams from the ip
+// of the control connection are relayed.
+type udpAssociation struct {
+	server      *Server
+	user        *config.SocksUser
+	relay       net.PacketConn
+	clientIP    net.IP
+	clientPort  int
+	sessions    *udpSessions
+	reassembler udpReassembler
+}
+
+// associateUDP opens a relay for the socks client and keeps it open until
+// the control connection is closed
+func (socksServer *Server) associateUDP(conn net.Conn, clientAddr string, user *config.SocksUser) {
+	localIP := conn.LocalAddr().(*net.TCPAddr).IP
+	relay, err := net.ListenPacket("udp", net.JoinHostPort(localIP.String(), "0"))
+	if err != nil {
+		socksServer.logger.Error("Failed to open udp relay: %v", err)
+		writeSocksError(conn, socksVer5, socksRepServerFailed)
+		return
+	}
+	defer relay.Close()
+
+	assoc := &udpAssociation{
+		server:   socksServer,
+		user:     user,
+		relay:    relay,
+		clientIP: conn.RemoteAddr().(*net.TCPAddr).IP,
+		sessions: newUDPSessions(),
+	}
+	// the client may announce its source port, the address is ignored as
+	// clients behind a nat don't know their address
+	if _, port, err := net.SplitHostPort(clientAddr); err == nil {
+		assoc.clientPort, _ = strconv.Atoi(port)
+	}
+	defer assoc.sessions.closeAll()
+
+	relayPort := relay.LocalAddr().(*net.UDPAddr).Port
+	writeSocksReturn(conn, socksVer5, relay.LocalAddr(), relayPort)
+	go assoc.serve()
+
+	// the control connection carries no data, the association ends when the
+	// client closes it
+	io.Copy(ioutil.Discard, conn)
+}
+
+// accept returns true if the datagram is from the client of the association
+func (assoc *udpAssociation) accept(addr net.Addr) bool {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok || !udpAddr.IP.Equal(assoc.clientIP) {
+		return false
+	}
+	return assoc.clientPort == 0 || udpAddr.Port == assoc.clientPort
+}
+
+func (assoc *udpAssociation) serve() {
+	logger := assoc.server.logger
+	packet := make([]byte, udpBufferSize)
+	for {
+		n, addr, err := assoc.relay.ReadFrom(packet)
+		if err != nil {
+			// ReadFrom will return op close error after the association ended
+			if isOpError(err) {
+				return
+			}
+			logger.Error("handleUDP error: %v", err)
+			continue
+		}
+		if !assoc.accept(addr) {
+			logger.Debug("handleUDP error: dropped datagram of %s", addr.String())
+			continue
+		}
+		frag, host, port, data, err := parseUDPHeader(packet[:n])
+		if err != nil {
+			logger.Error("handleUDP error: %v", err)
+			continue
+		}
+		host, port, data, ok := assoc.reassembler.add(frag, host, port, data, time.Now())
+		if !ok {
+			continue
+		}
+		assoc.forward(addr, host, port, data)
+	}
+}
+
+// forward relays the datagram to the session of the destination
+func (assoc *udpAssociation) forward(addr net.Addr, host string, port int, data []byte) {
+	logger := assoc.server.logger
+	dest := net.JoinHostPort(host, strconv.Itoa(port))
+	key := addr.String() + "/" + dest
+	session := assoc.sessions.get(key)
+	if session == nil {
+		var err error
+		session, err = assoc.openSession(addr, host, port)
+		if err != nil {
+			logger.Error("handleUDP error: %v", err)
+			return
+		}
+		assoc.sessions.add(key, session)
+	}
+	if err := session.send(data); err != nil {
+		logger.Error("handleUDP error: %v", err)
+	}
+}
+
+// openSession opens the session to the diode device or to the fallback host
+func (assoc *udpAssociation) openSession(addr net.Addr, host string, port int) (*udpSession, error) {
+	socksServer := assoc.server
+	target := socksServer.diodeHost(net.JoinHostPort(host, strconv.Itoa(port)))
+	route, err := socksServer.route(target)
+	if err != nil {
+		return nil, err
+	}
+	switch route.Action {
+	case RouteReject:
+		return nil, fmt.Errorf("target %v rejected by route %s", target, route.Name)
+	case RouteDirect, RouteProxy:
+		remote, err := route.Dial("udp", target)
+		if err != nil {
+			return nil, err
+		}
+		remoteAddr := remote.RemoteAddr().(*net.UDPAddr)
+		session := newUDPSession(assoc.relay, addr, udpHeader(remoteAddr.IP.String(), remoteAddr.Port))
+		session.rm.Lock()
+		session.remote = remote
+		session.rm.Unlock()
+		go session.pipeFallback()
+		return session, nil
+	}
+	if h, _, err := net.SplitHostPort(route.Target(target)); err == nil {
+		host = h
+	}
+	if !isDiodeHost(host) {
+		return nil, fmt.Errorf("target not a diode host %v", host)
+	}
+	isWS, mode, deviceID, _, err := parseHost(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s %v", host, err)
+	}
+	if isWS {
+		return nil, fmt.Errorf("WS is not supported")
+	}
+	if err = socksServer.checkUserAccess(assoc.user, deviceID); err != nil {
+		return nil, err
+	}
+	session := newUDPSession(assoc.relay, addr, udpHeader(host, port))
+	socksServer.openDeviceUDP(session, deviceID, port, mode)
+	return session, nil
+}
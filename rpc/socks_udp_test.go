@@ -0,0 +1,190 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+)
+
+func TestUDPHeader(t *testing.T) {
+	tests := []struct {
+		host string
+		port int
+		size int
+	}{
+		{"127.0.0.1", 53, 10},
+		{"::1", 5353, 22},
+		{"0x937c492a77ae90de971986d003ffbc5f8bb2232c.diode", 8080, 55},
+	}
+	for _, test := range tests {
+		packet := append(udpHeader(test.host, test.port), "hello"...)
+		if len(packet) != test.size+5 {
+			t.Errorf("wrong header size %d of %s", len(packet)-5, test.host)
+		}
+		frag, host, port, data, err := parseUDPHeader(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frag != 0 || host != test.host || port != test.port || string(data) != "hello" {
+			t.Errorf("wrong header %v %s %d %s", frag, host, port, data)
+		}
+	}
+	if _, _, _, _, err := parseUDPHeader([]byte{0, 0, 0, socksAddrIPv4, 127, 0}); err != errUDPHeader {
+		t.Errorf("short header should fail but got %v", err)
+	}
+	if _, _, _, _, err := parseUDPHeader([]byte{0, 0, 0, 0x02, 0, 0}); err != errAddrType {
+		t.Errorf("unknown address type should fail but got %v", err)
+	}
+}
+
+func TestUDPReassembler(t *testing.T) {
+	var r udpReassembler
+	now := time.Now()
+
+	host, port, data, ok := r.add(0, "a.diode", 80, []byte("standalone"), now)
+	if !ok || host != "a.diode" || port != 80 || string(data) != "standalone" {
+		t.Errorf("standalone datagram should pass through")
+	}
+
+	if _, _, _, ok = r.add(1, "a.diode", 80, []byte("hello "), now); ok {
+		t.Errorf("first fragment shouldn't complete the datagram")
+	}
+	if _, _, _, ok = r.add(2, "a.diode", 80, []byte("diode "), now); ok {
+		t.Errorf("second fragment shouldn't complete the datagram")
+	}
+	host, port, data, ok = r.add(3|udpFragEnd, "a.diode", 80, []byte("world"), now)
+	if !ok || host != "a.diode" || port != 80 || string(data) != "hello diode world" {
+		t.Errorf("wrong reassembled datagram %s", data)
+	}
+
+	// a missing fragment drops the datagram
+	r.add(1, "a.diode", 80, []byte("lost"), now)
+	if _, _, _, ok = r.add(3|udpFragEnd, "a.diode", 80, []byte("lost"), now); ok {
+		t.Errorf("datagram with missing fragment should be dropped")
+	}
+
+	// a lower fragment number starts a new datagram
+	r.add(1, "a.diode", 80, []byte("old"), now)
+	r.add(2, "a.diode", 80, []byte("old"), now)
+	r.add(1, "a.diode", 80, []byte("new "), now)
+	if _, _, data, ok = r.add(2|udpFragEnd, "a.diode", 80, []byte("one"), now); !ok || string(data) != "new one" {
+		t.Errorf("queue should be reinitialized but got %s", data)
+	}
+
+	// the reassembly timer expires
+	r.add(1, "a.diode", 80, []byte("slow"), now)
+	if _, _, _, ok = r.add(2|udpFragEnd, "a.diode", 80, []byte("slow"), now.Add(udpFragTimeout+time.Second)); ok {
+		t.Errorf("expired datagram should be dropped")
+	}
+}
+
+func TestUDPSessionExpiry(t *testing.T) {
+	defer func(timeout time.Duration) { udpIdleTimeout = timeout }(udpIdleTimeout)
+	udpIdleTimeout = 100 * time.Millisecond
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	session := newUDPSession(pc, pc.LocalAddr(), nil)
+	time.Sleep(60 * time.Millisecond)
+	session.send([]byte("ping"))
+	time.Sleep(60 * time.Millisecond)
+	if session.Closed() {
+		t.Errorf("active session shouldn't expire")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if !session.Closed() {
+		t.Errorf("idle session should expire")
+	}
+	if _, err = session.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("closed session should return EOF but got %v", err)
+	}
+}
+
+func TestSocksUDPAssociate(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	config.AppConfig = testConfig()
+	socksServer := NewSocksServer(nil)
+	socksServer.SetConfig(&Config{Addr: "127.0.0.1:0", Fallback: "localhost"})
+	if err = socksServer.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer socksServer.Close()
+
+	conn, err := net.Dial("tcp", socksServer.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte{socksVer5, 1, socksAuthNone})
+	conn.Write([]byte{socksVer5, socksCmdUDP, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	reply := make([]byte, 12)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply[3] != socksRepSuccess || reply[5] != socksAddrIPv4 {
+		t.Fatalf("wrong associate reply %v", reply)
+	}
+	relayAddr := &net.UDPAddr{IP: net.IP(reply[6:10]), Port: int(binary.BigEndian.Uint16(reply[10:12]))}
+
+	client, err := net.DialUDP("udp", nil, relayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	echoAddr := echo.LocalAddr().(*net.UDPAddr)
+	header := udpHeader(echoAddr.IP.String(), echoAddr.Port)
+
+	// the datagram is sent in two fragments
+	first := append(append([]byte{}, header...), "hello "...)
+	first[2] = 1
+	second := append(append([]byte{}, header...), "udp"...)
+	second[2] = 2 | udpFragEnd
+	client.Write(first)
+	client.Write(second)
+
+	buf := make([]byte, 2048)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], append(header, "hello udp"...)) {
+		t.Errorf("wrong echo %v", buf[:n])
+	}
+
+	// closing the control connection ends the association
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+	client.Write(append(header, "closed"...))
+	client.SetDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err = client.Read(buf); err == nil {
+		t.Errorf("relay should be closed with the control connection")
+	}
+}
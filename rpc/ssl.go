@@ -411,8 +411,6 @@ func DoConnect(host string, config *config.Config, pool *DataPool) (*RPCClient,
 		ClientAddr:   config.ClientAddr,
 		RegistryAddr: config.RegistryAddr,
 		FleetAddr:    config.FleetAddr,
-		Blocklists:   config.Blocklists,
-		Allowlists:   config.Allowlists,
 	}
 	rpcClient := NewRPCClient(client, rpcConfig, pool)
 
@@ -424,6 +422,7 @@ func DoConnect(host string, config *config.Config, pool *DataPool) (*RPCClient,
 		rpcClient.metrics = NewMetrics()
 	}
 	rpcClient.Start()
+	rpcClient.publishEvent(EventNodeConnected, nil)
 
 	return &rpcClient, nil
 }
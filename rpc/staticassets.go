@@ -0,0 +1,138 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// precompressedEncodings are the content encodings of precompressed files
+// and their file extensions, in the order of preference
+var precompressedEncodings = []struct {
+	Encoding  string
+	Extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// assetHandler serves the files of the static file server with cache
+// headers, precompressed files and the index.html fallback of single page
+// apps
+type assetHandler struct {
+	sv         *StaticHTTPServer
+	fs         http.FileSystem
+	fileServer http.Handler
+}
+
+// acceptsEncoding returns true if the request accepts the content encoding
+func acceptsEncoding(req *http.Request, encoding string) bool {
+	for _, accepted := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(accepted, ";")
+		if strings.TrimSpace(parts[0]) != encoding {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// setCacheControl lets clients cache assets for the max age, html pages are
+// revalidated so new deployments are picked up right away
+func (h assetHandler) setCacheControl(w http.ResponseWriter, name string, isDir bool) {
+	if h.sv.MaxAge <= 0 {
+		return
+	}
+	if isDir || path.Ext(name) == ".html" {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.sv.MaxAge.Seconds())))
+}
+
+// open returns the file of the name, unknown paths without an extension are
+// served the index.html of single page apps
+func (h assetHandler) open(name string) (string, http.File, error) {
+	file, err := h.fs.Open(name)
+	if err != nil && os.IsNotExist(err) && h.sv.SPA && path.Ext(name) == "" {
+		name = "/index.html"
+		file, err = h.fs.Open(name)
+	}
+	return name, file, err
+}
+
+// servePrecompressed serves the .br or .gz copy of the file when the client
+// accepts it
+func (h assetHandler) servePrecompressed(w http.ResponseWriter, req *http.Request, name string) bool {
+	for _, pre := range precompressedEncodings {
+		if !acceptsEncoding(req, pre.Encoding) {
+			continue
+		}
+		file, err := h.fs.Open(name + pre.Extension)
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+		fi, err := file.Stat()
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if len(contentType) == 0 {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Encoding", pre.Encoding)
+		http.ServeContent(w, req, name, fi.ModTime(), file)
+		return true
+	}
+	return false
+}
+
+func (h assetHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := path.Clean("/" + req.URL.Path)
+	if (req.Method != "GET" && req.Method != "HEAD") || containsDotFile(name) {
+		h.fileServer.ServeHTTP(w, req)
+		return
+	}
+	name, file, err := h.open(name)
+	if err != nil {
+		h.fileServer.ServeHTTP(w, req)
+		return
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		h.fileServer.ServeHTTP(w, req)
+		return
+	}
+	h.setCacheControl(w, name, fi.IsDir())
+	// directories are redirected and listed by the file server
+	if fi.IsDir() {
+		h.fileServer.ServeHTTP(w, req)
+		return
+	}
+	if h.sv.Precompressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if h.servePrecompressed(w, req, name) {
+			return
+		}
+	}
+	http.ServeContent(w, req, name, fi.ModTime(), file)
+}
@@ -4,12 +4,14 @@
 package rpc
 
 import (
+	"crypto/tls"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // containsDotFile reports whether name contains a path element starting with a period.
@@ -63,21 +65,41 @@ func (fs staticFileSystem) Open(name string) (http.File, error) {
 }
 
 // StaticHTTPServer represents static file server
-// TODO: https static file server
 type StaticHTTPServer struct {
 	Enabled       bool
 	RootDirectory string
 	Host          string
 	Port          int
 	Indexed       bool
-	server        *http.Server
-	cd            sync.Once
+	// WebDAV enables uploads by the devices of the write allowlist
+	WebDAV         bool
+	WriteAllowlist map[Address]bool
+	MaxUploadSize  int64
+	// SPA serves the index.html for unknown paths without a file extension
+	SPA bool
+	// Precompressed serves the .br and .gz copies of files
+	Precompressed bool
+	// MaxAge is the time clients may cache files other than html pages
+	MaxAge time.Duration
+	// TLS serves https with the certificate and key files or the TLSConfig
+	TLS       bool
+	CertFile  string
+	KeyFile   string
+	TLSConfig *tls.Config
+	server    *http.Server
+	cd        sync.Once
 }
 
 // Handler returns http handler of static file server
 func (sv *StaticHTTPServer) Handler() (handler http.Handler) {
 	fs := staticFileSystem{http.Dir(sv.RootDirectory), sv.Indexed}
 	handler = http.FileServer(fs)
+	if sv.SPA || sv.Precompressed || sv.MaxAge > 0 {
+		handler = assetHandler{sv: sv, fs: fs, fileServer: handler}
+	}
+	if sv.WebDAV {
+		handler = webdavHandler{sv: sv, fileServer: handler}
+	}
 	return
 }
 
@@ -92,6 +114,9 @@ func (sv *StaticHTTPServer) Close() {
 func (sv *StaticHTTPServer) ListenAndServe() error {
 	handler := sv.Handler()
 	addr := net.JoinHostPort(sv.Host, strconv.Itoa(sv.Port))
-	sv.server = &http.Server{Addr: addr, Handler: handler}
+	sv.server = &http.Server{Addr: addr, Handler: handler, TLSConfig: sv.TLSConfig}
+	if sv.TLS {
+		return sv.server.ListenAndServeTLS(sv.CertFile, sv.KeyFile)
+	}
 	return sv.server.ListenAndServe()
 }
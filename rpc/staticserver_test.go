@@ -4,9 +4,14 @@
 package rpc
 
 import (
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 type StaticServerTest struct {
@@ -54,3 +59,64 @@ func TestStaticServer(t *testing.T) {
 		testHTTPGetStatus(t, staticHandler, st.Path, st.Status)
 	}
 }
+
+func TestStaticServerAssets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<html></html>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "app.js"), []byte("console.log(1)"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "app.js.gz"), []byte("gzipped"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "app.js.br"), []byte("brotli"), 0644)
+	staticServer := StaticHTTPServer{
+		RootDirectory: dir,
+		SPA:           true,
+		Precompressed: true,
+		MaxAge:        time.Hour,
+	}
+	handler := staticServer.Handler()
+	get := func(url string, encoding string) *http.Response {
+		req := httptest.NewRequest("GET", url, nil)
+		if len(encoding) > 0 {
+			req.Header.Set("Accept-Encoding", encoding)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	res := get("/users/42", "")
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != 200 || string(body) != "<html></html>" {
+		t.Errorf("unknown paths should serve index.html but got %d %q", res.StatusCode, body)
+	}
+	if res.Header.Get("Cache-Control") != "no-cache" {
+		t.Errorf("html pages should be revalidated but got %q", res.Header.Get("Cache-Control"))
+	}
+	testHTTPGetStatus(t, handler, "/missing.js", 404)
+	testHTTPGetStatus(t, handler, "/.env", 403)
+
+	res = get("/app.js", "gzip, br")
+	body, _ = ioutil.ReadAll(res.Body)
+	if res.Header.Get("Content-Encoding") != "br" || string(body) != "brotli" {
+		t.Errorf("brotli file should be preferred but got %q %q", res.Header.Get("Content-Encoding"), body)
+	}
+	if res.Header.Get("Cache-Control") != "public, max-age=3600" {
+		t.Errorf("assets should be cached but got %q", res.Header.Get("Cache-Control"))
+	}
+	if res.Header.Get("Content-Type") != mime.TypeByExtension(".js") {
+		t.Errorf("content type should be of the original file but got %q", res.Header.Get("Content-Type"))
+	}
+	res = get("/app.js", "gzip, br;q=0")
+	body, _ = ioutil.ReadAll(res.Body)
+	if res.Header.Get("Content-Encoding") != "gzip" || string(body) != "gzipped" {
+		t.Errorf("gzip file should be served but got %q %q", res.Header.Get("Content-Encoding"), body)
+	}
+	res = get("/app.js", "")
+	body, _ = ioutil.ReadAll(res.Body)
+	if len(res.Header.Get("Content-Encoding")) > 0 || string(body) != "console.log(1)" {
+		t.Errorf("uncompressed file should be served but got %q", body)
+	}
+}
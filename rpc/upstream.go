@@ -0,0 +1,158 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// dialUpstream connects the host through the upstream socks5, socks5h or http
+// proxy, host names are resolved locally for socks5 and by the upstream proxy
+// otherwise
+func dialUpstream(proxy *url.URL, host string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", proxy.Host, routeDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(routeDialTimeout))
+	var upstream net.Conn
+	if proxy.Scheme == "http" {
+		upstream, err = connectHTTPUpstream(conn, proxy, host)
+	} else {
+		upstream, err = conn, connectSocksUpstream(conn, proxy, host)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("upstream proxy %s: %v", proxy.Host, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return upstream, nil
+}
+
+// connectSocksUpstream sends the socks5 connect request, the username and
+// password of the proxy url are used for RFC 1929 authentication
+func connectSocksUpstream(conn net.Conn, proxy *url.URL, host string) error {
+	name, portStr, err := net.SplitHostPort(host)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
+	}
+	if proxy.Scheme == "socks5" && net.ParseIP(name) == nil {
+		addr, err := net.ResolveIPAddr("ip", name)
+		if err != nil {
+			return err
+		}
+		name = addr.IP.String()
+	}
+	greeting := []byte{socksVer5, 1, socksAuthNone}
+	if proxy.User != nil {
+		greeting = []byte{socksVer5, 2, socksAuthNone, socksAuthPassword}
+	}
+	if _, err = conn.Write(greeting); err != nil {
+		return err
+	}
+	buf := make([]byte, 262)
+	if _, err = io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != socksVer5 {
+		return errVer
+	}
+	switch buf[1] {
+	case socksAuthNone:
+	case socksAuthPassword:
+		if proxy.User == nil {
+			return errSocksAuthMethod
+		}
+		user := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(user) > 255 || len(password) > 255 {
+			return errSocksAuthFailed
+		}
+		req := append([]byte{socksAuthVer, byte(len(user))}, user...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err = conn.Write(req); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, buf[:2]); err != nil {
+			return err
+		}
+		if buf[1] != socksAuthSuccess {
+			return errSocksAuthFailed
+		}
+	default:
+		return fmt.Errorf("no acceptable socks authentication method")
+	}
+	if len(name) > 255 {
+		return errAddrType
+	}
+	// the connect request has the address format of the udp header
+	req := append([]byte{socksVer5, socksCmdConnect, 0}, udpHeader(name, port)[3:]...)
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+	// some servers close the connection after the reply code of errors
+	if _, err = io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[1] != socksRepSuccess {
+		return fmt.Errorf("socks connect failed with reply %d", buf[1])
+	}
+	if _, err = io.ReadFull(conn, buf[2:4]); err != nil {
+		return err
+	}
+	var addrLen int
+	switch buf[3] {
+	case socksAddrIPv4:
+		addrLen = net.IPv4len
+	case socksAddrIPv6:
+		addrLen = net.IPv6len
+	case socksAddrDomain:
+		if _, err = io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		addrLen = int(buf[0])
+	default:
+		return errAddrType
+	}
+	_, err = io.ReadFull(conn, buf[:addrLen+2])
+	return err
+}
+
+// connectHTTPUpstream tunnels the connection with a CONNECT request, data
+// that the proxy sent after the reply is read first from the returned conn
+func connectHTTPUpstream(conn net.Conn, proxy *url.URL, host string) (net.Conn, error) {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", host, host)
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	if _, err := conn.Write([]byte(req + "\r\n")); err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT failed: %s", res.Status)
+	}
+	unread := make([]byte, reader.Buffered())
+	reader.Read(unread)
+	return NewHTTPConn(unread, conn), nil
+}
@@ -0,0 +1,108 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/diodechain/diode_go_client/config"
+	"github.com/diodechain/diode_go_client/edge"
+)
+
+const (
+	// vhostSniffTimeout is how long to wait for the first message of a
+	// virtual host tunnel
+	vhostSniffTimeout  = 10 * time.Second
+	tlsRecordHandshake = 0x16
+)
+
+var (
+	errServerNameSniffed = fmt.Errorf("server name was sniffed")
+)
+
+// sniffConn is a read only net.Conn to parse a tls client hello
+type sniffConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c sniffConn) Read(buf []byte) (int, error) {
+	return c.reader.Read(buf)
+}
+
+func (c sniffConn) Write(buf []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// tlsServerName returns the server name of the tls client hello
+func tlsServerName(conn net.Conn, reader io.Reader) string {
+	var name string
+	tls.Server(sniffConn{Conn: conn, reader: reader}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			name = hello.ServerName
+			return nil, errServerNameSniffed
+		},
+	}).Handshake()
+	return name
+}
+
+// sniffServerName returns the tls server name or the http host of the first
+// message of the connection and the data that was read to find it
+func sniffServerName(conn net.Conn) (name string, data []byte, err error) {
+	var buf bytes.Buffer
+	reader := bufio.NewReader(io.TeeReader(conn, &buf))
+	first, err := reader.Peek(1)
+	if err != nil {
+		return
+	}
+	if first[0] == tlsRecordHandshake {
+		name = tlsServerName(conn, reader)
+	} else if req, err := http.ReadRequest(reader); err == nil {
+		name = req.Host
+	}
+	return name, buf.Bytes(), nil
+}
+
+// newVirtualHostConn returns a connection that is bridged to the virtual
+// host of the port that matches the server name of the first message
+func (rpcClient *RPCClient) newVirtualHostConn(port *config.Port, portOpen *edge.PortOpen) net.Conn {
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		remote.SetReadDeadline(time.Now().Add(vhostSniffTimeout))
+		name, data, err := sniffServerName(remote)
+		if err != nil {
+			// the default service might speak first
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() || len(data) > 0 {
+				rpcClient.Debug("Failed to read virtual host of port %d: %v", port.To, err)
+				return
+			}
+		}
+		remote.SetReadDeadline(time.Time{})
+		hostPort := port.HostPort(name)
+		conn, err := rpcClient.connectPort(hostPort, portOpen)
+		if err != nil {
+			rpcClient.Error("Failed to connect virtual host %s of port %d: %v", name, port.To, err)
+			return
+		}
+		defer conn.Close()
+		_, err = conn.Write(data)
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(conn, remote)
+			conn.Close()
+		}()
+		io.Copy(remote, conn)
+	}()
+	return local
+}
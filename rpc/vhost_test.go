@@ -0,0 +1,70 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSniffServerNameHTTP(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	request := "GET / HTTP/1.1\r\nHost: blog.example.com:8080\r\n\r\n"
+	go local.Write([]byte(request))
+	remote.SetDeadline(time.Now().Add(5 * time.Second))
+	name, data, err := sniffServerName(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "blog.example.com:8080" {
+		t.Errorf("wrong host %s", name)
+	}
+	if string(data) != request {
+		t.Errorf("the read data should be returned but got %q", data)
+	}
+}
+
+func TestSniffServerNameTLS(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go tls.Client(local, &tls.Config{ServerName: "shop.example.com"}).Handshake()
+	remote.SetDeadline(time.Now().Add(5 * time.Second))
+	name, data, err := sniffServerName(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "shop.example.com" {
+		t.Errorf("wrong server name %s", name)
+	}
+	if len(data) == 0 || data[0] != tlsRecordHandshake {
+		t.Errorf("the client hello should be returned")
+	}
+}
+
+func TestSniffServerNameUnknown(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go func() {
+		local.Write([]byte("SSH-2.0-OpenSSH_8.2\r\n"))
+		local.Close()
+	}()
+	remote.SetDeadline(time.Now().Add(5 * time.Second))
+	name, data, err := sniffServerName(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(name) > 0 {
+		t.Errorf("there should be no server name but got %s", name)
+	}
+	if !strings.HasPrefix(string(data), "SSH-2.0") {
+		t.Errorf("the read data should be returned but got %q", data)
+	}
+}
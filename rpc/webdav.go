@@ -0,0 +1,338 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultMaxUploadSize is the default size limit of webdav uploads
+	DefaultMaxUploadSize = 100 << 20
+	webdavMethods        = "OPTIONS, GET, HEAD, PUT, DELETE, MKCOL, MOVE, PROPFIND"
+)
+
+var (
+	errWebDAVForbidden = fmt.Errorf("forbidden path")
+)
+
+// webdavHandler serves reads with the static file server and implements the
+// writing methods of WebDAV class 1 without locks. Writes are only accepted
+// from devices on the write allowlist, the device is taken from requests of
+// http published ports that the bridge serves in process.
+type webdavHandler struct {
+	sv         *StaticHTTPServer
+	fileServer http.Handler
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection"`
+}
+
+type davProp struct {
+	DisplayName      string          `xml:"D:displayname"`
+	ResourceType     davResourceType `xml:"D:resourcetype"`
+	GetContentLength *int64          `xml:"D:getcontentlength,omitempty"`
+	GetContentType   string          `xml:"D:getcontenttype,omitempty"`
+	GetLastModified  string          `xml:"D:getlastmodified"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	XMLNS     string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+// resolve returns the file path of the url path, paths with dot files are
+// forbidden
+func (h webdavHandler) resolve(name string) (string, error) {
+	name = path.Clean("/" + name)
+	if containsDotFile(name) {
+		return "", errWebDAVForbidden
+	}
+	root := h.sv.RootDirectory
+	if len(root) == 0 {
+		root = "."
+	}
+	return filepath.Join(root, filepath.FromSlash(name)), nil
+}
+
+// canWrite returns true if the calling device is on the write allowlist,
+// only requests that were served through the bridge have a trusted device
+func (h webdavHandler) canWrite(req *http.Request) bool {
+	device, ok := RequestDevice(req)
+	if !ok {
+		return false
+	}
+	return h.sv.WriteAllowlist[device]
+}
+
+func (h webdavHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET", "HEAD", "POST":
+		h.fileServer.ServeHTTP(w, req)
+		return
+	case "OPTIONS":
+		w.Header().Set("Allow", webdavMethods)
+		w.Header().Set("DAV", "1")
+		w.Header().Set("MS-Author-Via", "DAV")
+		w.WriteHeader(http.StatusOK)
+		return
+	case "PROPFIND":
+		h.propfind(w, req)
+		return
+	case "PUT", "DELETE", "MKCOL", "MOVE":
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.canWrite(req) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	name, err := h.resolve(req.URL.Path)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	var status int
+	switch req.Method {
+	case "PUT":
+		status = h.put(w, req, name)
+	case "DELETE":
+		status = h.delete(req, name)
+	case "MKCOL":
+		status = h.mkcol(req, name)
+	case "MOVE":
+		status = h.move(req, name)
+	}
+	if status >= 300 {
+		http.Error(w, http.StatusText(status), status)
+	} else if status != 0 {
+		w.WriteHeader(status)
+	}
+}
+
+// writeStatus converts file system errors to http status codes
+func writeStatus(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusConflict
+	case os.IsPermission(err):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (h webdavHandler) put(w http.ResponseWriter, req *http.Request, name string) int {
+	maxSize := h.sv.MaxUploadSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxUploadSize
+	}
+	if req.ContentLength > maxSize {
+		return http.StatusRequestEntityTooLarge
+	}
+	fi, err := os.Stat(name)
+	exists := err == nil
+	if exists && fi.IsDir() {
+		return http.StatusMethodNotAllowed
+	}
+	// the upload is written to a hidden file first, so partial uploads are
+	// never served
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".upload-")
+	if err != nil {
+		return writeStatus(err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, http.MaxBytesReader(w, req.Body, maxSize))
+	closeErr := tmp.Close()
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			return http.StatusRequestEntityTooLarge
+		}
+		return http.StatusBadRequest
+	}
+	if closeErr != nil {
+		return writeStatus(closeErr)
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return writeStatus(err)
+	}
+	if err = os.Rename(tmp.Name(), name); err != nil {
+		return writeStatus(err)
+	}
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	return 0
+}
+
+func (h webdavHandler) delete(req *http.Request, name string) int {
+	if path.Clean("/"+req.URL.Path) == "/" {
+		return http.StatusForbidden
+	}
+	if _, err := os.Stat(name); err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusNotFound
+		}
+		return writeStatus(err)
+	}
+	if err := os.RemoveAll(name); err != nil {
+		return writeStatus(err)
+	}
+	return http.StatusNoContent
+}
+
+func (h webdavHandler) mkcol(req *http.Request, name string) int {
+	if req.ContentLength > 0 {
+		return http.StatusUnsupportedMediaType
+	}
+	if _, err := os.Stat(name); err == nil {
+		return http.StatusMethodNotAllowed
+	}
+	if err := os.Mkdir(name, 0755); err != nil {
+		return writeStatus(err)
+	}
+	return http.StatusCreated
+}
+
+func (h webdavHandler) move(req *http.Request, name string) int {
+	if path.Clean("/"+req.URL.Path) == "/" {
+		return http.StatusForbidden
+	}
+	dest, err := url.Parse(req.Header.Get("Destination"))
+	if err != nil || len(dest.Path) == 0 {
+		return http.StatusBadRequest
+	}
+	if len(dest.Host) > 0 && dest.Host != req.Host {
+		return http.StatusBadGateway
+	}
+	destName, err := h.resolve(dest.Path)
+	if err != nil {
+		return http.StatusForbidden
+	}
+	if _, err = os.Stat(name); err != nil {
+		if os.IsNotExist(err) {
+			return http.StatusNotFound
+		}
+		return writeStatus(err)
+	}
+	if destName == name {
+		return http.StatusForbidden
+	}
+	_, err = os.Stat(destName)
+	exists := err == nil
+	if exists {
+		if req.Header.Get("Overwrite") == "F" {
+			return http.StatusPreconditionFailed
+		}
+		if err = os.RemoveAll(destName); err != nil {
+			return writeStatus(err)
+		}
+	}
+	if err = os.Rename(name, destName); err != nil {
+		return writeStatus(err)
+	}
+	if exists {
+		return http.StatusNoContent
+	}
+	return http.StatusCreated
+}
+
+func davEntry(href string, fi os.FileInfo) davResponse {
+	prop := davProp{
+		DisplayName:     fi.Name(),
+		GetLastModified: fi.ModTime().UTC().Format(http.TimeFormat),
+	}
+	if fi.IsDir() {
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		size := fi.Size()
+		prop.GetContentLength = &size
+		prop.GetContentType = mime.TypeByExtension(filepath.Ext(fi.Name()))
+	}
+	return davResponse{
+		Href: (&url.URL{Path: href}).EscapedPath(),
+		Propstat: davPropstat{
+			Prop:   prop,
+			Status: "HTTP/1.1 200 OK",
+		},
+	}
+}
+
+// propfind returns all properties of the resource and with depth 1 of its
+// members, dot files are hidden
+func (h webdavHandler) propfind(w http.ResponseWriter, req *http.Request) {
+	name, err := h.resolve(req.URL.Path)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		http.Error(w, http.StatusText(writeStatus(err)), writeStatus(err))
+		return
+	}
+	href := path.Clean("/" + req.URL.Path)
+	if fi.IsDir() && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
+	status := davMultistatus{
+		XMLNS:     "DAV:",
+		Responses: []davResponse{davEntry(href, fi)},
+	}
+	if fi.IsDir() && req.Header.Get("Depth") != "0" {
+		files, err := ioutil.ReadDir(name)
+		if err != nil {
+			http.Error(w, http.StatusText(writeStatus(err)), writeStatus(err))
+			return
+		}
+		for _, file := range files {
+			if strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+			member := href + file.Name()
+			if file.IsDir() {
+				member += "/"
+			}
+			status.Responses = append(status.Responses, davEntry(member, file))
+		}
+	}
+	res, err := xml.Marshal(status)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(xml.Header))
+	w.Write(res)
+}
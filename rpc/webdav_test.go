@@ -0,0 +1,115 @@
+// Diode Network Client
+// Copyright 2019 IoT Blockchain Technology Corporation LLC (IBTC)
+// Licensed under the Diode License, Version 1.0
+package rpc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diodechain/diode_go_client/util"
+)
+
+type webdavTest struct {
+	Method string
+	Path   string
+	Device string
+	Body   string
+	Header map[string]string
+	Status int
+}
+
+func TestWebDAV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webdav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writer := Address{1}
+	staticServer := StaticHTTPServer{
+		RootDirectory:  dir,
+		WebDAV:         true,
+		WriteAllowlist: map[Address]bool{writer: true},
+		MaxUploadSize:  16,
+	}
+	handler := staticServer.Handler()
+	allowed := writer.HexString()
+	other := Address{2}
+	tests := []webdavTest{
+		{Method: "PUT", Path: "/notes.txt", Body: "hello", Status: 403},
+		{Method: "PUT", Path: "/notes.txt", Device: other.HexString(), Body: "hello", Status: 403},
+		{Method: "PUT", Path: "/notes.txt", Body: "hello", Header: map[string]string{DiodeDeviceHeader: allowed}, Status: 403},
+		{Method: "PUT", Path: "/notes.txt", Device: allowed, Body: "hello", Status: 201},
+		{Method: "PUT", Path: "/notes.txt", Device: allowed, Body: "hello again", Status: 204},
+		{Method: "PUT", Path: "/.hidden", Device: allowed, Body: "hello", Status: 403},
+		{Method: "PUT", Path: "/large.txt", Device: allowed, Body: strings.Repeat("x", 17), Status: 413},
+		{Method: "PUT", Path: "/missing/notes.txt", Device: allowed, Body: "hello", Status: 409},
+		{Method: "MKCOL", Path: "/docs", Device: allowed, Status: 201},
+		{Method: "MKCOL", Path: "/docs", Device: allowed, Status: 405},
+		{Method: "MOVE", Path: "/notes.txt", Device: allowed, Header: map[string]string{"Destination": "http://example.com/docs/.notes.txt"}, Status: 403},
+		{Method: "MOVE", Path: "/notes.txt", Device: allowed, Header: map[string]string{"Destination": "http://example.com/docs/notes.txt"}, Status: 201},
+		{Method: "GET", Path: "/docs/notes.txt", Status: 200},
+		{Method: "PROPFIND", Path: "/docs", Header: map[string]string{"Depth": "1"}, Status: 207},
+		{Method: "DELETE", Path: "/docs/notes.txt", Status: 403},
+		{Method: "DELETE", Path: "/docs/notes.txt", Device: allowed, Status: 204},
+		{Method: "DELETE", Path: "/docs/notes.txt", Device: allowed, Status: 404},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.Method, "http://example.com"+test.Path, strings.NewReader(test.Body))
+		for key, value := range test.Header {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		if len(test.Device) > 0 {
+			// the bridge serves the requests of devices in process
+			device, _ := util.DecodeAddress(test.Device)
+			identity := http.Header{}
+			identity.Set(DiodeDeviceHeader, test.Device)
+			newIdentityHandler(handler, device, identity).ServeHTTP(w, req)
+		} else {
+			handler.ServeHTTP(w, req)
+		}
+		if w.Code != test.Status {
+			t.Fatalf("%s %s want status %d but got %d", test.Method, test.Path, test.Status, w.Code)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "large.txt")); !os.IsNotExist(err) {
+		t.Errorf("too large uploads shouldn't be saved")
+	}
+	files, _ := ioutil.ReadDir(dir)
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".") {
+			t.Errorf("temporary upload %s should be removed", file.Name())
+		}
+	}
+}
+
+func TestWebDAVPropfind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webdav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("hello"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".env"), []byte("secret"), 0644)
+	staticServer := StaticHTTPServer{RootDirectory: dir, WebDAV: true}
+	req := httptest.NewRequest("PROPFIND", "/", nil)
+	req.Header.Set("Depth", "1")
+	w := httptest.NewRecorder()
+	staticServer.Handler().ServeHTTP(w, req)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("want status 207 but got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "<D:href>/index.html</D:href>") || !strings.Contains(body, "<D:getcontentlength>5</D:getcontentlength>") {
+		t.Errorf("index.html should be listed: %s", body)
+	}
+	if strings.Contains(body, ".env") {
+		t.Errorf("dot files should be hidden: %s", body)
+	}
+}